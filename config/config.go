@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 //MysqlConfig mysql信息配置
 type MysqlConfig struct {
 	Host     string `mapstructure:"host" json:"host"`
@@ -14,8 +16,44 @@ type RedisConfig struct {
 	Port int    `mapstructure:"port" json:"port"`
 }
 
+// KafkaConfig 跨网关路由使用的 Kafka 配置
+type KafkaConfig struct {
+	Brokers    []string `mapstructure:"brokers" json:"brokers"`
+	Topic      string   `mapstructure:"topic" json:"topic"`
+	Partitions int      `mapstructure:"partitions" json:"partitions"` // 必须与 topic 实际 partition 数一致
+}
+
+// GatewayConfig 本进程启动的网关节点
+type GatewayConfig struct {
+	ID   string `mapstructure:"id" json:"id"`
+	Port int    `mapstructure:"port" json:"port"`
+}
+
+// WebSocketConfig 网关 WebSocket 握手配置
+type WebSocketConfig struct {
+	AllowedOrigins []string `mapstructure:"allowed_origins" json:"allowed_origins"` // 为空时只允许同源，"*" 允许所有
+}
+
+// SessionConfig 多端登录策略
+type SessionConfig struct {
+	// MaxPerPlatform 同一平台允许的并发会话数，超出时踢掉最早的会话；未配置或 0 表示不限制
+	MaxPerPlatform map[string]int `mapstructure:"max_per_platform" json:"max_per_platform"`
+}
+
+// MessageConfig 消息操作相关配置
+type MessageConfig struct {
+	RecallWindow time.Duration            `mapstructure:"recall_window" json:"recall_window"` // 允许撤回的时间窗口，如 2m
+	Retention    map[string]time.Duration `mapstructure:"retention" json:"retention"`         // 冷数据按会话类型（private / group）的保留时间，未配置则永久保留
+}
+
 type ServiceConfig struct {
-	Port    int         `mapstructure:"port" json:"port"`
-	DB      MysqlConfig `mapstructure:"mysql" json:"mysql"`
-	RedisDB RedisConfig `mapstructure:"redis" json:"redis"`
+	Port      int             `mapstructure:"port" json:"port"`
+	AdminAddr string          `mapstructure:"admin_addr" json:"admin_addr"` // 内部管理接口监听地址，为空时不启动
+	DB        MysqlConfig     `mapstructure:"mysql" json:"mysql"`
+	RedisDB   RedisConfig     `mapstructure:"redis" json:"redis"`
+	Kafka     KafkaConfig     `mapstructure:"kafka" json:"kafka"`
+	Gateways  []GatewayConfig `mapstructure:"gateways" json:"gateways"`
+	WebSocket WebSocketConfig `mapstructure:"websocket" json:"websocket"`
+	Session   SessionConfig   `mapstructure:"session" json:"session"`
+	Message   MessageConfig   `mapstructure:"message" json:"message"`
 }
@@ -0,0 +1,79 @@
+package dao
+
+import (
+	"HiChat/global"
+	"HiChat/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// BlockUser 拉黑用户，同时拒绝对方发来的待处理好友申请；返回码与 AddFriend 一致
+func BlockUser(userID, targetId uint) (int, error) {
+	if userID == targetId {
+		return -2, errors.New("userID和TargetId相等")
+	}
+	if _, err := FindUserID(targetId); err != nil {
+		return -1, errors.New("未查询到用户")
+	}
+	blocked, err := IsBlocked(userID, targetId)
+	if err != nil {
+		return -1, err
+	}
+	if blocked {
+		return 0, errors.New("已经拉黑该用户")
+	}
+
+	err = global.DB.Transaction(func(tx *gorm.DB) error {
+		relation := models.Relation{OwnerId: userID, TargetID: targetId, Type: 3}
+		if t := tx.Create(&relation); t.Error != nil {
+			return t.Error
+		}
+		return tx.Model(&models.FriendRequest{}).
+			Where("from_id = ? and to_id = ? and status = ?", targetId, userID, models.FriendRequestPending).
+			Update("status", models.FriendRequestRejected).Error
+	})
+	if err != nil {
+		return -1, errors.New("拉黑失败")
+	}
+	return 1, nil
+}
+
+// UnblockUser 取消拉黑
+func UnblockUser(userID, targetId uint) error {
+	return global.DB.Where("owner_id = ? and target_id = ? and type = 3", userID, targetId).Delete(&models.Relation{}).Error
+}
+
+// BlockList 获取黑名单
+func BlockList(userId uint) ([]models.UserBasic, error) {
+	relation := make([]models.Relation, 0)
+	if tx := global.DB.Where("owner_id = ? and type = 3", userId).Find(&relation); tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	ids := make([]uint, 0, len(relation))
+	for _, v := range relation {
+		ids = append(ids, v.TargetID)
+	}
+	return FindUsersByIDs(ids)
+}
+
+// IsBlocked userID 是否拉黑了 targetId
+func IsBlocked(userID, targetId uint) (bool, error) {
+	var n int64
+	if tx := global.DB.Model(&models.Relation{}).Where("owner_id = ? and target_id = ? and type = 3", userID, targetId).Count(&n); tx.Error != nil {
+		return false, tx.Error
+	}
+	return n > 0, nil
+}
+
+// BlockedBetween 两人之间是否存在任一方向的拉黑
+func BlockedBetween(a, b uint) (bool, error) {
+	var n int64
+	if tx := global.DB.Model(&models.Relation{}).
+		Where("type = 3 and ((owner_id = ? and target_id = ?) or (owner_id = ? and target_id = ?))", a, b, b, a).
+		Count(&n); tx.Error != nil {
+		return false, tx.Error
+	}
+	return n > 0, nil
+}
@@ -28,6 +28,7 @@ func CreateCommunity(community models.Community) (int, error) {
 	relation.OwnerId = community.OwnerId //群主id
 	relation.TargetID = community.ID     //群id
 	relation.Type = 2                    //群
+	relation.Role = models.RoleOwner
 	if t := tx.Create(&relation); t.RowsAffected == 0 {
 		tx.Rollback()
 		return -1, errors.New("群记录创建失败")
@@ -61,7 +62,7 @@ func GetCommunityList(ownerId uint) (*[]models.Community, error) {
 	return &community, nil
 }
 
-// JoinCommunity 根据群昵称搜索并加入群
+// JoinCommunity 根据群昵称搜索并加入群；群开启审批时提交加群申请并返回 1
 func JoinCommunity(ownerId uint, cname string) (int, error) {
 	community := models.Community{}
 	if tx := global.DB.Where("name = ?", cname).First(&community); tx.RowsAffected == 0 {
@@ -74,6 +75,13 @@ func JoinCommunity(ownerId uint, cname string) (int, error) {
 		return -1, errors.New("该群已经加入")
 	}
 
+	if community.JoinApproval {
+		if err := createJoinRequest(community.ID, ownerId); err != nil {
+			return -1, err
+		}
+		return 1, nil
+	}
+
 	relation = models.Relation{}
 	relation.OwnerId = ownerId
 	relation.TargetID = community.ID
@@ -86,26 +94,99 @@ func JoinCommunity(ownerId uint, cname string) (int, error) {
 	return 0, nil
 }
 
-func IsUserInGroup(groupID, from string) (bool, error) {
+// GroupMembership 获取用户的群成员关系，不在群中时返回 nil；在群中的结果会被缓存
+func GroupMembership(groupID, userID string) (*models.Relation, error) {
 	// 将字符串转换为 uint
 	gid, err := strconv.ParseUint(groupID, 10, 32)
 	if err != nil {
-		return false, errors.New("群组ID格式无效")
+		return nil, errors.New("群组ID格式无效")
 	}
 
-	uid, err := strconv.ParseUint(from, 10, 32)
+	uid, err := strconv.ParseUint(userID, 10, 32)
 	if err != nil {
-		return false, errors.New("用户ID格式无效")
+		return nil, errors.New("用户ID格式无效")
+	}
+	// 缓存以规范化后的 ID 为 key，与 InvalidateMembership 一致
+	groupID, userID = strconv.FormatUint(gid, 10), strconv.FormatUint(uid, 10)
+	if relation, ok := cachedMembership(groupID, userID); ok {
+		return relation, nil
 	}
+	gen := membershipGeneration(groupID)
 
 	var relation models.Relation
 	tx := global.DB.Where("owner_id = ? AND target_id = ? AND type = 2", uint(uid), uint(gid)).First(&relation)
 	if tx.Error != nil {
 		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-			return false, nil // 没有找到记录，说明用户不在群中
+			return nil, nil // 没有找到记录，说明用户不在群中
 		}
-		return false, tx.Error // 其他数据库错误
+		return nil, tx.Error // 其他数据库错误
+	}
+	cacheMembership(groupID, userID, gen, &relation)
+	return &relation, nil
+}
+
+func IsUserInGroup(groupID, from string) (bool, error) {
+	relation, err := GroupMembership(groupID, from)
+	return relation != nil, err
+}
+
+// GroupOwner 获取群主 id
+func GroupOwner(groupID string) (uint, error) {
+	gid, err := strconv.ParseUint(groupID, 10, 32)
+	if err != nil {
+		return 0, errors.New("群组ID格式无效")
+	}
+
+	community := models.Community{}
+	if tx := global.DB.Select("owner_id").Where("id = ?", uint(gid)).First(&community); tx.Error != nil {
+		return 0, tx.Error
+	}
+	return community.OwnerId, nil
+}
+
+// FindCommunitiesByIDs 批量获取群信息
+func FindCommunitiesByIDs(ids []uint) ([]models.Community, error) {
+	communities := make([]models.Community, 0, len(ids))
+	if len(ids) == 0 {
+		return communities, nil
+	}
+	if tx := global.DB.Where("id in ?", ids).Find(&communities); tx.Error != nil {
+		return nil, tx.Error
+	}
+	return communities, nil
+}
+
+// InviteToGroup 群成员把其他用户拉进群，遵循被邀请人的隐私设置和黑名单
+func InviteToGroup(inviter, invitee, groupId uint) (int, error) {
+	var n int64
+	if tx := global.DB.Model(&models.Relation{}).Where("owner_id = ? and target_id = ? and type = 2", inviter, groupId).Count(&n); tx.Error != nil {
+		return -1, tx.Error
+	}
+	if n == 0 {
+		return -1, errors.New("你不在该群中")
+	}
+
+	if _, err := FindUserID(invitee); err != nil {
+		return -1, errors.New("未查询到用户")
+	}
+	ok, err := CanInviteToGroup(inviter, invitee)
+	if err != nil {
+		return -1, err
+	}
+	if !ok {
+		return -1, errors.New("对方的隐私设置不允许被拉进群")
+	}
+
+	if tx := global.DB.Model(&models.Relation{}).Where("owner_id = ? and target_id = ? and type = 2", invitee, groupId).Count(&n); tx.Error != nil {
+		return -1, tx.Error
+	}
+	if n > 0 {
+		return -1, errors.New("对方已在群中")
 	}
 
-	return true, nil // 找到了，用户在群中
+	relation := models.Relation{OwnerId: invitee, TargetID: groupId, Type: 2}
+	if tx := global.DB.Create(&relation); tx.RowsAffected == 0 {
+		return -1, errors.New("加入失败")
+	}
+	return 0, nil
 }
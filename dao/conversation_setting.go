@@ -0,0 +1,42 @@
+package dao
+
+import (
+	"HiChat/global"
+	"HiChat/models"
+
+	"gorm.io/gorm/clause"
+)
+
+// ConversationSettings 批量获取用户在若干会话上的设置，未设置过的会话不在结果中
+func ConversationSettings(ownerId uint, convIDs []string) (map[string]models.ConversationSetting, error) {
+	result := make(map[string]models.ConversationSetting, len(convIDs))
+	if len(convIDs) == 0 {
+		return result, nil
+	}
+
+	settings := make([]models.ConversationSetting, 0, len(convIDs))
+	if tx := global.DB.Where("owner_id = ? and conversation_id in ?", ownerId, convIDs).Find(&settings); tx.Error != nil {
+		return nil, tx.Error
+	}
+	for _, s := range settings {
+		result[s.ConversationID] = s
+	}
+	return result, nil
+}
+
+// PinnedOrArchived 获取用户置顶或归档的会话
+func PinnedOrArchived(ownerId uint) ([]models.ConversationSetting, error) {
+	settings := make([]models.ConversationSetting, 0)
+	if tx := global.DB.Where("owner_id = ? and (pinned = ? or archived = ?)", ownerId, true, true).Find(&settings); tx.Error != nil {
+		return nil, tx.Error
+	}
+	return settings, nil
+}
+
+// SaveConversationSetting 新建或覆盖用户在某会话上的设置
+func SaveConversationSetting(setting *models.ConversationSetting) error {
+	return global.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "owner_id"}, {Name: "conversation_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"pinned", "muted_until", "archived", "updated_at"}),
+	}).Create(setting).Error
+}
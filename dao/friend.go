@@ -0,0 +1,92 @@
+package dao
+
+import (
+	"HiChat/global"
+	"HiChat/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// FriendInfo 好友列表中的一项，附带自己设置的备注和标签
+type FriendInfo struct {
+	models.UserBasic
+	Remark string
+	Tags   []string
+}
+
+// DeleteFriend 删除好友，双方的好友关系和各自设置的标签在同一事务中删除
+func DeleteFriend(userID, targetId uint) error {
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		t := tx.Where("type = 1 and ((owner_id = ? and target_id = ?) or (owner_id = ? and target_id = ?))", userID, targetId, targetId, userID).
+			Delete(&models.Relation{})
+		if t.Error != nil {
+			return t.Error
+		}
+		if t.RowsAffected == 0 {
+			return errors.New("好友不存在")
+		}
+		return tx.Unscoped().
+			Where("(owner_id = ? and friend_id = ?) or (owner_id = ? and friend_id = ?)", userID, targetId, targetId, userID).
+			Delete(&models.FriendTag{}).Error
+	})
+}
+
+// SetFriendRemark 设置好友备注，remark 为空时清除
+func SetFriendRemark(userID, targetId uint, remark string) error {
+	tx := global.DB.Model(&models.Relation{}).
+		Where("owner_id = ? and target_id = ? and type = 1", userID, targetId).
+		Update("desc", remark)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		// 备注未变化时 RowsAffected 同样为 0，需要再确认好友关系
+		friend, err := IsFriend(userID, targetId)
+		if err != nil {
+			return err
+		}
+		if !friend {
+			return errors.New("好友不存在")
+		}
+	}
+	return nil
+}
+
+// SetFriendTags 覆盖设置某个好友的标签，tags 为空时清除
+func SetFriendTags(userID, targetId uint, tags []string) error {
+	friend, err := IsFriend(userID, targetId)
+	if err != nil {
+		return err
+	}
+	if !friend {
+		return errors.New("好友不存在")
+	}
+
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		if t := tx.Unscoped().Where("owner_id = ? and friend_id = ?", userID, targetId).Delete(&models.FriendTag{}); t.Error != nil {
+			return t.Error
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		rows := make([]models.FriendTag, 0, len(tags))
+		for _, tag := range tags {
+			rows = append(rows, models.FriendTag{OwnerId: userID, FriendId: targetId, Tag: tag})
+		}
+		return tx.Create(&rows).Error
+	})
+}
+
+// FriendTags 获取用户给所有好友设置的标签：好友ID -> 标签
+func FriendTags(userID uint) (map[uint][]string, error) {
+	rows := make([]models.FriendTag, 0)
+	if tx := global.DB.Where("owner_id = ?", userID).Order("id").Find(&rows); tx.Error != nil {
+		return nil, tx.Error
+	}
+	tags := make(map[uint][]string)
+	for _, r := range rows {
+		tags[r.FriendId] = append(tags[r.FriendId], r.Tag)
+	}
+	return tags, nil
+}
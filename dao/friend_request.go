@@ -0,0 +1,154 @@
+package dao
+
+import (
+	"HiChat/global"
+	"HiChat/models"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IsFriend 判断两人是否已是好友
+func IsFriend(userID, targetID uint) (bool, error) {
+	var n int64
+	if tx := global.DB.Model(&models.Relation{}).Where("owner_id = ? and target_id = ? and type = 1", userID, targetID).Count(&n); tx.Error != nil {
+		return false, tx.Error
+	}
+	return n > 0, nil
+}
+
+// SendFriendRequest 发起好友申请，返回码与 AddFriend 一致。
+// 对方已向自己发起待处理的申请时直接同意；重复申请只刷新验证消息和有效期
+func SendFriendRequest(userID, targetId uint, greeting string) (*models.FriendRequest, int, error) {
+	if userID == targetId {
+		return nil, -2, errors.New("userID和TargetId相等")
+	}
+	if _, err := FindUserID(targetId); err != nil {
+		return nil, -1, errors.New("未查询到用户")
+	}
+	friend, err := IsFriend(userID, targetId)
+	if err != nil {
+		return nil, -1, err
+	}
+	if friend {
+		return nil, 0, errors.New("好友已经存在")
+	}
+	blocked, err := IsBlocked(userID, targetId)
+	if err != nil {
+		return nil, -1, err
+	}
+	if blocked {
+		return nil, -1, errors.New("请先将对方移出黑名单")
+	}
+	// 被对方拉黑时与用户不存在的提示一致
+	if blocked, err = IsBlocked(targetId, userID); err != nil {
+		return nil, -1, err
+	}
+	if blocked {
+		return nil, -1, errors.New("未查询到用户")
+	}
+
+	now := time.Now()
+	reverse := models.FriendRequest{}
+	if tx := global.DB.Where("from_id = ? and to_id = ? and status = ?", targetId, userID, models.FriendRequestPending).Order("id desc").Limit(1).Find(&reverse); tx.RowsAffected == 1 && !reverse.Expired(now) {
+		req, err := HandleFriendRequest(reverse.ID, userID, true)
+		if err != nil {
+			return nil, -1, err
+		}
+		return req, 1, nil
+	}
+
+	req := models.FriendRequest{}
+	if tx := global.DB.Where("from_id = ? and to_id = ? and status = ?", userID, targetId, models.FriendRequestPending).Order("id desc").Limit(1).Find(&req); tx.RowsAffected == 1 {
+		req.Greeting = greeting
+		req.CreatedAt = now
+		if tx := global.DB.Save(&req); tx.Error != nil {
+			return nil, -1, errors.New("发送好友申请失败")
+		}
+		return &req, 1, nil
+	}
+
+	req = models.FriendRequest{
+		FromId:   userID,
+		ToId:     targetId,
+		Status:   models.FriendRequestPending,
+		Greeting: greeting,
+	}
+	if tx := global.DB.Create(&req); tx.RowsAffected == 0 {
+		zap.S().Info("创建好友申请失败")
+		return nil, -1, errors.New("发送好友申请失败")
+	}
+	return &req, 1, nil
+}
+
+// FriendRequests 收到（incoming 为 true）或发出的好友申请，按时间倒序；列出前先把超时的申请标记为过期
+func FriendRequests(userID uint, incoming bool) ([]models.FriendRequest, error) {
+	column := "from_id"
+	if incoming {
+		column = "to_id"
+	}
+
+	if tx := global.DB.Model(&models.FriendRequest{}).
+		Where(column+" = ? and status = ? and created_at < ?", userID, models.FriendRequestPending, time.Now().Add(-models.FriendRequestTTL)).
+		Update("status", models.FriendRequestExpired); tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	requests := make([]models.FriendRequest, 0)
+	if tx := global.DB.Where(column+" = ?", userID).Order("created_at desc").Find(&requests); tx.Error != nil {
+		return nil, tx.Error
+	}
+	return requests, nil
+}
+
+// HandleFriendRequest 被申请人同意或拒绝好友申请，同意时在同一事务中建立好友关系
+func HandleFriendRequest(requestID, userID uint, accept bool) (*models.FriendRequest, error) {
+	req := models.FriendRequest{}
+	expired := false
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		if t := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&req, requestID); t.Error != nil {
+			return errors.New("好友申请不存在")
+		}
+		if req.ToId != userID {
+			return errors.New("无权处理该好友申请")
+		}
+
+		now := time.Now()
+		if req.Expired(now) {
+			expired = true
+			req.Status = models.FriendRequestExpired
+			return tx.Model(&req).Update("status", req.Status).Error
+		}
+		if req.Status != models.FriendRequestPending {
+			return errors.New("好友申请已处理")
+		}
+
+		req.Status = models.FriendRequestRejected
+		if accept {
+			blocked, err := BlockedBetween(req.FromId, req.ToId)
+			if err != nil {
+				return err
+			}
+			if blocked {
+				return errors.New("双方存在拉黑关系，无法添加好友")
+			}
+			req.Status = models.FriendRequestAccepted
+			// 已经是好友（code 0）时同样视为同意成功
+			if code, err := AddFriend(tx, req.FromId, req.ToId); err != nil && code != 0 {
+				return err
+			}
+		}
+		req.HandledAt = &now
+		return tx.Save(&req).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, errors.New("好友申请已过期")
+	}
+	return &req, nil
+}
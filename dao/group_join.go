@@ -0,0 +1,89 @@
+package dao
+
+import (
+	"HiChat/global"
+	"HiChat/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// createJoinRequest 提交加群申请，已有待处理的申请时不重复创建
+func createJoinRequest(groupId, userId uint) error {
+	var n int64
+	if tx := global.DB.Model(&models.GroupJoinRequest{}).
+		Where("group_id = ? and user_id = ? and status = ?", groupId, userId, models.FriendRequestPending).
+		Count(&n); tx.Error != nil {
+		return tx.Error
+	}
+	if n > 0 {
+		return nil
+	}
+
+	req := models.GroupJoinRequest{
+		GroupId: groupId,
+		UserId:  userId,
+		Status:  models.FriendRequestPending,
+	}
+	if tx := global.DB.Create(&req); tx.RowsAffected == 0 {
+		return errors.New("提交加群申请失败")
+	}
+	return nil
+}
+
+// GroupJoinRequests 群的待处理加群申请，仅群主和管理员可见
+func GroupJoinRequests(groupId, operator uint) ([]models.GroupJoinRequest, error) {
+	if _, err := CheckGroupRole(groupId, operator, models.RoleAdmin); err != nil {
+		return nil, err
+	}
+	requests := make([]models.GroupJoinRequest, 0)
+	if tx := global.DB.Where("group_id = ? and status = ?", groupId, models.FriendRequestPending).Order("id").Find(&requests); tx.Error != nil {
+		return nil, tx.Error
+	}
+	return requests, nil
+}
+
+// HandleGroupJoinRequest 群主或管理员同意或拒绝加群申请，同意时在同一事务中加入群
+func HandleGroupJoinRequest(requestId, operator uint, accept bool) (*models.GroupJoinRequest, error) {
+	req := models.GroupJoinRequest{}
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		if t := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&req, requestId); t.Error != nil {
+			return errors.New("加群申请不存在")
+		}
+		role, err := groupRole(tx, req.GroupId, operator)
+		if err != nil {
+			return err
+		}
+		if role < models.RoleAdmin {
+			return errors.New("权限不足")
+		}
+		if req.Status != models.FriendRequestPending {
+			return errors.New("加群申请已处理")
+		}
+
+		now := time.Now()
+		req.Status = models.FriendRequestRejected
+		if accept {
+			req.Status = models.FriendRequestAccepted
+			var n int64
+			if t := tx.Model(&models.Relation{}).Where("owner_id = ? and target_id = ? and type = 2", req.UserId, req.GroupId).Count(&n); t.Error != nil {
+				return t.Error
+			}
+			if n == 0 {
+				relation := models.Relation{OwnerId: req.UserId, TargetID: req.GroupId, Type: 2}
+				if t := tx.Create(&relation); t.Error != nil {
+					return t.Error
+				}
+			}
+		}
+		req.HandledBy = operator
+		req.HandledAt = &now
+		return tx.Save(&req).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
@@ -0,0 +1,292 @@
+package dao
+
+import (
+	"HiChat/global"
+	"HiChat/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GroupMember 群成员列表中的一项
+type GroupMember struct {
+	UserId     uint       `json:"user_id"`
+	Name       string     `json:"name"`
+	Avatar     string     `json:"avatar"`
+	Role       int        `json:"role"` // 0 普通成员 1 管理员 2 群主
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	JoinedAt   time.Time  `json:"joined_at"`
+}
+
+// groupRole 用户在群中的角色，不在群中时返回错误。没有记录角色的旧数据以 Community.OwnerId 判断群主
+func groupRole(db *gorm.DB, groupId, userId uint) (int, error) {
+	relation := models.Relation{}
+	if tx := db.Where("owner_id = ? and target_id = ? and type = 2", userId, groupId).Limit(1).Find(&relation); tx.Error != nil {
+		return 0, tx.Error
+	} else if tx.RowsAffected == 0 {
+		return 0, errors.New("你不在该群中")
+	}
+	if relation.Role == models.RoleOwner {
+		return models.RoleOwner, nil
+	}
+
+	community := models.Community{}
+	if tx := db.Select("owner_id").Where("id = ?", groupId).First(&community); tx.Error != nil {
+		return 0, tx.Error
+	}
+	if community.OwnerId == userId {
+		return models.RoleOwner, nil
+	}
+	return relation.Role, nil
+}
+
+// CheckGroupRole 要求操作者在群中至少具备 min 角色，返回操作者的角色
+func CheckGroupRole(groupId, userId uint, min int) (int, error) {
+	role, err := groupRole(global.DB, groupId, userId)
+	if err != nil {
+		return 0, err
+	}
+	if role < min {
+		return role, errors.New("权限不足")
+	}
+	return role, nil
+}
+
+// CanManageMember 只能管理角色低于自己的成员：群主管理所有人，管理员只能管理普通成员
+func CanManageMember(operatorRole, targetRole int) bool {
+	return operatorRole > targetRole
+}
+
+// checkManageMember 校验 operator 能否管理群中的 target，返回双方角色
+func checkManageMember(groupId, operator, target uint) (int, int, error) {
+	if operator == target {
+		return 0, 0, errors.New("不能对自己操作")
+	}
+	operatorRole, err := CheckGroupRole(groupId, operator, models.RoleAdmin)
+	if err != nil {
+		return 0, 0, err
+	}
+	targetRole, err := groupRole(global.DB, groupId, target)
+	if err != nil {
+		return 0, 0, errors.New("对方不在该群中")
+	}
+	if !CanManageMember(operatorRole, targetRole) {
+		return 0, 0, errors.New("权限不足")
+	}
+	return operatorRole, targetRole, nil
+}
+
+// GroupMembers 群成员及其角色，只有群成员可以查看
+func GroupMembers(groupId, requester uint) ([]GroupMember, error) {
+	if _, err := CheckGroupRole(groupId, requester, models.RoleMember); err != nil {
+		return nil, err
+	}
+
+	community := models.Community{}
+	if tx := global.DB.Select("owner_id").Where("id = ?", groupId).First(&community); tx.Error != nil {
+		return nil, tx.Error
+	}
+	relation := make([]models.Relation, 0)
+	if tx := global.DB.Where("target_id = ? and type = 2", groupId).Order("role desc, id").Find(&relation); tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	ids := make([]uint, 0, len(relation))
+	for _, r := range relation {
+		ids = append(ids, r.OwnerId)
+	}
+	users, err := FindUsersByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.UserBasic, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+
+	now := time.Now()
+	members := make([]GroupMember, 0, len(relation))
+	for _, r := range relation {
+		role := r.Role
+		if r.OwnerId == community.OwnerId {
+			role = models.RoleOwner
+		}
+		member := GroupMember{
+			UserId:   r.OwnerId,
+			Name:     byID[r.OwnerId].Name,
+			Avatar:   byID[r.OwnerId].Avatar,
+			Role:     role,
+			JoinedAt: r.CreatedAt,
+		}
+		if r.MutedUntil != nil && r.MutedUntil.After(now) {
+			member.MutedUntil = r.MutedUntil
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// SetGroupAdmin 群主设置或取消管理员
+func SetGroupAdmin(groupId, operator, target uint, admin bool) error {
+	if _, err := CheckGroupRole(groupId, operator, models.RoleOwner); err != nil {
+		return err
+	}
+	if _, _, err := checkManageMember(groupId, operator, target); err != nil {
+		return err
+	}
+
+	role := models.RoleMember
+	if admin {
+		role = models.RoleAdmin
+	}
+	if tx := global.DB.Model(&models.Relation{}).
+		Where("owner_id = ? and target_id = ? and type = 2", target, groupId).
+		Update("role", role); tx.Error != nil {
+		return tx.Error
+	}
+	InvalidateMembership(groupId, target)
+	return nil
+}
+
+// TransferGroupOwner 群主把群转让给其他成员，原群主变为普通成员
+func TransferGroupOwner(groupId, operator, target uint) error {
+	if operator == target {
+		return errors.New("不能转让给自己")
+	}
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		// 锁住群记录，避免并发转让
+		community := models.Community{}
+		if t := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&community, groupId); t.Error != nil {
+			return errors.New("群记录不存在")
+		}
+		role, err := groupRole(tx, groupId, operator)
+		if err != nil {
+			return err
+		}
+		if role != models.RoleOwner {
+			return errors.New("只有群主可以转让群")
+		}
+		if _, err := groupRole(tx, groupId, target); err != nil {
+			return errors.New("对方不在该群中")
+		}
+
+		if t := tx.Model(&community).Update("owner_id", target); t.Error != nil {
+			return t.Error
+		}
+		if t := tx.Model(&models.Relation{}).Where("owner_id = ? and target_id = ? and type = 2", operator, groupId).Update("role", models.RoleMember); t.Error != nil {
+			return t.Error
+		}
+		return tx.Model(&models.Relation{}).
+			Where("owner_id = ? and target_id = ? and type = 2", target, groupId).
+			Updates(map[string]interface{}{"role": models.RoleOwner, "muted_until": nil}).Error
+	})
+	if err != nil {
+		return err
+	}
+	InvalidateMembership(groupId, operator, target)
+	return nil
+}
+
+// MuteGroupMember 禁言群成员，until 为 nil 时解除禁言
+func MuteGroupMember(groupId, operator, target uint, until *time.Time) error {
+	if _, _, err := checkManageMember(groupId, operator, target); err != nil {
+		return err
+	}
+	if tx := global.DB.Model(&models.Relation{}).
+		Where("owner_id = ? and target_id = ? and type = 2", target, groupId).
+		Update("muted_until", until); tx.Error != nil {
+		return tx.Error
+	}
+	InvalidateMembership(groupId, target)
+	return nil
+}
+
+// UpdateCommunityInfo 管理员修改群资料，fields 的 key 为列名（name / image / desc / join_approval）
+func UpdateCommunityInfo(groupId, operator uint, fields map[string]interface{}) (*models.Community, error) {
+	if _, err := CheckGroupRole(groupId, operator, models.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	if name, ok := fields["name"]; ok {
+		var n int64
+		if tx := global.DB.Model(&models.Community{}).Where("name = ? and id <> ?", name, groupId).Count(&n); tx.Error != nil {
+			return nil, tx.Error
+		}
+		if n > 0 {
+			return nil, errors.New("群名称已存在")
+		}
+	}
+
+	if len(fields) > 0 {
+		if tx := global.DB.Model(&models.Community{}).Where("id = ?", groupId).Updates(fields); tx.Error != nil {
+			return nil, tx.Error
+		}
+	}
+	community := models.Community{}
+	if tx := global.DB.First(&community, groupId); tx.Error != nil {
+		return nil, errors.New("群记录不存在")
+	}
+	return &community, nil
+}
+
+// LeaveGroup 退出群聊，群主需要先转让或解散群
+func LeaveGroup(groupId, userId uint) error {
+	role, err := CheckGroupRole(groupId, userId, models.RoleMember)
+	if err != nil {
+		return err
+	}
+	if role == models.RoleOwner {
+		return errors.New("群主不能退群，请先转让或解散群")
+	}
+	if tx := global.DB.Where("owner_id = ? and target_id = ? and type = 2", userId, groupId).Delete(&models.Relation{}); tx.Error != nil {
+		return tx.Error
+	}
+	InvalidateMembership(groupId, userId)
+	return nil
+}
+
+// KickGroupMember 群主或管理员把成员移出群，只能移出角色低于自己的成员
+func KickGroupMember(groupId, operator, target uint) error {
+	if _, _, err := checkManageMember(groupId, operator, target); err != nil {
+		return err
+	}
+	if tx := global.DB.Where("owner_id = ? and target_id = ? and type = 2", target, groupId).Delete(&models.Relation{}); tx.Error != nil {
+		return tx.Error
+	}
+	InvalidateMembership(groupId, target)
+	return nil
+}
+
+// DissolveGroup 群主解散群：软删除群记录和全部成员关系，未处理的加群申请一并拒绝
+func DissolveGroup(groupId, operator uint) error {
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		community := models.Community{}
+		if t := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&community, groupId); t.Error != nil {
+			return errors.New("群记录不存在")
+		}
+		role, err := groupRole(tx, groupId, operator)
+		if err != nil {
+			return err
+		}
+		if role != models.RoleOwner {
+			return errors.New("只有群主可以解散群")
+		}
+
+		if t := tx.Where("target_id = ? and type = 2", groupId).Delete(&models.Relation{}); t.Error != nil {
+			return t.Error
+		}
+		if t := tx.Model(&models.GroupJoinRequest{}).
+			Where("group_id = ? and status = ?", groupId, models.FriendRequestPending).
+			Updates(map[string]interface{}{"status": models.FriendRequestRejected, "handled_by": operator, "handled_at": time.Now()}); t.Error != nil {
+			return t.Error
+		}
+		return tx.Delete(&community).Error
+	})
+	if err != nil {
+		return err
+	}
+	InvalidateMembership(groupId)
+	return nil
+}
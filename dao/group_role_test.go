@@ -0,0 +1,196 @@
+package dao
+
+import (
+	"HiChat/global"
+	"HiChat/models"
+	"fmt"
+	"testing"
+)
+
+func TestCanManageMember(t *testing.T) {
+	cases := []struct {
+		operator, target int
+		want             bool
+	}{
+		{models.RoleOwner, models.RoleAdmin, true},
+		{models.RoleOwner, models.RoleMember, true},
+		{models.RoleAdmin, models.RoleMember, true},
+		{models.RoleAdmin, models.RoleAdmin, false}, // 管理员之间不能互相管理
+		{models.RoleAdmin, models.RoleOwner, false},
+		{models.RoleMember, models.RoleMember, false},
+		{models.RoleMember, models.RoleOwner, false},
+	}
+	for _, c := range cases {
+		if got := CanManageMember(c.operator, c.target); got != c.want {
+			t.Errorf("CanManageMember(%d, %d) = %v, want %v", c.operator, c.target, got, c.want)
+		}
+	}
+}
+
+func TestCheckManageMemberRejectsSelf(t *testing.T) {
+	// 对自己操作在查库之前就被拒绝
+	if _, _, err := checkManageMember(1, 7, 7); err == nil {
+		t.Fatal("managing yourself should be rejected")
+	}
+}
+
+// createTestGroup 建群并按 roles 加入成员，返回群 ID
+func createTestGroup(t *testing.T, name string, owner uint, approval bool, roles map[uint]int) uint {
+	t.Helper()
+	if _, err := CreateCommunity(models.Community{Name: name, OwnerId: owner, JoinApproval: approval}); err != nil {
+		t.Fatal(err)
+	}
+	community := models.Community{}
+	if err := global.DB.Where("name = ?", name).First(&community).Error; err != nil {
+		t.Fatal(err)
+	}
+	for userId, role := range roles {
+		relation := models.Relation{OwnerId: userId, TargetID: community.ID, Type: 2, Role: role}
+		if err := global.DB.Create(&relation).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return community.ID
+}
+
+func mustRole(t *testing.T, groupId, userId uint) int {
+	t.Helper()
+	role, err := groupRole(global.DB, groupId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return role
+}
+
+func TestHandleGroupJoinRequestRequiresAdmin(t *testing.T) {
+	useTestDB(t)
+	owner := createTestUser(t, "owner", "")
+	admin := createTestUser(t, "admin", "")
+	member := createTestUser(t, "member", "")
+	applicant := createTestUser(t, "applicant", "")
+	groupId := createTestGroup(t, "g", owner, true, map[uint]int{admin: models.RoleAdmin, member: models.RoleMember})
+
+	code, err := JoinCommunity(applicant, "g")
+	if err != nil || code != 1 {
+		t.Fatalf("join = (%d, %v), want pending request", code, err)
+	}
+	if ok, _ := IsUserInGroup(fmt.Sprint(groupId), fmt.Sprint(applicant)); ok {
+		t.Fatal("joined without approval")
+	}
+
+	if _, err := GroupJoinRequests(groupId, member); err == nil {
+		t.Fatal("member listed join requests")
+	}
+	requests, err := GroupJoinRequests(groupId, admin)
+	if err != nil || len(requests) != 1 {
+		t.Fatalf("requests = %v, %v", requests, err)
+	}
+
+	if _, err := HandleGroupJoinRequest(requests[0].ID, member, true); err == nil {
+		t.Fatal("member approved a join request")
+	}
+	if _, err := HandleGroupJoinRequest(requests[0].ID, admin, true); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := IsUserInGroup(fmt.Sprint(groupId), fmt.Sprint(applicant)); !ok {
+		t.Fatal("approved applicant not in group")
+	}
+	if _, err := HandleGroupJoinRequest(requests[0].ID, owner, false); err == nil {
+		t.Fatal("handled request processed twice")
+	}
+}
+
+func TestKickGroupMemberChecksRoles(t *testing.T) {
+	useTestDB(t)
+	owner := createTestUser(t, "owner", "")
+	admin := createTestUser(t, "admin", "")
+	admin2 := createTestUser(t, "admin2", "")
+	member := createTestUser(t, "member", "")
+	groupId := createTestGroup(t, "g", owner, false,
+		map[uint]int{admin: models.RoleAdmin, admin2: models.RoleAdmin, member: models.RoleMember})
+
+	if err := KickGroupMember(groupId, member, admin); err == nil {
+		t.Fatal("member kicked an admin")
+	}
+	if err := KickGroupMember(groupId, admin, admin2); err == nil {
+		t.Fatal("admin kicked another admin")
+	}
+	if err := KickGroupMember(groupId, admin, owner); err == nil {
+		t.Fatal("admin kicked the owner")
+	}
+	if err := KickGroupMember(groupId, admin, member); err != nil {
+		t.Fatal(err)
+	}
+	if err := KickGroupMember(groupId, owner, admin2); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint{member, admin2} {
+		if ok, _ := IsUserInGroup(fmt.Sprint(groupId), fmt.Sprint(id)); ok {
+			t.Fatalf("user %d still in group", id)
+		}
+	}
+}
+
+func TestTransferGroupOwner(t *testing.T) {
+	useTestDB(t)
+	owner := createTestUser(t, "owner", "")
+	admin := createTestUser(t, "admin", "")
+	member := createTestUser(t, "member", "")
+	outsider := createTestUser(t, "outsider", "")
+	groupId := createTestGroup(t, "g", owner, false, map[uint]int{admin: models.RoleAdmin, member: models.RoleMember})
+
+	if err := TransferGroupOwner(groupId, admin, member); err == nil {
+		t.Fatal("admin transferred ownership")
+	}
+	if err := TransferGroupOwner(groupId, owner, outsider); err == nil {
+		t.Fatal("ownership transferred to a non-member")
+	}
+	if err := TransferGroupOwner(groupId, owner, member); err != nil {
+		t.Fatal(err)
+	}
+
+	if role := mustRole(t, groupId, member); role != models.RoleOwner {
+		t.Fatalf("new owner role = %d", role)
+	}
+	if role := mustRole(t, groupId, owner); role != models.RoleMember {
+		t.Fatalf("previous owner role = %d", role)
+	}
+	community := models.Community{}
+	global.DB.First(&community, groupId)
+	if community.OwnerId != member {
+		t.Fatalf("community owner = %d, want %d", community.OwnerId, member)
+	}
+	// 原群主失去群主权限
+	if err := DissolveGroup(groupId, owner); err == nil {
+		t.Fatal("previous owner dissolved the group")
+	}
+}
+
+func TestDissolveGroup(t *testing.T) {
+	useTestDB(t)
+	owner := createTestUser(t, "owner", "")
+	admin := createTestUser(t, "admin", "")
+	applicant := createTestUser(t, "applicant", "")
+	groupId := createTestGroup(t, "g", owner, true, map[uint]int{admin: models.RoleAdmin})
+	if _, err := JoinCommunity(applicant, "g"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DissolveGroup(groupId, admin); err == nil {
+		t.Fatal("admin dissolved the group")
+	}
+	if err := DissolveGroup(groupId, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	var members int64
+	global.DB.Model(&models.Relation{}).Where("target_id = ? and type = 2", groupId).Count(&members)
+	if members != 0 {
+		t.Fatalf("%d memberships left after dissolve", members)
+	}
+	var pending int64
+	global.DB.Model(&models.GroupJoinRequest{}).Where("group_id = ? and status = ?", groupId, models.FriendRequestPending).Count(&pending)
+	if pending != 0 {
+		t.Fatalf("%d join requests still pending", pending)
+	}
+}
@@ -0,0 +1,99 @@
+package dao
+
+import (
+	"HiChat/global"
+	"HiChat/models"
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// 群成员关系缓存：hash group:members:<群ID>，field 为用户 ID，value 为 Relation 的 JSON。
+// 只缓存在群中的成员，成员变动（退群、踢人、解散、角色或禁言变化）时必须调用 InvalidateMembership。
+// group:members:gen:<群ID> 为缓存代数，每次失效加一：查库前记下代数，回填时代数已变化说明期间有成员变动，
+// 查到的可能是变动前的数据，放弃回填，避免失效后又被写回旧数据
+const (
+	groupMembersCachePrefix = "group:members:"
+	groupMembersGenPrefix   = "group:members:gen:"
+	groupMembersCacheTTL    = 10 * time.Minute
+	groupMembersGenTTL      = 24 * time.Hour // 远大于一次查库的耗时
+)
+
+// cacheMembershipScript 代数未变化时才回填
+// KEYS: 缓存 hash, 代数 key；ARGV: 用户 ID, 数据, TTL 秒, 查库前的代数
+var cacheMembershipScript = redis.NewScript(`
+if (redis.call("GET", KEYS[2]) or "0") ~= ARGV[4] then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+func cachedMembership(groupID, userID string) (*models.Relation, bool) {
+	data, err := global.RedisDB.HGet(context.Background(), groupMembersCachePrefix+groupID, userID).Result()
+	if err != nil {
+		return nil, false
+	}
+	relation := models.Relation{}
+	if err := json.Unmarshal([]byte(data), &relation); err != nil {
+		return nil, false
+	}
+	return &relation, true
+}
+
+// membershipGeneration 查库前读取缓存代数，读取失败时返回空串，之后不回填
+func membershipGeneration(groupID string) string {
+	gen, err := global.RedisDB.Get(context.Background(), groupMembersGenPrefix+groupID).Result()
+	if err == redis.Nil {
+		return "0"
+	}
+	if err != nil {
+		return ""
+	}
+	return gen
+}
+
+func cacheMembership(groupID, userID, gen string, relation *models.Relation) {
+	if gen == "" {
+		return
+	}
+	data, err := json.Marshal(relation)
+	if err != nil {
+		return
+	}
+	err = cacheMembershipScript.Run(context.Background(), global.RedisDB,
+		[]string{groupMembersCachePrefix + groupID, groupMembersGenPrefix + groupID},
+		userID, data, int(groupMembersCacheTTL.Seconds()), gen,
+	).Err()
+	if err != nil {
+		zap.S().Warn("Cache group membership failed", zap.String("group", groupID), zap.Error(err))
+	}
+}
+
+// InvalidateMembership 推进缓存代数并删除群成员关系缓存，userIds 为空时删除整个群的缓存；须在数据库修改提交之后调用
+func InvalidateMembership(groupId uint, userIds ...uint) {
+	ctx := context.Background()
+	groupID := strconv.FormatUint(uint64(groupId), 10)
+	key := groupMembersCachePrefix + groupID
+
+	pipe := global.RedisDB.TxPipeline()
+	pipe.Incr(ctx, groupMembersGenPrefix+groupID)
+	pipe.Expire(ctx, groupMembersGenPrefix+groupID, groupMembersGenTTL)
+	if len(userIds) == 0 {
+		pipe.Del(ctx, key)
+	} else {
+		fields := make([]string, 0, len(userIds))
+		for _, id := range userIds {
+			fields = append(fields, strconv.FormatUint(uint64(id), 10))
+		}
+		pipe.HDel(ctx, key, fields...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		zap.S().Warn("Invalidate group membership cache failed", zap.String("group", key), zap.Error(err))
+	}
+}
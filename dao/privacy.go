@@ -0,0 +1,93 @@
+package dao
+
+import (
+	"HiChat/global"
+	"HiChat/models"
+	"errors"
+
+	"gorm.io/gorm/clause"
+)
+
+// GetPrivacy 获取用户隐私设置，未设置过时返回全部允许的默认值
+func GetPrivacy(userId uint) (*models.UserPrivacy, error) {
+	privacy := models.UserPrivacy{UserId: userId}
+	if tx := global.DB.Where("user_id = ?", userId).Limit(1).Find(&privacy); tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &privacy, nil
+}
+
+// SavePrivacy 新建或覆盖用户隐私设置
+func SavePrivacy(privacy *models.UserPrivacy) error {
+	return global.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"find_by_name", "find_by_phone", "group_invite", "updated_at"}),
+	}).Create(privacy).Error
+}
+
+// privacyAllows 判断 requester 是否满足 owner 的某项隐私选项
+func privacyAllows(policy int, owner, requester uint) (bool, error) {
+	if owner == requester {
+		return true, nil
+	}
+	switch policy {
+	case models.PrivacyEveryone:
+		return true, nil
+	case models.PrivacyFriends:
+		return IsFriend(owner, requester)
+	default:
+		return false, nil
+	}
+}
+
+// SearchUser 按昵称或手机号查找用户。对方的隐私设置不允许或已拉黑查找者时，与用户不存在返回相同的错误
+func SearchUser(requester uint, keyword string) (*models.UserBasic, error) {
+	notFound := errors.New("该用户不存在")
+
+	byPhone := false
+	user, err := FindUserByName(keyword)
+	if err != nil {
+		if user, err = FindUserByPhone(keyword); err != nil {
+			return nil, notFound
+		}
+		byPhone = true
+	}
+
+	privacy, err := GetPrivacy(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	policy := privacy.FindByName
+	if byPhone {
+		policy = privacy.FindByPhone
+	}
+	ok, err := privacyAllows(policy, user.ID, requester)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, notFound
+	}
+
+	blocked, err := IsBlocked(user.ID, requester)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, notFound
+	}
+	return user, nil
+}
+
+// CanInviteToGroup 判断 inviter 能否把 invitee 拉进群：遵循 invitee 的隐私设置，双方存在拉黑时不允许
+func CanInviteToGroup(inviter, invitee uint) (bool, error) {
+	blocked, err := BlockedBetween(inviter, invitee)
+	if err != nil || blocked {
+		return false, err
+	}
+	privacy, err := GetPrivacy(invitee)
+	if err != nil {
+		return false, err
+	}
+	return privacyAllows(privacy.GroupInvite, invitee, inviter)
+}
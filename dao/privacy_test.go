@@ -0,0 +1,147 @@
+package dao
+
+import (
+	"HiChat/global"
+	"HiChat/models"
+	"fmt"
+	"testing"
+)
+
+func TestPrivacyAllows(t *testing.T) {
+	cases := []struct {
+		name             string
+		policy           int
+		owner, requester uint
+		want             bool
+	}{
+		{"everyone", models.PrivacyEveryone, 1, 2, true},
+		{"nobody", models.PrivacyNobody, 1, 2, false},
+		{"nobody allows self", models.PrivacyNobody, 1, 1, true},
+		{"friends allows self", models.PrivacyFriends, 1, 1, true},
+		{"unknown policy", 99, 1, 2, false},
+	}
+	for _, c := range cases {
+		got, err := privacyAllows(c.policy, c.owner, c.requester)
+		if err != nil || got != c.want {
+			t.Errorf("%s: privacyAllows = (%v, %v), want %v", c.name, got, err, c.want)
+		}
+	}
+}
+
+func setPrivacy(t *testing.T, userId uint, byName, byPhone, invite int) {
+	t.Helper()
+	if err := SavePrivacy(&models.UserPrivacy{UserId: userId, FindByName: byName, FindByPhone: byPhone, GroupInvite: invite}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func makeFriends(t *testing.T, a, b uint) {
+	t.Helper()
+	for _, r := range []models.Relation{{OwnerId: a, TargetID: b, Type: 1}, {OwnerId: b, TargetID: a, Type: 1}} {
+		if err := global.DB.Create(&r).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSearchUserHonorsPrivacy(t *testing.T) {
+	useTestDB(t)
+	target := createTestUser(t, "alice", "13800000001")
+	friend := createTestUser(t, "bob", "13800000002")
+	stranger := createTestUser(t, "carol", "13800000003")
+	makeFriends(t, target, friend)
+	setPrivacy(t, target, models.PrivacyNobody, models.PrivacyFriends, models.PrivacyEveryone)
+
+	if _, err := SearchUser(stranger, "alice"); err == nil {
+		t.Fatal("found by name although nobody may")
+	}
+	if _, err := SearchUser(friend, "alice"); err == nil {
+		t.Fatal("friend found by name although nobody may")
+	}
+	if _, err := SearchUser(stranger, "13800000001"); err == nil {
+		t.Fatal("stranger found by phone although only friends may")
+	}
+	if user, err := SearchUser(friend, "13800000001"); err != nil || user.ID != target {
+		t.Fatalf("friend search by phone = (%v, %v)", user, err)
+	}
+	if user, err := SearchUser(target, "alice"); err != nil || user.ID != target {
+		t.Fatalf("self search = (%v, %v)", user, err)
+	}
+}
+
+func TestSearchUserHidesBlockers(t *testing.T) {
+	useTestDB(t)
+	target := createTestUser(t, "alice", "13800000001")
+	requester := createTestUser(t, "bob", "13800000002")
+	if _, err := BlockUser(target, requester); err != nil {
+		t.Fatal(err)
+	}
+
+	_, blockedErr := SearchUser(requester, "alice")
+	_, missingErr := SearchUser(requester, "nobody")
+	if blockedErr == nil || missingErr == nil || blockedErr.Error() != missingErr.Error() {
+		t.Fatalf("blocked search err = %v, missing user err = %v", blockedErr, missingErr)
+	}
+}
+
+// 纯数字的关键字按手机号查找，同样受隐私设置约束
+func TestAddFriendByPhoneHonorsPrivacy(t *testing.T) {
+	useTestDB(t)
+	target := createTestUser(t, "alice", "13800000001")
+	requester := createTestUser(t, "bob", "13800000002")
+
+	setPrivacy(t, target, models.PrivacyEveryone, models.PrivacyNobody, models.PrivacyEveryone)
+	if _, _, err := AddFriendByName(requester, "13800000001", "hi"); err == nil {
+		t.Fatal("friend request sent by phone although nobody may find by phone")
+	}
+	if _, _, err := AddFriendByName(requester, fmt.Sprint(target), "hi"); err == nil {
+		t.Fatal("friend request sent by raw user ID")
+	}
+
+	setPrivacy(t, target, models.PrivacyEveryone, models.PrivacyEveryone, models.PrivacyEveryone)
+	req, _, err := AddFriendByName(requester, "13800000001", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ToId != target || req.Status != models.FriendRequestPending {
+		t.Fatalf("request = %+v", req)
+	}
+}
+
+func TestFriendRequestRejectedWhenBlocked(t *testing.T) {
+	useTestDB(t)
+	a := createTestUser(t, "alice", "")
+	b := createTestUser(t, "bob", "")
+	if _, err := BlockUser(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := SendFriendRequest(b, a, "hi"); err == nil {
+		t.Fatal("blocked user sent a friend request")
+	}
+	if _, _, err := SendFriendRequest(a, b, "hi"); err == nil {
+		t.Fatal("blocker sent a friend request without unblocking")
+	}
+}
+
+func TestCanInviteToGroup(t *testing.T) {
+	useTestDB(t)
+	inviter := createTestUser(t, "alice", "")
+	friend := createTestUser(t, "bob", "")
+	stranger := createTestUser(t, "carol", "")
+	makeFriends(t, inviter, friend)
+	setPrivacy(t, friend, models.PrivacyEveryone, models.PrivacyEveryone, models.PrivacyFriends)
+	setPrivacy(t, stranger, models.PrivacyEveryone, models.PrivacyEveryone, models.PrivacyFriends)
+
+	if ok, err := CanInviteToGroup(inviter, friend); err != nil || !ok {
+		t.Fatalf("invite friend = (%v, %v)", ok, err)
+	}
+	if ok, _ := CanInviteToGroup(inviter, stranger); ok {
+		t.Fatal("stranger invited although only friends may")
+	}
+	if _, err := BlockUser(friend, inviter); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := CanInviteToGroup(inviter, friend); ok {
+		t.Fatal("invited by a blocked user")
+	}
+}
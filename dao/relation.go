@@ -7,19 +7,33 @@ import (
 	"errors"
 
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
-//FriendList 获取好友列表
-func FriendList(userId uint) (*[]models.UserBasic, error) {
+//FriendList 获取好友列表及备注、标签，tag 非空时只返回带该标签的好友
+func FriendList(userId uint, tag string) ([]FriendInfo, error) {
 	relation := make([]models.Relation, 0)
 	if tx := global.DB.Where("owner_id = ? and type=1", userId).Find(&relation); tx.RowsAffected == 0 {
 		zap.S().Info("未查询到Relation数据")
 		return nil, errors.New("未查到好友关系")
 	}
 
+	tags, err := FriendTags(userId)
+	if err != nil {
+		return nil, err
+	}
+
 	userID := make([]uint, 0)
+	remarks := make(map[uint]string, len(relation))
 	for _, v := range relation {
+		if tag != "" && !hasTag(tags[v.TargetID], tag) {
+			continue
+		}
 		userID = append(userID, v.TargetID)
+		remarks[v.TargetID] = v.Desc
+	}
+	if len(userID) == 0 {
+		return []FriendInfo{}, nil
 	}
 
 	user := make([]models.UserBasic, 0)
@@ -27,24 +41,38 @@ func FriendList(userId uint) (*[]models.UserBasic, error) {
 		zap.S().Info("未查询到Relation好友关系")
 		return nil, errors.New("未查到好友")
 	}
-	return &user, nil
+
+	friends := make([]FriendInfo, 0, len(user))
+	for _, u := range user {
+		friends = append(friends, FriendInfo{
+			UserBasic: u,
+			Remark:    remarks[u.ID],
+			Tags:      tags[u.ID],
+		})
+	}
+	return friends, nil
 }
 
-//AddFriendByName 昵称加好友
-func AddFriendByName(userId uint, targetName string) (int, error) {
-	user, err := FindUserByName(targetName)
-	if err != nil {
-		return -1, errors.New("该用户不存在")
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
 	}
-	if user.ID == 0 {
-		zap.S().Info("未查询到用户")
-		return -1, errors.New("该用户不存在")
+	return false
+}
+
+//AddFriendByName 通过昵称或手机号发起好友申请，查找时遵循对方的隐私设置和黑名单
+func AddFriendByName(userId uint, targetName, greeting string) (*models.FriendRequest, int, error) {
+	user, err := SearchUser(userId, targetName)
+	if err != nil {
+		return nil, -1, err
 	}
-	return AddFriend(userId, user.ID)
+	return SendFriendRequest(userId, user.ID, greeting)
 }
 
-//AddFriend 加好友
-func AddFriend(userID, TargetId uint) (int, error) {
+//AddFriend 建立双向好友关系，只在同意好友申请时于事务 tx 中调用
+func AddFriend(tx *gorm.DB, userID, TargetId uint) (int, error) {
 
 	if userID == TargetId {
 		return -2, errors.New("userID和TargetId相等")
@@ -61,28 +89,22 @@ func AddFriend(userID, TargetId uint) (int, error) {
 
 	relation := models.Relation{}
 
-	if tx := global.DB.Where("owner_id = ? and target_id = ? and type = 1", userID, TargetId).First(&relation); tx.RowsAffected == 1 {
+	if t := tx.Where("owner_id = ? and target_id = ? and type = 1", userID, TargetId).First(&relation); t.RowsAffected == 1 {
 		zap.S().Info("该好友存在")
 		return 0, errors.New("好友已经存在")
 	}
 
-	if tx := global.DB.Where("owner_id = ? and target_id = ?  and type = 1", TargetId, userID).First(&relation); tx.RowsAffected == 1 {
+	if t := tx.Where("owner_id = ? and target_id = ?  and type = 1", TargetId, userID).First(&relation); t.RowsAffected == 1 {
 		zap.S().Info("该好友存在")
 		return 0, errors.New("好友已经存在")
 	}
 
-	//开启事务
-	tx := global.DB.Begin()
-
 	relation.OwnerId = userID
 	relation.TargetID = targetUser.ID
 	relation.Type = 1
 
 	if t := tx.Create(&relation); t.RowsAffected == 0 {
 		zap.S().Info("创建失败")
-
-		//事务回滚
-		tx.Rollback()
 		return -1, errors.New("创建好友记录失败")
 	}
 
@@ -93,13 +115,27 @@ func AddFriend(userID, TargetId uint) (int, error) {
 
 	if t := tx.Create(&relation); t.RowsAffected == 0 {
 		zap.S().Info("创建失败")
-
-		//事务回滚
-		tx.Rollback()
 		return -1, errors.New("创建好友记录失败")
 	}
 
-	//提交事务
-	tx.Commit()
 	return 1, nil
 }
+
+// RelationTargets 获取用户的好友ID和已加入的群ID
+func RelationTargets(userId uint) ([]uint, []uint, error) {
+	relation := make([]models.Relation, 0)
+	if tx := global.DB.Where("owner_id = ? and type in (1, 2)", userId).Find(&relation); tx.Error != nil {
+		return nil, nil, tx.Error
+	}
+
+	friends := make([]uint, 0)
+	groups := make([]uint, 0)
+	for _, v := range relation {
+		if v.Type == 1 {
+			friends = append(friends, v.TargetID)
+		} else {
+			groups = append(groups, v.TargetID)
+		}
+	}
+	return friends, groups, nil
+}
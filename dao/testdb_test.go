@@ -0,0 +1,62 @@
+package dao
+
+import (
+	"HiChat/global"
+	"HiChat/models"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const userBasicTable = `CREATE TABLE user_basics (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime, updated_at datetime, deleted_at datetime,
+	name text, pass_word text, avatar text, gender text DEFAULT 'male',
+	phone text, email text, identity text, client_ip text, client_port text, salt text,
+	login_time datetime, heart_beat_time datetime, login_out_time datetime,
+	is_login_out numeric, device_info text
+)`
+
+// useTestDB 以内存 SQLite 替换 global.DB；Redis 指向不可达地址，缓存失效只记录告警
+func useTestDB(t *testing.T) {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// UserBasic 的 gender 列类型带有 MySQL 注释，SQLite 无法解析，单独建表
+	if err := db.Exec(userBasicTable).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Relation{}, &models.Community{},
+		&models.GroupJoinRequest{}, &models.FriendRequest{}, &models.UserPrivacy{}); err != nil {
+		t.Fatal(err)
+	}
+
+	prevDB, prevRedis := global.DB, global.RedisDB
+	global.DB = db
+	global.RedisDB = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() {
+		global.RedisDB.Close()
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		global.DB, global.RedisDB = prevDB, prevRedis
+	})
+}
+
+// createTestUser 新建用户并返回 ID
+func createTestUser(t *testing.T, name, phone string) uint {
+	t.Helper()
+	user, err := CreateUser(models.UserBasic{Name: name, Phone: phone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
@@ -111,3 +111,15 @@ func DeleteUser(user models.UserBasic) error {
 	}
 	return nil
 }
+
+// FindUsersByIDs 批量获取用户信息
+func FindUsersByIDs(ids []uint) ([]models.UserBasic, error) {
+	users := make([]models.UserBasic, 0, len(ids))
+	if len(ids) == 0 {
+		return users, nil
+	}
+	if tx := global.DB.Where("id in ?", ids).Find(&users); tx.Error != nil {
+		return nil, tx.Error
+	}
+	return users, nil
+}
@@ -40,7 +40,9 @@ create table relations
     owner_id   bigint unsigned null,
     target_id  bigint unsigned null,
     type       bigint          null,
-    `desc`     longtext        null
+    `desc`     longtext        null,
+    role        bigint         null comment '群成员角色：0 普通成员 1 管理员 2 群主',
+    muted_until datetime(3)    null
 );
 
 create index idx_relations_deleted_at
@@ -52,14 +54,148 @@ INSERT INTO hi_chat.relations (id, created_at, updated_at, deleted_at, owner_id,
 INSERT INTO hi_chat.relations (id, created_at, updated_at, deleted_at, owner_id, target_id, type, `desc`) VALUES (4, '2023-10-30 16:39:33.825', '2023-10-30 16:39:33.825', null, 3, 1, 1, '');
 INSERT INTO hi_chat.relations (id, created_at, updated_at, deleted_at, owner_id, target_id, type, `desc`) VALUES (5, '2023-10-30 16:48:09.447', '2023-10-30 16:48:09.447', null, 1, 1, 2, '');
 
+create table conversation_settings
+(
+    id              bigint unsigned auto_increment
+        primary key,
+    created_at      datetime(3)     null,
+    updated_at      datetime(3)     null,
+    deleted_at      datetime(3)     null,
+    owner_id        bigint unsigned null,
+    conversation_id varchar(64)     null,
+    pinned          tinyint(1)      null,
+    muted_until     datetime(3)     null,
+    archived        tinyint(1)      null,
+    constraint idx_owner_conv
+        unique (owner_id, conversation_id)
+);
+
+create index idx_conversation_settings_deleted_at
+    on conversation_settings (deleted_at);
+
+create table friend_requests
+(
+    id         bigint unsigned auto_increment
+        primary key,
+    created_at datetime(3)      null,
+    updated_at datetime(3)      null,
+    deleted_at datetime(3)      null,
+    from_id    bigint unsigned  null,
+    to_id      bigint unsigned  null,
+    status     bigint           null comment '0 待处理 1 已同意 2 已拒绝 3 已过期',
+    greeting   varchar(255)     null,
+    handled_at datetime(3)      null
+);
+
+create index idx_friend_requests_deleted_at
+    on friend_requests (deleted_at);
+
+create index idx_friend_requests_from_id
+    on friend_requests (from_id);
+
+create index idx_friend_requests_to_id
+    on friend_requests (to_id);
+
+create table user_privacies
+(
+    id            bigint unsigned auto_increment
+        primary key,
+    created_at    datetime(3)     null,
+    updated_at    datetime(3)     null,
+    deleted_at    datetime(3)     null,
+    user_id       bigint unsigned null,
+    find_by_name  bigint          null comment '0 所有人 1 仅好友 2 不允许',
+    find_by_phone bigint          null comment '0 所有人 1 仅好友 2 不允许',
+    group_invite  bigint          null comment '0 所有人 1 仅好友 2 不允许',
+    constraint idx_user_privacies_user_id
+        unique (user_id)
+);
+
+create index idx_user_privacies_deleted_at
+    on user_privacies (deleted_at);
+
+create table friend_tags
+(
+    id         bigint unsigned auto_increment
+        primary key,
+    created_at datetime(3)     null,
+    updated_at datetime(3)     null,
+    deleted_at datetime(3)     null,
+    owner_id   bigint unsigned null,
+    friend_id  bigint unsigned null,
+    tag        varchar(32)     null,
+    constraint idx_owner_friend_tag
+        unique (owner_id, friend_id, tag)
+);
+
+create index idx_friend_tags_deleted_at
+    on friend_tags (deleted_at);
+
+create table group_join_requests
+(
+    id         bigint unsigned auto_increment
+        primary key,
+    created_at datetime(3)     null,
+    updated_at datetime(3)     null,
+    deleted_at datetime(3)     null,
+    group_id   bigint unsigned null,
+    user_id    bigint unsigned null,
+    status     bigint          null comment '0 待处理 1 已同意 2 已拒绝',
+    handled_by bigint unsigned null,
+    handled_at datetime(3)     null
+);
+
+create index idx_group_join_requests_deleted_at
+    on group_join_requests (deleted_at);
+
+create index idx_group_join_requests_group_id
+    on group_join_requests (group_id);
+
+create index idx_group_join_requests_user_id
+    on group_join_requests (user_id);
+
+-- 归档消息按会话类型和月份分表：messages_cold_<private|group>_<YYYYMM>，
+-- 由归档任务以 CREATE TABLE ... LIKE messages_cold 自动创建，超出 message.retention 后整表删除；
+-- messages_cold 本身作为模板并保存分表前的历史数据
 CREATE TABLE messages_cold (
     id VARCHAR(32) PRIMARY KEY,
-    conversation_id VARCHAR(64) INDEX,
+    conversation_id VARCHAR(64),
+    seq BIGINT NOT NULL DEFAULT 0,
     sender_id VARCHAR(32),
     content BLOB,
     msg_type VARCHAR(16),
     timestamp DATETIME(6),
-    INDEX idx_conv_time (conversation_id, timestamp)
+    recalled TINYINT(1) NOT NULL DEFAULT 0,
+    revision_count INT NOT NULL DEFAULT 0,
+    edited_at DATETIME(6) NULL,
+    reply_to VARCHAR(32) NULL,
+    thread_root VARCHAR(32) NULL,
+    reactions JSON NULL,
+    mentions JSON NULL,
+    INDEX idx_conv_time (conversation_id, timestamp),
+    INDEX idx_conv_seq (conversation_id, seq),
+    INDEX idx_thread_root (thread_root)
+);
+
+CREATE TABLE messages_revision (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    msg_id VARCHAR(32),
+    conversation_id VARCHAR(64),
+    version INT,
+    content BLOB,
+    edited_at DATETIME(6),
+    UNIQUE INDEX uniq_msg_version (msg_id, version)
+);
+
+-- 全文检索索引，ngram 分词支持中文（需 MySQL 5.7.6+）
+CREATE TABLE messages_search (
+    msg_id VARCHAR(32) PRIMARY KEY,
+    conversation_id VARCHAR(64),
+    sender_id VARCHAR(32),
+    content TEXT,
+    timestamp DATETIME(6),
+    INDEX idx_search_conv_time (conversation_id, timestamp),
+    FULLTEXT INDEX idx_search_content (content) WITH PARSER ngram
 );
 
 create table messages
@@ -118,7 +254,8 @@ create table communities
     owner_id   bigint unsigned null,
     type       bigint          null,
     image      longtext        null,
-    `desc`     longtext        null
+    `desc`     longtext        null,
+    join_approval tinyint(1)   null
 );
 
 create index idx_communities_deleted_at
@@ -0,0 +1,103 @@
+package idgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	workerKeyPrefix = "idgen:worker:"
+	// WorkerLeaseTTL worker ID 租约有效期，续约周期为 TTL 的 1/3
+	WorkerLeaseTTL = 30 * time.Second
+)
+
+// renewScript 只有仍持有租约时才续期
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript 只释放自己持有的租约
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Lease 从 Redis 租用的 worker ID
+type Lease struct {
+	client   *redis.Client
+	owner    string
+	workerID int64
+}
+
+func workerKey(workerID int64) string {
+	return fmt.Sprintf("%s%d", workerKeyPrefix, workerID)
+}
+
+// AcquireWorker 为 owner 租用一个空闲的 worker ID
+func AcquireWorker(ctx context.Context, client *redis.Client, owner string) (*Lease, error) {
+	start := rand.Int63n(MaxWorkerID + 1)
+	for i := int64(0); i <= MaxWorkerID; i++ {
+		workerID := (start + i) % (MaxWorkerID + 1)
+		ok, err := client.SetNX(ctx, workerKey(workerID), owner, WorkerLeaseTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &Lease{client: client, owner: owner, workerID: workerID}, nil
+		}
+	}
+	return nil, errors.New("no free worker id")
+}
+
+// WorkerID 当前租用的 worker ID
+func (l *Lease) WorkerID() int64 {
+	return l.workerID
+}
+
+// KeepAlive 周期续约直到 ctx 结束；租约丢失时重新申请并更新 node 的 worker ID，结束时释放租约
+func (l *Lease) KeepAlive(ctx context.Context, node *Node) {
+	ticker := time.NewTicker(WorkerLeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			releaseScript.Run(context.Background(), l.client, []string{workerKey(l.workerID)}, l.owner)
+			return
+		case <-ticker.C:
+		}
+
+		ok, err := renewScript.Run(ctx, l.client, []string{workerKey(l.workerID)}, l.owner, WorkerLeaseTTL.Milliseconds()).Int()
+		if err != nil {
+			zap.S().Warn("Worker lease renew failed", zap.String("owner", l.owner), zap.Error(err))
+			continue
+		}
+		if ok == 1 {
+			continue
+		}
+
+		// 租约已被他人占用（例如长时间网络分区），换一个 worker ID
+		next, err := AcquireWorker(ctx, l.client, l.owner)
+		if err != nil {
+			zap.S().Error("Worker lease lost and reacquire failed", zap.String("owner", l.owner), zap.Error(err))
+			continue
+		}
+		zap.S().Warn("Worker lease lost, switched worker id",
+			zap.String("owner", l.owner),
+			zap.Int64("old", l.workerID),
+			zap.Int64("new", next.workerID))
+		l.workerID = next.workerID
+		node.SetWorkerID(l.workerID)
+	}
+}
@@ -0,0 +1,107 @@
+// Package idgen 雪花算法 ID 生成器：按时间有序的 64 位 ID，worker ID 从 Redis 租约获取
+package idgen
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 64 位布局：1 位符号 | 41 位毫秒时间戳 | 10 位 worker | 12 位序列
+const (
+	Epoch        int64 = 1704067200000 // 2024-01-01 00:00:00 UTC（毫秒）
+	workerBits         = 10
+	sequenceBits       = 12
+
+	MaxWorkerID = 1<<workerBits - 1
+	maxSequence = 1<<sequenceBits - 1
+
+	timeShift   = workerBits + sequenceBits
+	workerShift = sequenceBits
+
+	// idWidth 字符串形式固定宽度，保证 VARCHAR 字典序与数值序一致
+	idWidth = 19
+)
+
+// Node 单个 worker 的 ID 生成器，并发安全
+type Node struct {
+	mu       sync.Mutex
+	workerID int64
+	lastMs   int64
+	sequence int64
+}
+
+// NewNode 使用指定 worker ID 创建生成器
+func NewNode(workerID int64) (*Node, error) {
+	if workerID < 0 || workerID > MaxWorkerID {
+		return nil, fmt.Errorf("worker id must be between 0 and %d", MaxWorkerID)
+	}
+	return &Node{workerID: workerID}, nil
+}
+
+// SetWorkerID 租约丢失重新获取后切换 worker ID
+func (n *Node) SetWorkerID(workerID int64) {
+	n.mu.Lock()
+	n.workerID = workerID
+	n.mu.Unlock()
+}
+
+// Next 生成下一个 ID；同一毫秒内序列用尽或时钟回拨时等待到下一可用毫秒
+func (n *Node) Next() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	if now < n.lastMs {
+		// 时钟回拨：沿用上次时间戳，避免生成重复或乱序 ID
+		now = n.lastMs
+	}
+	if now == n.lastMs {
+		n.sequence = (n.sequence + 1) & maxSequence
+		if n.sequence == 0 {
+			for now <= n.lastMs {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Now().UnixMilli()
+			}
+		}
+	} else {
+		n.sequence = 0
+	}
+	n.lastMs = now
+
+	return (now-Epoch)<<timeShift | n.workerID<<workerShift | n.sequence
+}
+
+// NextString 生成固定宽度的十进制字符串 ID
+func (n *Node) NextString() string {
+	return Format(n.Next())
+}
+
+// Format 转为固定宽度字符串
+func Format(id int64) string {
+	return fmt.Sprintf("%0*d", idWidth, id)
+}
+
+// Parse 解析字符串 ID
+func Parse(s string) (int64, error) {
+	if len(s) != idWidth {
+		return 0, errors.New("not a snowflake id")
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// Time 返回 ID 中的生成时间
+func Time(id int64) time.Time {
+	return time.UnixMilli(id>>timeShift + Epoch)
+}
+
+// TimeOf 返回字符串 ID 的生成时间，非雪花 ID（历史数据）返回 false
+func TimeOf(s string) (time.Time, bool) {
+	id, err := Parse(s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return Time(id), true
+}
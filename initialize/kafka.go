@@ -7,9 +7,20 @@ import (
 )
 
 func InitProducer() {
+	cfg := &global.ServiceConfig.Kafka
+	if len(cfg.Brokers) == 0 {
+		cfg.Brokers = []string{"localhost:9092"}
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = "im.msg.route"
+	}
+	if cfg.Partitions <= 0 {
+		cfg.Partitions = 3
+	}
+
 	global.Producer = &kafka.Writer{
-		Addr:  kafka.TCP("localhost:9092"),
-		Topic: "im.msg.route",
+		Addr:  kafka.TCP(cfg.Brokers...),
+		Topic: cfg.Topic,
 		// 不设置 Balancer！否则会覆盖 Partition 字段
 		RequiredAcks: kafka.RequireAll,
 	}
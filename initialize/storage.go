@@ -0,0 +1,16 @@
+package initialize
+
+import (
+	"HiChat/global"
+	"HiChat/messagesave"
+)
+
+// InitMessageStorage 消息热存储使用 Redis，冷存储、编辑历史和全文检索使用 MySQL，需在 InitDB、InitRedis 之后调用
+func InitMessageStorage() {
+	messagesave.InitStorage(
+		messagesave.NewRedisMessageStorage(global.RedisDB),
+		messagesave.NewMySQLMessageStorage(global.DB),
+	)
+	messagesave.InitRevisionStorage(messagesave.NewMySQLRevisionStorage(global.DB))
+	messagesave.InitSearchIndex(messagesave.NewMySQLSearchIndex(global.DB))
+}
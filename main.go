@@ -9,6 +9,9 @@ import (
 	"HiChat/router"
 	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
@@ -29,15 +32,19 @@ func main() {
 	//初始化数据库
 	initialize.InitDB()
 	initialize.InitRedis()
+	initialize.InitMessageStorage()
 	initialize.InitProducer()
 
-	gateways := []*messagev2.Gateway{
-		messagev2.NewGateway("gateway-1", 8081),
-		messagev2.NewGateway("gateway-2", 8082),
-		messagev2.NewGateway("gateway-3", 8083),
+	// 离线推送暂未接入 APNs/FCM，先记录日志
+	messagev2.SetPushNotifier(messagev2.LogPushNotifier{})
+
+	// 网关节点来自配置，新增节点只需追加配置或另起进程，partition 由成员注册表动态分配
+	gateways := make([]*messagev2.Gateway, 0, len(global.ServiceConfig.Gateways))
+	for _, gc := range global.ServiceConfig.Gateways {
+		gateways = append(gateways, messagev2.NewGateway(gc.ID, gc.Port))
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	// 启动所有网关（每个监听不同端口）
@@ -54,8 +61,21 @@ func main() {
 		}
 	}()
 
-	go messagesave.StartArchiveJob(5 * time.Minute)
+	// 内部管理接口单独监听，不经过对外端口
+	if addr := global.ServiceConfig.AdminAddr; addr != "" {
+		go func() {
+			if err := router.AdminRouter().Run(addr); err != nil {
+				zap.S().Error("Admin server failed", zap.Error(err))
+			}
+		}()
+	}
 
-	select {}
+	go messagesave.StartArchiveJob(ctx, 5*time.Minute, messagesave.NewRedisArchiveState(global.RedisDB))
+	go messagesave.StartPurgeJob(ctx, 24*time.Hour)
 
+	<-ctx.Done()
+	for _, g := range gateways {
+		g.Leave()
+	}
+	zap.S().Info("HiChat shutting down")
 }
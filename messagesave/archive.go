@@ -0,0 +1,277 @@
+package messagesave
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	archiveLockKey       = "archive:lock"
+	archiveCheckpointKey = "archive:checkpoint" // hash：会话 ID -> 已写入冷存储的最大 seq
+	archiveDeadLetterKey = "archive:deadletter" // hash：消息 ID -> 无法解析的原始数据，hash 已过期的为 "missing: <会话 ID>"
+	archiveBatchSize     = 500
+)
+
+// 归档指标，通过 /debug/vars 暴露
+var archiveMetrics = struct {
+	Runs        *expvar.Int
+	Skipped     *expvar.Int   // 未抢到锁而跳过的轮次
+	Archived    *expvar.Int   // 累计写入冷存储的消息数
+	DeadLetters *expvar.Int   // 累计进入死信的消息数
+	Failures    *expvar.Int   // 会话级失败次数，下轮重试
+	LagSeconds  *expvar.Float // 最近一轮中最早待归档消息超出保留期的秒数
+	LastRun     *expvar.Int   // 最近一轮完成时间（Unix 秒）
+}{
+	Runs:        expvar.NewInt("archive_runs_total"),
+	Skipped:     expvar.NewInt("archive_skipped_total"),
+	Archived:    expvar.NewInt("archive_messages_total"),
+	DeadLetters: expvar.NewInt("archive_dead_letters_total"),
+	Failures:    expvar.NewInt("archive_failures_total"),
+	LagSeconds:  expvar.NewFloat("archive_lag_seconds"),
+	LastRun:     expvar.NewInt("archive_last_run"),
+}
+
+// ArchiveState 归档任务的协调状态：保证只有一个进程在归档，并记录每个会话的检查点
+type ArchiveState interface {
+	// TryLock 获取或续期归档锁，锁被其他 owner 持有时返回 false
+	TryLock(ctx context.Context, owner string, ttl time.Duration) (bool, error)
+	Unlock(ctx context.Context, owner string) error
+	Checkpoint(ctx context.Context, convID string) (int64, error)
+	SetCheckpoint(ctx context.Context, convID string, seq int64) error
+}
+
+// RedisArchiveState 基于 Redis 的归档锁与检查点
+type RedisArchiveState struct {
+	client *redis.Client
+}
+
+func NewRedisArchiveState(client *redis.Client) *RedisArchiveState {
+	return &RedisArchiveState{client: client}
+}
+
+// lockScript 锁空闲时抢占，已持有时续期
+var lockScript = redis.NewScript(`
+local cur = redis.call("GET", KEYS[1])
+if cur == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if not cur then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// unlockScript 只释放自己持有的锁
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (s *RedisArchiveState) TryLock(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	n, err := lockScript.Run(ctx, s.client, []string{archiveLockKey}, owner, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (s *RedisArchiveState) Unlock(ctx context.Context, owner string) error {
+	return unlockScript.Run(ctx, s.client, []string{archiveLockKey}, owner).Err()
+}
+
+func (s *RedisArchiveState) Checkpoint(ctx context.Context, convID string) (int64, error) {
+	seq, err := s.client.HGet(ctx, archiveCheckpointKey, convID).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return seq, err
+}
+
+func (s *RedisArchiveState) SetCheckpoint(ctx context.Context, convID string, seq int64) error {
+	return s.client.HSet(ctx, archiveCheckpointKey, convID, seq).Err()
+}
+
+// StartArchiveJob 周期性地把超出保留期的热数据归档到冷存储，ctx 取消后在当前会话处理完时退出
+func StartArchiveJob(ctx context.Context, interval time.Duration, state ArchiveState) {
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s-%d", host, os.Getpid())
+	if interval >= hotTTL-hotRetention {
+		zap.S().Warn("Archive interval exceeds hot TTL margin, messages may expire before archived", zap.Duration("interval", interval))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		runArchive(ctx, hotStorage, coldStorage, state, owner, interval, time.Now().Add(-hotRetention)) // 7天前
+	}
+}
+
+// runArchive 抢到锁后执行一轮归档；锁的有效期为一个周期，每处理完一个会话续期一次
+func runArchive(ctx context.Context, hot HotStorage, cold MessageStorage, state ArchiveState, owner string, ttl time.Duration, cutoff time.Time) {
+	ok, err := state.TryLock(ctx, owner, ttl)
+	if err != nil {
+		zap.S().Warn("Acquire archive lock failed", zap.Error(err))
+		return
+	}
+	if !ok {
+		archiveMetrics.Skipped.Add(1)
+		return
+	}
+	defer func() {
+		// ctx 可能已经取消，释放锁不能依赖它
+		if err := state.Unlock(context.Background(), owner); err != nil {
+			zap.S().Warn("Release archive lock failed", zap.Error(err))
+		}
+	}()
+
+	archiveMetrics.Runs.Add(1)
+	lag, err := archiveOldMessages(ctx, hot, cold, state, cutoff, func() bool {
+		ok, err := state.TryLock(ctx, owner, ttl)
+		return err == nil && ok
+	})
+	if err != nil {
+		zap.S().Warn("Archive run aborted", zap.Error(err))
+	}
+	archiveMetrics.LagSeconds.Set(lag.Seconds())
+	archiveMetrics.LastRun.Set(time.Now().Unix())
+}
+
+// archiveOldMessages 执行归档逻辑，返回本轮观察到的最大归档延迟；renew 返回 false 时说明锁已丢失，立即停止
+func archiveOldMessages(ctx context.Context, hot HotStorage, cold MessageStorage, state ArchiveState, cutoff time.Time, renew func() bool) (time.Duration, error) {
+	convIDs, err := hot.Conversations(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var maxLag time.Duration
+	for _, convID := range convIDs {
+		if err := ctx.Err(); err != nil {
+			return maxLag, err
+		}
+		if !renew() {
+			return maxLag, fmt.Errorf("archive lock lost")
+		}
+
+		lag, err := archiveConversation(ctx, hot, cold, state, convID, cutoff)
+		if err != nil {
+			archiveMetrics.Failures.Add(1)
+			zap.S().Warn("Archive conversation failed", zap.String("conv", convID), zap.Error(err))
+			continue
+		}
+		if lag > maxLag {
+			maxLag = lag
+		}
+	}
+	return maxLag, nil
+}
+
+// archiveConversation 归档单个会话的消息，每批：写入冷存储（幂等）→ 推进检查点 → 删除热数据。
+// 任一步失败都保留热数据，下轮重试时检查点之前的消息不再重复写入
+func archiveConversation(ctx context.Context, hot HotStorage, cold MessageStorage, state ArchiveState, convID string, cutoff time.Time) (time.Duration, error) {
+	checkpoint, err := state.Checkpoint(ctx, convID)
+	if err != nil {
+		return 0, err
+	}
+
+	var lag time.Duration
+	for {
+		// 查找过期消息（回应随消息一起归档）
+		batch, err := hot.Expired(ctx, convID, cutoff, archiveBatchSize)
+		if err != nil || len(batch) == 0 {
+			return lag, err
+		}
+		if lag == 0 {
+			lag = cutoff.Sub(batch[0].Timestamp)
+		}
+
+		var pending []*Message
+		for _, m := range batch {
+			if m.Seq > checkpoint {
+				pending = append(pending, m)
+			}
+		}
+
+		// 批量写入冷存储
+		if len(pending) > 0 {
+			if err := cold.BatchSave(ctx, pending); err != nil {
+				return lag, err
+			}
+			archiveMetrics.Archived.Add(int64(len(pending)))
+			// 热数据写入时的异步索引可能失败，归档时补齐
+			indexMessages(ctx, pending...)
+			checkpoint = pending[len(pending)-1].Seq
+			if err := state.SetCheckpoint(ctx, convID, checkpoint); err != nil {
+				return lag, err
+			}
+		}
+
+		// 从热存储删除，读出批次之后新增或取消的回应随删除一起取出，补写到冷存储
+		final, err := hot.Remove(ctx, convID, batch)
+		if err != nil {
+			return lag, err
+		}
+		if err := syncArchivedReactions(ctx, cold, batch, pending, final); err != nil {
+			return lag, err
+		}
+		if len(batch) < archiveBatchSize || ctx.Err() != nil {
+			return lag, nil
+		}
+	}
+}
+
+// syncArchivedReactions 把热存储删除时的回应与已写入冷存储的回应对齐。pending 是本轮写入的，
+// 以批次快照为准；检查点之前的消息在之前的轮次写入，以冷存储中的副本为准。
+// 逐条增删而不是整体覆盖，不会冲掉 msg:<id> 删除后已直接写入冷存储的回应
+func syncArchivedReactions(ctx context.Context, cold MessageStorage, batch, pending []*Message, final map[string]Reactions) error {
+	written := make(map[string]bool, len(pending))
+	for _, m := range pending {
+		written[m.ID] = true
+	}
+
+	var firstErr error
+	for _, m := range batch {
+		before := m.Reactions
+		if !written[m.ID] {
+			if len(before) == 0 && len(final[m.ID]) == 0 {
+				continue
+			}
+			stored, err := cold.Get(ctx, m.ID)
+			if errors.Is(err, ErrMessageNotFound) {
+				continue
+			}
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			before = stored.Reactions
+		}
+		for _, c := range reactionDiff(before, final[m.ID]) {
+			if _, _, err := cold.React(ctx, m.ID, c.UserID, c.Emoji, c.Add); err != nil {
+				zap.S().Error("Sync archived reaction failed",
+					zap.String("msg_id", m.ID),
+					zap.String("emoji", c.Emoji),
+					zap.String("user", c.UserID),
+					zap.Bool("add", c.Add),
+					zap.Error(err))
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,173 @@
+package messagesave
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 归档任务按 5 分钟周期运行，key 的过期时间必须在保留期之后留出足够余量
+func TestHotTTLOutlivesRetention(t *testing.T) {
+	if margin := hotTTL - hotRetention; margin < 24*time.Hour {
+		t.Fatalf("hot TTL margin %v is too small", margin)
+	}
+}
+
+func TestArchiveConversationMovesExpired(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := NewMemoryMessageStorage(), NewMemoryMessageStorage()
+	state := NewMemoryArchiveState()
+	now := time.Now()
+	cutoff := now.Add(-hotRetention)
+
+	conv := "user:1:2"
+	for i, ts := range []time.Time{
+		cutoff.Add(-2 * time.Hour),
+		cutoff.Add(-time.Hour),
+		cutoff.Add(time.Hour), // 未过期
+	} {
+		if err := hot.Save(ctx, newTestMessage(string(rune('a'+i)), conv, ts)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lag, err := archiveConversation(ctx, hot, cold, state, conv, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag != 2*time.Hour {
+		t.Fatalf("lag = %v, want 2h", lag)
+	}
+
+	archived, _ := cold.List(ctx, conv, 0, Forward, 10)
+	if got := seqsOf(archived); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("cold = %v, want [1 2]", got)
+	}
+	remaining, _ := hot.List(ctx, conv, 0, Forward, 10)
+	if got := seqsOf(remaining); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("hot = %v, want [3]", got)
+	}
+	if cp, _ := state.Checkpoint(ctx, conv); cp != 2 {
+		t.Fatalf("checkpoint = %d, want 2", cp)
+	}
+
+	// 再跑一轮不会重复写入
+	if _, err := archiveConversation(ctx, hot, cold, state, conv, cutoff); err != nil {
+		t.Fatal(err)
+	}
+	archived, _ = cold.List(ctx, conv, 0, Forward, 10)
+	if len(archived) != 2 {
+		t.Fatalf("cold after rerun = %v", seqsOf(archived))
+	}
+}
+
+// 检查点之前的消息（上一轮写入冷存储后删除热数据失败）只删除不重复写入
+func TestArchiveConversationSkipsCheckpointed(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := NewMemoryMessageStorage(), NewMemoryMessageStorage()
+	state := NewMemoryArchiveState()
+	cutoff := time.Now()
+
+	conv := "group:7"
+	for _, id := range []string{"a", "b"} {
+		if err := hot.Save(ctx, newTestMessage(id, conv, cutoff.Add(-time.Hour))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state.SetCheckpoint(ctx, conv, 1)
+
+	if _, err := archiveConversation(ctx, hot, cold, state, conv, cutoff); err != nil {
+		t.Fatal(err)
+	}
+	archived, _ := cold.List(ctx, conv, 0, Forward, 10)
+	if got := seqsOf(archived); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("cold = %v, want [2]", got)
+	}
+	if remaining, _ := hot.List(ctx, conv, 0, Forward, 10); len(remaining) != 0 {
+		t.Fatalf("hot = %v, want empty", seqsOf(remaining))
+	}
+}
+
+func TestRunArchiveSkipsWhenLocked(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := NewMemoryMessageStorage(), NewMemoryMessageStorage()
+	state := NewMemoryArchiveState()
+	cutoff := time.Now()
+
+	if err := hot.Save(ctx, newTestMessage("a", "user:1:2", cutoff.Add(-time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := state.TryLock(ctx, "other", time.Minute); !ok {
+		t.Fatal("lock not acquired")
+	}
+
+	runArchive(ctx, hot, cold, state, "me", time.Minute, cutoff)
+	if archived, _ := cold.List(ctx, "user:1:2", 0, Forward, 10); len(archived) != 0 {
+		t.Fatal("archived without holding the lock")
+	}
+
+	state.Unlock(ctx, "other")
+	runArchive(ctx, hot, cold, state, "me", time.Minute, cutoff)
+	if archived, _ := cold.List(ctx, "user:1:2", 0, Forward, 10); len(archived) != 1 {
+		t.Fatal("not archived after the lock was released")
+	}
+}
+
+// reactDuringArchive 模拟归档读出批次之后、删除热数据之前有用户回应
+type reactDuringArchive struct {
+	*MemoryMessageStorage
+	react func()
+}
+
+func (s reactDuringArchive) Remove(ctx context.Context, convID string, messages []*Message) (map[string]Reactions, error) {
+	s.react()
+	return s.MemoryMessageStorage.Remove(ctx, convID, messages)
+}
+
+func TestArchiveKeepsReactionsAddedDuringArchive(t *testing.T) {
+	ctx := context.Background()
+	mem, cold := NewMemoryMessageStorage(), NewMemoryMessageStorage()
+	state := NewMemoryArchiveState()
+	cutoff := time.Now()
+
+	conv := "group:9"
+	if err := mem.Save(ctx, newTestMessage("a", conv, cutoff.Add(-time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	mem.React(ctx, "a", "1", "👍", true)
+	mem.React(ctx, "a", "2", "👍", true)
+
+	hot := reactDuringArchive{mem, func() {
+		mem.React(ctx, "a", "3", "🎉", true)
+		mem.React(ctx, "a", "2", "👍", false)
+	}}
+	if _, err := archiveConversation(ctx, hot, cold, state, conv, cutoff); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := cold.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Reactions{"👍": {"1"}, "🎉": {"3"}}
+	if !reflect.DeepEqual(got.Reactions, want) {
+		t.Fatalf("cold reactions = %v, want %v", got.Reactions, want)
+	}
+}
+
+func TestReactionDiff(t *testing.T) {
+	before := Reactions{"👍": {"1", "2"}, "😂": {"5"}}
+	after := Reactions{"👍": {"1"}, "🎉": {"3"}}
+	want := []reactionChange{
+		{"🎉", "3", true},
+		{"👍", "2", false},
+		{"😂", "5", false},
+	}
+	if got := reactionDiff(before, after); !reflect.DeepEqual(got, want) {
+		t.Fatalf("diff = %v, want %v", got, want)
+	}
+	if got := reactionDiff(after, after); len(got) != 0 {
+		t.Fatalf("diff of equal reactions = %v", got)
+	}
+}
@@ -0,0 +1,51 @@
+package messagesave
+
+import (
+	"context"
+)
+
+// HistoryService 历史消息查询：先读 Redis 热数据，热数据翻完后继续从 MySQL 冷数据往前翻
+type HistoryService struct {
+	hot  MessageStorage
+	cold MessageStorage
+}
+
+func NewHistoryService(hot, cold MessageStorage) *HistoryService {
+	return &HistoryService{hot: hot, cold: cold}
+}
+
+// DefaultHistory 基于 InitStorage 注入的存储实现的历史查询服务
+func DefaultHistory() *HistoryService {
+	return NewHistoryService(hotStorage, coldStorage)
+}
+
+// Before 返回 seq 小于 cursor 的最多 limit 条消息（新的在前），cursor <= 0 表示从最新一条开始；
+// 返回的 next 为下一页游标（本页最早一条的 seq）
+func (h *HistoryService) Before(ctx context.Context, convID string, cursor int64, limit int) (msgs []*Message, next int64, hasMore bool, err error) {
+	msgs, err = h.hot.List(ctx, convID, cursor, Backward, limit+1)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if len(msgs) <= limit {
+		// Redis 中的范围已经翻完，从最早一条热数据处接着查冷数据
+		coldCursor := cursor
+		if len(msgs) > 0 {
+			coldCursor = msgs[len(msgs)-1].Seq
+		}
+		cold, err := h.cold.List(ctx, convID, coldCursor, Backward, limit+1-len(msgs))
+		if err != nil {
+			return nil, 0, false, err
+		}
+		msgs = append(msgs, cold...)
+	}
+
+	hasMore = len(msgs) > limit
+	if hasMore {
+		msgs = msgs[:limit]
+	}
+	if len(msgs) > 0 {
+		next = msgs[len(msgs)-1].Seq
+	}
+	return msgs, next, hasMore, nil
+}
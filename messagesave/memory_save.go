@@ -0,0 +1,303 @@
+package messagesave
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryMessageStorage 纯内存实现，可同时充当热存储与冷存储，用于单元测试和本地调试
+type MemoryMessageStorage struct {
+	mu        sync.RWMutex
+	convs     map[string][]*Message // 会话 ID -> 按 seq 升序的消息
+	seqs      map[string]int64      // 会话 ID -> 已分配的最大 seq
+	clientIDs map[string]string     // "<会话 ID>:<ClientMsgID>" -> 消息 ID
+}
+
+func NewMemoryMessageStorage() *MemoryMessageStorage {
+	return &MemoryMessageStorage{
+		convs:     make(map[string][]*Message),
+		seqs:      make(map[string]int64),
+		clientIDs: make(map[string]string),
+	}
+}
+
+// Save 保存消息；未分配 seq 的消息（热存储写入）在会话内分配下一个 seq，ClientMsgID 重复时行为与 Redis 一致
+func (m *MemoryMessageStorage) Save(ctx context.Context, msg *Message) error {
+	if msg.ID == "" || msg.ConversationID == "" || msg.Timestamp.IsZero() {
+		return fmt.Errorf("消息缺少必要字段")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if msg.ClientMsgID != "" {
+		key := msg.ConversationID + ":" + msg.ClientMsgID
+		if origID, ok := m.clientIDs[key]; ok {
+			for _, stored := range m.convs[msg.ConversationID] {
+				if stored.ID == origID {
+					*msg = *stored
+					break
+				}
+			}
+			return ErrDuplicateMessage
+		}
+		m.clientIDs[key] = msg.ID
+	}
+
+	if msg.Seq == 0 {
+		m.seqs[msg.ConversationID]++
+		msg.Seq = m.seqs[msg.ConversationID]
+	} else if msg.Seq > m.seqs[msg.ConversationID] {
+		m.seqs[msg.ConversationID] = msg.Seq
+	}
+	m.put(msg)
+	return nil
+}
+
+// BatchSave 逐条保存，重复消息跳过
+func (m *MemoryMessageStorage) BatchSave(ctx context.Context, messages []*Message) error {
+	for _, msg := range messages {
+		if err := m.Save(ctx, msg); err != nil && err != ErrDuplicateMessage {
+			return err
+		}
+	}
+	return nil
+}
+
+// put 按 seq 有序插入副本，相同 ID 覆盖（与 MySQL 重复归档的结果一致）
+func (m *MemoryMessageStorage) put(msg *Message) {
+	cp := *msg
+	list := m.convs[msg.ConversationID]
+	for i, stored := range list {
+		if stored.ID == msg.ID {
+			list[i] = &cp
+			return
+		}
+	}
+	i := sort.Search(len(list), func(i int) bool { return list[i].Seq > cp.Seq })
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = &cp
+	m.convs[msg.ConversationID] = list
+}
+
+// List 按会话内 seq 翻页
+func (m *MemoryMessageStorage) List(ctx context.Context, convID string, cursor int64, dir Direction, limit int) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := m.convs[convID]
+	result := make([]*Message, 0, limit)
+	if dir == Backward {
+		for i := len(list) - 1; i >= 0 && len(result) < limit; i-- {
+			if cursor > 0 && list[i].Seq >= cursor {
+				continue
+			}
+			cp := *list[i]
+			result = append(result, &cp)
+		}
+	} else {
+		for i := 0; i < len(list) && len(result) < limit; i++ {
+			if list[i].Seq <= cursor {
+				continue
+			}
+			cp := *list[i]
+			result = append(result, &cp)
+		}
+	}
+	return result, nil
+}
+
+// find 按 ID 查找消息，返回所在会话的下标
+func (m *MemoryMessageStorage) find(msgID string) (string, int, bool) {
+	for convID, list := range m.convs {
+		for i, msg := range list {
+			if msg.ID == msgID {
+				return convID, i, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+// Get 按 ID 查找消息
+func (m *MemoryMessageStorage) Get(ctx context.Context, msgID string) (*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	convID, i, ok := m.find(msgID)
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	cp := *m.convs[convID][i]
+	return &cp, nil
+}
+
+// Update 持有写锁期间修改消息副本，fn 成功后替换原消息
+func (m *MemoryMessageStorage) Update(ctx context.Context, msgID string, fn UpdateFunc) (*Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	convID, i, ok := m.find(msgID)
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	cp := *m.convs[convID][i]
+	if err := fn(ctx, &cp); err != nil {
+		return nil, err
+	}
+	stored := cp
+	m.convs[convID][i] = &stored
+	return &cp, nil
+}
+
+// React 持有写锁期间修改消息的回应
+func (m *MemoryMessageStorage) React(ctx context.Context, msgID, userID, emoji string, add bool) (bool, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	convID, i, ok := m.find(msgID)
+	if !ok {
+		return false, 0, ErrMessageNotFound
+	}
+	cp := *m.convs[convID][i]
+	next, changed, count := applyReaction(cp.Reactions, userID, emoji, add)
+	if changed {
+		cp.Reactions = next
+		m.convs[convID][i] = &cp
+	}
+	return changed, count, nil
+}
+
+// ListThread 按 seq 升序返回话题中的回复
+func (m *MemoryMessageStorage) ListThread(ctx context.Context, root *Message, afterSeq int64, limit int) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var result []*Message
+	for _, msg := range m.convs[root.ConversationID] {
+		if len(result) >= limit {
+			break
+		}
+		if msg.ThreadRoot != root.ID || msg.Seq <= afterSeq {
+			continue
+		}
+		cp := *msg
+		result = append(result, &cp)
+	}
+	return result, nil
+}
+
+// LatestSeqs 返回会话已分配的最大 seq
+func (m *MemoryMessageStorage) LatestSeqs(ctx context.Context, convIDs []string) (map[string]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seqs := make(map[string]int64, len(convIDs))
+	for _, convID := range convIDs {
+		seqs[convID] = m.seqs[convID]
+	}
+	return seqs, nil
+}
+
+// Conversations 返回所有有消息的会话
+func (m *MemoryMessageStorage) Conversations(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	convIDs := make([]string, 0, len(m.convs))
+	for convID, list := range m.convs {
+		if len(list) > 0 {
+			convIDs = append(convIDs, convID)
+		}
+	}
+	sort.Strings(convIDs)
+	return convIDs, nil
+}
+
+// Expired 按 seq 升序返回会话开头连续的已过期消息
+func (m *MemoryMessageStorage) Expired(ctx context.Context, convID string, cutoff time.Time, limit int) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var result []*Message
+	for _, msg := range m.convs[convID] {
+		if len(result) >= limit || msg.Timestamp.After(cutoff) {
+			break
+		}
+		cp := *msg
+		result = append(result, &cp)
+	}
+	return result, nil
+}
+
+// Remove 删除指定消息并返回删除时的回应，已分配的 seq 不回收
+func (m *MemoryMessageStorage) Remove(ctx context.Context, convID string, messages []*Message) (map[string]Reactions, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	drop := make(map[string]bool, len(messages))
+	for _, msg := range messages {
+		drop[msg.ID] = true
+	}
+	reactions := make(map[string]Reactions)
+	list := m.convs[convID]
+	kept := list[:0]
+	for _, msg := range list {
+		if !drop[msg.ID] {
+			kept = append(kept, msg)
+		} else if len(msg.Reactions) > 0 {
+			reactions[msg.ID] = msg.Reactions
+		}
+	}
+	m.convs[convID] = kept
+	return reactions, nil
+}
+
+// MemoryArchiveState 单进程内的归档锁与检查点
+type MemoryArchiveState struct {
+	mu          sync.Mutex
+	owner       string
+	expiresAt   time.Time
+	checkpoints map[string]int64
+}
+
+func NewMemoryArchiveState() *MemoryArchiveState {
+	return &MemoryArchiveState{checkpoints: make(map[string]int64)}
+}
+
+func (s *MemoryArchiveState) TryLock(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner != "" && s.owner != owner && time.Now().Before(s.expiresAt) {
+		return false, nil
+	}
+	s.owner = owner
+	s.expiresAt = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (s *MemoryArchiveState) Unlock(ctx context.Context, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner == owner {
+		s.owner = ""
+	}
+	return nil
+}
+
+func (s *MemoryArchiveState) Checkpoint(ctx context.Context, convID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkpoints[convID], nil
+}
+
+func (s *MemoryArchiveState) SetCheckpoint(ctx context.Context, convID string, seq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[convID] = seq
+	return nil
+}
@@ -0,0 +1,106 @@
+package messagesave
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestMessage(id, convID string, ts time.Time) *Message {
+	return &Message{
+		ID:             id,
+		ConversationID: convID,
+		SenderID:       "1",
+		Content:        []byte("hello " + id),
+		MsgType:        "text",
+		Timestamp:      ts,
+	}
+}
+
+func TestMemorySaveAssignsSeq(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryMessageStorage()
+	now := time.Now()
+
+	for i, id := range []string{"a", "b", "c"} {
+		msg := newTestMessage(id, "user:1:2", now)
+		if err := s.Save(ctx, msg); err != nil {
+			t.Fatalf("save %s: %v", id, err)
+		}
+		if msg.Seq != int64(i+1) {
+			t.Fatalf("seq of %s = %d, want %d", id, msg.Seq, i+1)
+		}
+	}
+
+	// 不同会话的 seq 相互独立
+	other := newTestMessage("d", "group:9", now)
+	if err := s.Save(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if other.Seq != 1 {
+		t.Fatalf("seq in another conversation = %d, want 1", other.Seq)
+	}
+}
+
+func TestMemorySaveDeduplicatesClientMsgID(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryMessageStorage()
+	now := time.Now()
+
+	first := newTestMessage("a", "user:1:2", now)
+	first.ClientMsgID = "c1"
+	if err := s.Save(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	retry := newTestMessage("b", "user:1:2", now)
+	retry.ClientMsgID = "c1"
+	if err := s.Save(ctx, retry); !errors.Is(err, ErrDuplicateMessage) {
+		t.Fatalf("err = %v, want ErrDuplicateMessage", err)
+	}
+	if retry.ID != "a" || retry.Seq != first.Seq {
+		t.Fatalf("duplicate not backfilled: id=%s seq=%d", retry.ID, retry.Seq)
+	}
+
+	// 重复消息不占用 seq
+	next := newTestMessage("c", "user:1:2", now)
+	if err := s.Save(ctx, next); err != nil {
+		t.Fatal(err)
+	}
+	if next.Seq != 2 {
+		t.Fatalf("seq after duplicate = %d, want 2", next.Seq)
+	}
+}
+
+func TestMemoryListDirections(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryMessageStorage()
+	now := time.Now()
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if err := s.Save(ctx, newTestMessage(id, "user:1:2", now)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	back, _ := s.List(ctx, "user:1:2", 0, Backward, 2)
+	if len(back) != 2 || back[0].Seq != 4 || back[1].Seq != 3 {
+		t.Fatalf("backward from latest = %v", seqsOf(back))
+	}
+	back, _ = s.List(ctx, "user:1:2", 3, Backward, 10)
+	if len(back) != 2 || back[0].Seq != 2 || back[1].Seq != 1 {
+		t.Fatalf("backward before 3 = %v", seqsOf(back))
+	}
+	fwd, _ := s.List(ctx, "user:1:2", 2, Forward, 10)
+	if len(fwd) != 2 || fwd[0].Seq != 3 || fwd[1].Seq != 4 {
+		t.Fatalf("forward after 2 = %v", seqsOf(fwd))
+	}
+}
+
+func seqsOf(msgs []*Message) []int64 {
+	seqs := make([]int64, 0, len(msgs))
+	for _, m := range msgs {
+		seqs = append(seqs, m.Seq)
+	}
+	return seqs
+}
@@ -2,44 +2,173 @@ package messagesave
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// MySQLMessageStorage 使用外部传入的 *gorm.DB，不再自己创建
+// MySQLMessageStorage 使用外部传入的 *gorm.DB，不再自己创建；消息按会话类型和月份写入分表
 type MySQLMessageStorage struct {
 	db *gorm.DB
 }
 
-// 构造函数改为接收已存在的 *gorm.DB
+// 构造函数改为接收已存在的 *gorm.DB，表结构由 migrate 负责
 func NewMySQLMessageStorage(db *gorm.DB) *MySQLMessageStorage {
-	// 确保表结构存在（可放在 migrations 中更好）
-	db.AutoMigrate(&Message{})
-
 	return &MySQLMessageStorage{db: db}
 }
 
 // Save 保存单条消息
 func (m *MySQLMessageStorage) Save(ctx context.Context, msg *Message) error {
-	return m.db.WithContext(ctx).Create(msg).Error
+	return m.BatchSave(ctx, []*Message{msg})
 }
 
-// List 按会话和时间范围查询消息（倒序：最新在前）
-func (m *MySQLMessageStorage) List(ctx context.Context, convID string, start, end time.Time, limit int) ([]*Message, error) {
+// List 按会话内 seq 翻页，依次扫描各月分表直到凑够 limit 条
+func (m *MySQLMessageStorage) List(ctx context.Context, convID string, cursor int64, dir Direction, limit int) ([]*Message, error) {
+	tables, err := readTables(ctx, m.db, chatTypeOf(convID), time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if dir == Backward {
+		// 从最新的分表往前翻
+		for i, j := 0, len(tables)-1; i < j; i, j = i+1, j-1 {
+			tables[i], tables[j] = tables[j], tables[i]
+		}
+	}
+
 	var msgs []*Message
-	err := m.db.WithContext(ctx).
-		Where("conversation_id = ? AND timestamp > ? AND timestamp <= ?", convID, start, end).
-		Order("timestamp DESC").
-		Limit(limit).
-		Find(&msgs).Error
-	return msgs, err
+	for _, table := range tables {
+		var part []*Message
+		q := m.db.WithContext(ctx).Table(table).Where("conversation_id = ?", convID)
+		if dir == Backward {
+			if cursor > 0 {
+				q = q.Where("seq < ?", cursor)
+			}
+			q = q.Order("seq DESC")
+		} else {
+			q = q.Where("seq > ?", cursor).Order("seq ASC")
+		}
+		if err := q.Limit(limit - len(msgs)).Find(&part).Error; err != nil {
+			// 分表已被其他进程删除（超出保留时间），跳过并刷新缓存
+			if isMissingTable(err) {
+				expirePartitions(chatTypeOf(convID))
+				continue
+			}
+			return nil, err
+		}
+		msgs = append(msgs, part...)
+		if len(msgs) >= limit {
+			break
+		}
+	}
+	return msgs, nil
 }
 
-// BatchSave 批量保存（归档时性能关键）
+// BatchSave 批量保存（归档时性能关键），按分表分组写入；主键冲突时覆盖，重复归档不会报错也不会产生重复行
 func (m *MySQLMessageStorage) BatchSave(ctx context.Context, messages []*Message) error {
 	if len(messages) == 0 {
 		return nil
 	}
-	return m.db.WithContext(ctx).CreateInBatches(messages, 100).Error
+
+	groups := make(map[string][]*Message)
+	for _, msg := range messages {
+		table := partitionFor(msg)
+		groups[table] = append(groups[table], msg)
+	}
+	for table, group := range groups {
+		if err := ensurePartition(ctx, m.db, table); err != nil {
+			return err
+		}
+		if err := m.db.WithContext(ctx).Table(table).
+			Clauses(clause.OnConflict{UpdateAll: true}).
+			CreateInBatches(group, 100).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txKey ctx 中携带的 MySQL 事务，Update 的回调在同一事务内读写其他表时使用
+type txKey struct{}
+
+// dbFrom 优先使用 ctx 中的事务
+func dbFrom(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx
+	}
+	return db.WithContext(ctx)
+}
+
+// Get 按 ID 查找已归档消息
+func (m *MySQLMessageStorage) Get(ctx context.Context, msgID string) (*Message, error) {
+	msg, _, err := findCold(ctx, m.db, msgID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	return msg, err
+}
+
+// Update 在事务中对消息所在行加锁后修改，只写回可变列（表情回应由 React 单独维护）；
+// 事务通过 ctx 传给 fn，fn 中经 dbFrom 的写入与消息修改一起提交或回滚
+func (m *MySQLMessageStorage) Update(ctx context.Context, msgID string, fn UpdateFunc) (*Message, error) {
+	_, table, err := findCold(ctx, m.db, msgID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var updated *Message
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		msg := &Message{}
+		if err := tx.Table(table).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", msgID).First(msg).Error; err != nil {
+			return err
+		}
+		if err := fn(context.WithValue(ctx, txKey{}, tx), msg); err != nil {
+			return err
+		}
+		if err := tx.Table(table).Where("id = ?", msgID).
+			Select("content", "recalled", "revision_count", "edited_at").
+			Updates(msg).Error; err != nil {
+			return err
+		}
+		updated = msg
+		return nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	return updated, err
+}
+
+// ListThread 回复不会早于根消息，只扫描根消息所在月份及之后的分表
+func (m *MySQLMessageStorage) ListThread(ctx context.Context, root *Message, afterSeq int64, limit int) ([]*Message, error) {
+	tables, err := readTables(ctx, m.db, chatTypeOf(root.ConversationID), root.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []*Message
+	for _, table := range tables {
+		var part []*Message
+		if err := m.db.WithContext(ctx).Table(table).
+			Where("thread_root = ? AND seq > ?", root.ID, afterSeq).
+			Order("seq ASC").
+			Limit(limit - len(msgs)).
+			Find(&part).Error; err != nil {
+			if isMissingTable(err) {
+				expirePartitions(chatTypeOf(root.ConversationID))
+				continue
+			}
+			return nil, err
+		}
+		msgs = append(msgs, part...)
+		if len(msgs) >= limit {
+			break
+		}
+	}
+	return msgs, nil
 }
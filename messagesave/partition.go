@@ -0,0 +1,396 @@
+package messagesave
+
+import (
+	"HiChat/global"
+	"HiChat/idgen"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// 冷数据按会话类型和月份分表：messages_cold_<private|group>_<YYYYMM>，月份取消息 Timestamp（UTC）。
+// messages_cold 作为建表模板，同时保存分表前归档的历史数据，查询时视为最早的一张表
+const (
+	legacyColdTable     = "messages_cold"
+	partitionListMaxAge = time.Minute
+	// partitionMissRefresh 缓存中缺少最新月表时重新列出的最小间隔
+	partitionMissRefresh = 5 * time.Second
+	// mysqlErrNoSuchTable MySQL 的 ER_NO_SUCH_TABLE
+	mysqlErrNoSuchTable = 1146
+)
+
+var chatTypes = []string{"private", "group"}
+
+// partitions 已知分表的进程内缓存
+var partitions = struct {
+	sync.Mutex
+	tables   map[string][]string // 会话类型 -> 按月份升序的分表
+	listedAt map[string]time.Time
+}{
+	tables:   make(map[string][]string),
+	listedAt: make(map[string]time.Time),
+}
+
+// chatTypeOf 根据会话 ID 判断会话类型
+func chatTypeOf(convID string) string {
+	if strings.HasPrefix(convID, "group:") {
+		return "group"
+	}
+	return "private"
+}
+
+func partitionName(chatType string, t time.Time) string {
+	return fmt.Sprintf("%s_%s_%s", legacyColdTable, chatType, t.UTC().Format("200601"))
+}
+
+// chatTypeOfTable 分表对应的会话类型，历史表返回空串
+func chatTypeOfTable(table string) string {
+	for _, chatType := range chatTypes {
+		if strings.HasPrefix(table, legacyColdTable+"_"+chatType+"_") {
+			return chatType
+		}
+	}
+	return ""
+}
+
+// partitionFor 消息应写入的分表
+func partitionFor(msg *Message) string {
+	return partitionName(chatTypeOf(msg.ConversationID), msg.Timestamp)
+}
+
+// partitionMonth 解析分表对应月份的第一天
+func partitionMonth(table string) (time.Time, bool) {
+	i := strings.LastIndex(table, "_")
+	if i < 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("200601", table[i+1:])
+	return t, err == nil
+}
+
+// listPartitions 返回某类会话已存在的分表（按月份升序），结果缓存一分钟。
+// 其他进程建表或删表后缓存会过时，查不到消息或表不存在时调用 refreshPartitions 重新列出
+func listPartitions(ctx context.Context, db *gorm.DB, chatType string) ([]string, error) {
+	partitions.Lock()
+	defer partitions.Unlock()
+
+	if time.Since(partitions.listedAt[chatType]) < partitionListMaxAge {
+		return partitions.tables[chatType], nil
+	}
+	return loadPartitions(ctx, db, chatType)
+}
+
+// refreshPartitions 忽略缓存重新列出分表，返回分表列表是否有变化
+func refreshPartitions(ctx context.Context, db *gorm.DB, chatType string) (bool, error) {
+	partitions.Lock()
+	defer partitions.Unlock()
+
+	before := partitions.tables[chatType]
+	tables, err := loadPartitions(ctx, db, chatType)
+	if err != nil {
+		return false, err
+	}
+	return !equalTables(before, tables), nil
+}
+
+// loadPartitions 查询分表并写入缓存，调用方持有锁
+func loadPartitions(ctx context.Context, db *gorm.DB, chatType string) ([]string, error) {
+	var tables []string
+	pattern := fmt.Sprintf("%s\\_%s\\_%%", legacyColdTable, chatType)
+	if err := db.WithContext(ctx).Raw("SHOW TABLES LIKE ?", pattern).Scan(&tables).Error; err != nil {
+		return nil, err
+	}
+	sort.Strings(tables)
+	partitions.tables[chatType] = tables
+	partitions.listedAt[chatType] = time.Now()
+	return tables, nil
+}
+
+func equalTables(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isMissingTable 判断是否为表不存在的错误（分表已被其他进程删除）
+func isMissingTable(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == mysqlErrNoSuchTable
+}
+
+// expirePartitions 发现缓存的分表已被删除时让下次查询重新列出
+func expirePartitions(chatType string) {
+	partitions.Lock()
+	defer partitions.Unlock()
+	delete(partitions.listedAt, chatType)
+}
+
+// ensurePartition 以 messages_cold 为模板创建分表（含索引）
+func ensurePartition(ctx context.Context, db *gorm.DB, table string) error {
+	chatType := strings.TrimPrefix(table, legacyColdTable+"_")
+	chatType = chatType[:strings.Index(chatType, "_")]
+
+	tables, err := listPartitions(ctx, db, chatType)
+	if err != nil {
+		return err
+	}
+	i := sort.SearchStrings(tables, table)
+	if i < len(tables) && tables[i] == table {
+		return nil
+	}
+
+	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` LIKE `%s`", table, legacyColdTable)
+	if err := db.WithContext(ctx).Exec(sql).Error; err != nil {
+		return err
+	}
+
+	partitions.Lock()
+	defer partitions.Unlock()
+	list := partitions.tables[chatType]
+	i = sort.SearchStrings(list, table)
+	if i == len(list) || list[i] != table {
+		list = append(list, "")
+		copy(list[i+1:], list[i:])
+		list[i] = table
+		partitions.tables[chatType] = list
+	}
+	return nil
+}
+
+// forgetPartition 分表被删除后从缓存中移除
+func forgetPartition(chatType, table string) {
+	partitions.Lock()
+	defer partitions.Unlock()
+	list := partitions.tables[chatType]
+	for i, t := range list {
+		if t == table {
+			partitions.tables[chatType] = append(list[:i:i], list[i+1:]...)
+			return
+		}
+	}
+}
+
+// readTables 返回查询某类会话需要扫描的表：since 所在月份及之后的分表加上历史表，按时间升序。
+// 归档可能写入的最新月表不在缓存中时，可能是其他进程刚建的，重新列出（至多每 partitionMissRefresh 一次）
+func readTables(ctx context.Context, db *gorm.DB, chatType string, since time.Time) ([]string, error) {
+	tables, err := listPartitions(ctx, db, chatType)
+	if err != nil {
+		return nil, err
+	}
+	latest := partitionName(chatType, time.Now().Add(-hotRetention))
+	if i := sort.SearchStrings(tables, latest); i == len(tables) || tables[i] != latest {
+		partitions.Lock()
+		stale := time.Since(partitions.listedAt[chatType]) >= partitionMissRefresh
+		partitions.Unlock()
+		if stale {
+			if _, err := refreshPartitions(ctx, db, chatType); err != nil {
+				return nil, err
+			}
+			if tables, err = listPartitions(ctx, db, chatType); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result := []string{legacyColdTable}
+	for _, t := range tables {
+		if !since.IsZero() && t < partitionName(chatType, since) {
+			continue
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// locateCold 找到已归档消息所在的表；缓存中没有消息所在的分表时重新列出一次
+func locateCold(ctx context.Context, db *gorm.DB, msg *Message) (string, error) {
+	chatType := chatTypeOf(msg.ConversationID)
+	table, err := locateColdIn(ctx, db, msg, chatType)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return table, err
+	}
+	changed, err := refreshPartitions(ctx, db, chatType)
+	if err != nil {
+		return "", err
+	}
+	if !changed {
+		return "", gorm.ErrRecordNotFound
+	}
+	return locateColdIn(ctx, db, msg, chatType)
+}
+
+func locateColdIn(ctx context.Context, db *gorm.DB, msg *Message, chatType string) (string, error) {
+	candidates := []string{partitionFor(msg), legacyColdTable}
+	tables, err := listPartitions(ctx, db, chatType)
+	if err != nil {
+		return "", err
+	}
+	if i := sort.SearchStrings(tables, candidates[0]); i == len(tables) || tables[i] != candidates[0] {
+		candidates = candidates[1:]
+	}
+
+	for _, table := range candidates {
+		var n int64
+		if err := db.WithContext(ctx).Table(table).Where("id = ?", msg.ID).Count(&n).Error; err != nil {
+			if isMissingTable(err) {
+				expirePartitions(chatType)
+				continue
+			}
+			return "", err
+		}
+		if n > 0 {
+			return table, nil
+		}
+	}
+	return "", gorm.ErrRecordNotFound
+}
+
+// findCold 按 ID 查找已归档消息及其所在的表：雪花 ID 可以推算出月份，否则逐表查找。
+// 未找到时重新列出分表，分表有变化（其他进程新建了月表）再查一次
+func findCold(ctx context.Context, db *gorm.DB, msgID string) (*Message, string, error) {
+	msg, table, err := findColdIn(ctx, db, msgID)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return msg, table, err
+	}
+	changed := false
+	for _, chatType := range chatTypes {
+		c, err := refreshPartitions(ctx, db, chatType)
+		if err != nil {
+			return nil, "", err
+		}
+		changed = changed || c
+	}
+	if !changed {
+		return nil, "", gorm.ErrRecordNotFound
+	}
+	return findColdIn(ctx, db, msgID)
+}
+
+func findColdIn(ctx context.Context, db *gorm.DB, msgID string) (*Message, string, error) {
+	var candidates []string
+	if t, ok := idgen.TimeOf(msgID); ok {
+		for _, chatType := range chatTypes {
+			candidates = append(candidates, partitionName(chatType, t))
+			// ID 先于 Timestamp 生成，跨月时消息可能落在下个月的分表
+			if next := t.Add(time.Minute); partitionName(chatType, next) != partitionName(chatType, t) {
+				candidates = append(candidates, partitionName(chatType, next))
+			}
+		}
+	} else {
+		for _, chatType := range chatTypes {
+			tables, err := listPartitions(ctx, db, chatType)
+			if err != nil {
+				return nil, "", err
+			}
+			candidates = append(candidates, tables...)
+		}
+	}
+	candidates = append(candidates, legacyColdTable)
+
+	existing := make(map[string]bool)
+	for _, chatType := range chatTypes {
+		tables, err := listPartitions(ctx, db, chatType)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, t := range tables {
+			existing[t] = true
+		}
+	}
+
+	for _, table := range candidates {
+		if table != legacyColdTable && !existing[table] {
+			continue
+		}
+		msg := &Message{}
+		err := db.WithContext(ctx).Table(table).Where("id = ?", msgID).First(msg).Error
+		if err == nil {
+			return msg, table, nil
+		}
+		if isMissingTable(err) {
+			expirePartitions(chatTypeOfTable(table))
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", err
+		}
+	}
+	return nil, "", gorm.ErrRecordNotFound
+}
+
+// retention 会话类型的冷数据保留时间，未配置或为 0 时永久保留
+func retention(chatType string) time.Duration {
+	return global.ServiceConfig.Message.Retention[chatType]
+}
+
+// StartPurgeJob 周期性删除超出保留时间的分表
+func StartPurgeJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purgeExpired(ctx, global.DB, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeExpired 整月都超出保留时间的分表直接 DROP，历史表按行删除
+func purgeExpired(ctx context.Context, db *gorm.DB, now time.Time) {
+	for _, chatType := range chatTypes {
+		keep := retention(chatType)
+		if keep <= 0 {
+			continue
+		}
+		cutoff := now.Add(-keep)
+
+		tables, err := listPartitions(ctx, db, chatType)
+		if err != nil {
+			zap.S().Warn("List cold partitions failed", zap.String("type", chatType), zap.Error(err))
+			continue
+		}
+		for _, table := range tables {
+			month, ok := partitionMonth(table)
+			if !ok || month.AddDate(0, 1, 0).After(cutoff) {
+				continue
+			}
+			if err := db.WithContext(ctx).Exec(fmt.Sprintf("DROP TABLE IF EXISTS `%s`", table)).Error; err != nil {
+				zap.S().Warn("Drop cold partition failed", zap.String("table", table), zap.Error(err))
+				continue
+			}
+			forgetPartition(chatType, table)
+			zap.L().Info("Dropped expired cold partition", zap.String("table", table))
+		}
+
+		prefix := "user:%"
+		if chatType == "group" {
+			prefix = "group:%"
+		}
+		if err := db.WithContext(ctx).Table(legacyColdTable).
+			Where("conversation_id LIKE ? AND timestamp < ?", prefix, cutoff).
+			Delete(&Message{}).Error; err != nil {
+			zap.S().Warn("Purge legacy cold messages failed", zap.String("type", chatType), zap.Error(err))
+		}
+		if searchIndex != nil {
+			if err := searchIndex.RemoveBefore(ctx, chatType, cutoff); err != nil {
+				zap.S().Warn("Purge search index failed", zap.String("type", chatType), zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package messagesave
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestIsMissingTable(t *testing.T) {
+	missing := &mysql.MySQLError{Number: mysqlErrNoSuchTable, Message: "Table 'messages_cold_group_202401' doesn't exist"}
+	if !isMissingTable(missing) {
+		t.Fatal("ER_NO_SUCH_TABLE not detected")
+	}
+	if !isMissingTable(fmt.Errorf("list: %w", missing)) {
+		t.Fatal("wrapped ER_NO_SUCH_TABLE not detected")
+	}
+	if isMissingTable(&mysql.MySQLError{Number: 1062}) || isMissingTable(errors.New("boom")) {
+		t.Fatal("other errors treated as missing table")
+	}
+}
+
+func TestChatTypeOfTable(t *testing.T) {
+	month := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, chatType := range chatTypes {
+		if got := chatTypeOfTable(partitionName(chatType, month)); got != chatType {
+			t.Errorf("chatTypeOfTable(%s) = %q", partitionName(chatType, month), got)
+		}
+	}
+	if got := chatTypeOfTable(legacyColdTable); got != "" {
+		t.Errorf("legacy table chat type = %q, want empty", got)
+	}
+}
+
+func TestExpirePartitionsForcesRelist(t *testing.T) {
+	partitions.Lock()
+	partitions.tables["group"] = []string{"messages_cold_group_202401"}
+	partitions.listedAt["group"] = time.Now()
+	partitions.Unlock()
+	t.Cleanup(func() {
+		partitions.Lock()
+		delete(partitions.tables, "group")
+		delete(partitions.listedAt, "group")
+		partitions.Unlock()
+	})
+
+	expirePartitions("group")
+	partitions.Lock()
+	defer partitions.Unlock()
+	if time.Since(partitions.listedAt["group"]) < partitionListMaxAge {
+		t.Fatal("expired partition list still considered fresh")
+	}
+}
@@ -0,0 +1,278 @@
+package messagesave
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reactions 表情回应：emoji -> 回应过的用户 ID，归档时随消息写入 messages_cold.reactions
+type Reactions map[string][]string
+
+// Counts 汇总为 emoji -> 人数
+func (r Reactions) Counts() map[string]int {
+	if len(r) == 0 {
+		return nil
+	}
+	counts := make(map[string]int, len(r))
+	for emoji, users := range r {
+		counts[emoji] = len(users)
+	}
+	return counts
+}
+
+// reactionCountKey 热数据阶段的回应计数：hash，field 为 emoji，value 为人数
+func reactionCountKey(msgID string) string {
+	return fmt.Sprintf("msg:react:%s", msgID)
+}
+
+// reactionUsersKey 热数据阶段某个 emoji 的回应用户集合
+func reactionUsersKey(msgID, emoji string) string {
+	return fmt.Sprintf("msg:react:%s:%s", msgID, emoji)
+}
+
+// reactScript 原子地更新用户集合与计数，消息已归档（msg:<id> 不存在）时返回 -1
+// KEYS: msg:<id>, 计数 hash, 用户集合；ARGV: emoji, userID, 1 添加 / 0 取消, TTL 秒
+var reactScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return {-1, 0}
+end
+local changed
+if ARGV[3] == "1" then
+	changed = redis.call("SADD", KEYS[3], ARGV[2])
+else
+	changed = redis.call("SREM", KEYS[3], ARGV[2])
+end
+local count = redis.call("SCARD", KEYS[3])
+if count > 0 then
+	redis.call("HSET", KEYS[2], ARGV[1], count)
+	redis.call("EXPIRE", KEYS[2], ARGV[4])
+	redis.call("EXPIRE", KEYS[3], ARGV[4])
+else
+	redis.call("HDEL", KEYS[2], ARGV[1])
+end
+return {changed, count}
+`)
+
+// React 添加或取消用户对消息的回应，返回是否发生变化以及该 emoji 当前人数；
+// 先在热存储中修改，消息已归档时修改冷存储
+func React(ctx context.Context, msg *Message, userID, emoji string, add bool) (bool, int, error) {
+	changed, count, err := hotStorage.React(ctx, msg.ID, userID, emoji, add)
+	if errors.Is(err, ErrMessageNotFound) {
+		return coldStorage.React(ctx, msg.ID, userID, emoji, add)
+	}
+	return changed, count, err
+}
+
+// applyReaction 在 r 的副本上添加或取消回应，返回新的回应、是否发生变化以及该 emoji 当前人数
+func applyReaction(r Reactions, userID, emoji string, add bool) (Reactions, bool, int) {
+	users := r[emoji]
+	idx := -1
+	for i, u := range users {
+		if u == userID {
+			idx = i
+			break
+		}
+	}
+	if (add && idx >= 0) || (!add && idx < 0) {
+		return r, false, len(users)
+	}
+
+	next := make(Reactions, len(r)+1)
+	for e, u := range r {
+		next[e] = u
+	}
+	if add {
+		users = append(users[:len(users):len(users)], userID)
+	} else {
+		users = append(users[:idx:idx], users[idx+1:]...)
+	}
+	if len(users) > 0 {
+		next[emoji] = users
+	} else {
+		delete(next, emoji)
+	}
+	return next, true, len(users)
+}
+
+// React 热数据阶段的回应，消息已归档（msg:<id> 不存在）时返回 ErrMessageNotFound
+func (r *RedisMessageStorage) React(ctx context.Context, msgID, userID, emoji string, add bool) (bool, int, error) {
+	flag := "0"
+	if add {
+		flag = "1"
+	}
+	res, err := reactScript.Run(ctx, r.client,
+		[]string{fmt.Sprintf("msg:%s", msgID), reactionCountKey(msgID), reactionUsersKey(msgID, emoji)},
+		emoji, userID, flag, int(hotTTL.Seconds()),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if res[0] < 0 {
+		return false, 0, ErrMessageNotFound
+	}
+	return res[0] == 1, int(res[1]), nil
+}
+
+// React 已归档的消息直接在 MySQL 所在分表中加行锁修改
+func (m *MySQLMessageStorage) React(ctx context.Context, msgID, userID, emoji string, add bool) (bool, int, error) {
+	_, table, err := findCold(ctx, m.db, msgID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, 0, ErrMessageNotFound
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	var changed bool
+	var count int
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		stored := &Message{}
+		if err := tx.Table(table).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "reactions").Where("id = ?", msgID).First(stored).Error; err != nil {
+			return err
+		}
+
+		var next Reactions
+		next, changed, count = applyReaction(stored.Reactions, userID, emoji, add)
+		if !changed {
+			return nil
+		}
+		data, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		return tx.Table(table).Where("id = ?", msgID).Update("reactions", string(data)).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, 0, ErrMessageNotFound
+	}
+	return changed, count, err
+}
+
+// loadReactions 读取热数据阶段消息的完整回应（含用户）
+func loadReactions(ctx context.Context, client redis.Cmdable, msgIDs []string) (map[string]Reactions, error) {
+	pipe := client.Pipeline()
+	countCmds := make([]*redis.StringStringMapCmd, len(msgIDs))
+	for i, id := range msgIDs {
+		countCmds[i] = pipe.HGetAll(ctx, reactionCountKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	type usersCmd struct {
+		msgID, emoji string
+		cmd          *redis.StringSliceCmd
+	}
+	var cmds []usersCmd
+	pipe = client.Pipeline()
+	for i, id := range msgIDs {
+		for emoji := range countCmds[i].Val() {
+			cmds = append(cmds, usersCmd{id, emoji, pipe.SMembers(ctx, reactionUsersKey(id, emoji))})
+		}
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	result := make(map[string]Reactions)
+	for _, c := range cmds {
+		users := c.cmd.Val()
+		if len(users) == 0 {
+			continue
+		}
+		if result[c.msgID] == nil {
+			result[c.msgID] = Reactions{}
+		}
+		result[c.msgID][c.emoji] = users
+	}
+	return result, nil
+}
+
+// attachReactions 为尚在 Redis 中的消息补上回应；已归档的消息自带 reactions 列
+func attachReactions(ctx context.Context, client redis.Cmdable, msgs []*Message) error {
+	var hot []string
+	for _, m := range msgs {
+		if m.Reactions == nil {
+			hot = append(hot, m.ID)
+		}
+	}
+	if len(hot) == 0 {
+		return nil
+	}
+	reactions, err := loadReactions(ctx, client, hot)
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if r, ok := reactions[m.ID]; ok {
+			m.Reactions = r
+		}
+	}
+	return nil
+}
+
+// reactionChange 一次回应的添加或取消
+type reactionChange struct {
+	Emoji  string
+	UserID string
+	Add    bool
+}
+
+// reactionDiff 从 before 变为 after 需要的回应变更，按 emoji、用户排序
+func reactionDiff(before, after Reactions) []reactionChange {
+	var changes []reactionChange
+	emojis := make(map[string]bool)
+	for e := range before {
+		emojis[e] = true
+	}
+	for e := range after {
+		emojis[e] = true
+	}
+	sorted := make([]string, 0, len(emojis))
+	for e := range emojis {
+		sorted = append(sorted, e)
+	}
+	sort.Strings(sorted)
+
+	for _, emoji := range sorted {
+		had := make(map[string]bool, len(before[emoji]))
+		for _, u := range before[emoji] {
+			had[u] = true
+		}
+		has := make(map[string]bool, len(after[emoji]))
+		for _, u := range after[emoji] {
+			has[u] = true
+		}
+		var added, removed []string
+		for u := range has {
+			if !had[u] {
+				added = append(added, u)
+			}
+		}
+		for u := range had {
+			if !has[u] {
+				removed = append(removed, u)
+			}
+		}
+		sort.Strings(added)
+		sort.Strings(removed)
+		for _, u := range added {
+			changes = append(changes, reactionChange{emoji, u, true})
+		}
+		for _, u := range removed {
+			changes = append(changes, reactionChange{emoji, u, false})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,65 @@
+package messagesave
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyReactionCopiesOnWrite(t *testing.T) {
+	orig := Reactions{"👍": {"1"}}
+	next, changed, count := applyReaction(orig, "2", "👍", true)
+	if !changed || count != 2 {
+		t.Fatalf("add = (%v, %d), want (true, 2)", changed, count)
+	}
+	if len(orig["👍"]) != 1 {
+		t.Fatalf("original reactions modified: %v", orig)
+	}
+
+	if _, changed, count = applyReaction(next, "2", "👍", true); changed || count != 2 {
+		t.Fatalf("duplicate add = (%v, %d), want (false, 2)", changed, count)
+	}
+
+	next, _, _ = applyReaction(next, "1", "👍", false)
+	next, changed, count = applyReaction(next, "2", "👍", false)
+	if !changed || count != 0 {
+		t.Fatalf("remove = (%v, %d), want (true, 0)", changed, count)
+	}
+	if _, ok := next["👍"]; ok {
+		t.Fatalf("empty emoji kept: %v", next)
+	}
+}
+
+func TestReactUsesInjectedStorage(t *testing.T) {
+	hot, cold := useMemoryStorage(t)
+	ctx := context.Background()
+
+	live := newTestMessage("live", "group:1", time.Now())
+	hot.Save(ctx, live)
+	archived := newTestMessage("archived", "group:1", time.Now().Add(-30*24*time.Hour))
+	archived.Seq = 1
+	cold.Save(ctx, archived)
+
+	if changed, count, err := React(ctx, live, "7", "👍", true); err != nil || !changed || count != 1 {
+		t.Fatalf("hot react = (%v, %d, %v)", changed, count, err)
+	}
+	if changed, count, err := React(ctx, archived, "7", "🎉", true); err != nil || !changed || count != 1 {
+		t.Fatalf("cold react = (%v, %d, %v)", changed, count, err)
+	}
+
+	got, _ := hot.Get(ctx, "live")
+	if !reflect.DeepEqual(got.Reactions, Reactions{"👍": {"7"}}) {
+		t.Fatalf("hot reactions = %v", got.Reactions)
+	}
+	got, _ = cold.Get(ctx, "archived")
+	if !reflect.DeepEqual(got.Reactions, Reactions{"🎉": {"7"}}) {
+		t.Fatalf("cold reactions = %v", got.Reactions)
+	}
+
+	missing := newTestMessage("missing", "group:1", time.Now())
+	if _, _, err := React(ctx, missing, "7", "👍", true); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("err = %v, want ErrMessageNotFound", err)
+	}
+}
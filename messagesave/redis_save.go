@@ -3,13 +3,20 @@ package messagesave
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
 )
 
-var ctx = context.Background()
+const (
+	// hotRetention 热数据的保留期，超出后由归档任务写入冷存储
+	hotRetention = 7 * 24 * time.Hour
+	// hotTTL 热数据 key 的过期时间。比保留期多留余量，归档任务停摆不超过余量时消息不会在归档前过期
+	hotTTL = hotRetention + 3*24*time.Hour
+)
 
 type RedisMessageStorage struct {
 	client *redis.Client // 复用外部传入的 client
@@ -20,65 +27,122 @@ func NewRedisMessageStorage(client *redis.Client) *RedisMessageStorage {
 	return &RedisMessageStorage{client: client}
 }
 
-// 后续 Save 和 List 方法保持不变（只使用 r.client）
+// seqPlaceholder 序列化时 seq 的占位值，由 saveScript 替换为分配到的 seq
+const seqPlaceholder = `"seq":-1`
+
+// saveScript 原子地完成幂等检查、分配 seq 与写入，任何一步失败都不会留下幂等记录或空洞索引
+// KEYS: conv:seq, conv:msg, conv:seqidx, msg:<id>, 幂等 key（为空表示不检查）, 话题索引（可选）
+// ARGV: 消息 ID, 序列化后的消息（seq 为占位值）, 时间分值, TTL 秒, 幂等 key TTL 秒, 占位值
+// 返回 {1, seq}；重复消息返回 {0, 首次保存的消息 ID}
+var saveScript = redis.NewScript(`
+if KEYS[5] ~= "" then
+	if not redis.call("SET", KEYS[5], ARGV[1], "NX", "EX", ARGV[5]) then
+		return {0, redis.call("GET", KEYS[5])}
+	end
+end
+local seq = redis.call("INCR", KEYS[1])
+local data = ARGV[2]
+local i, j = string.find(data, ARGV[6], 1, true)
+data = string.sub(data, 1, i - 1) .. '"seq":' .. seq .. string.sub(data, j + 1)
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[1])
+redis.call("ZADD", KEYS[3], seq, ARGV[1])
+redis.call("HSET", KEYS[4], "data", data)
+redis.call("EXPIRE", KEYS[2], ARGV[4])
+redis.call("EXPIRE", KEYS[3], ARGV[4])
+redis.call("EXPIRE", KEYS[4], ARGV[4])
+if KEYS[6] then
+	redis.call("ZADD", KEYS[6], seq, ARGV[1])
+	redis.call("EXPIRE", KEYS[6], ARGV[4])
+end
+return {1, seq}
+`)
+
+// Save 写入热存储并分配会话内 seq；ClientMsgID 重复时回填首次保存的消息并返回 ErrDuplicateMessage
 func (r *RedisMessageStorage) Save(ctx context.Context, msg *Message) error {
 	if msg.ID == "" || msg.ConversationID == "" || msg.Timestamp.IsZero() {
 		return fmt.Errorf("消息缺少必要字段")
 	}
 
-	// 幂等控制
-	if msg.ClientMsgID != "" {
-		key := fmt.Sprintf("msgid:%s:%s", msg.ConversationID, msg.ClientMsgID)
-		exists, err := r.client.Exists(ctx, key).Result()
-		if err != nil {
-			return err
-		}
-		if exists > 0 {
-			return nil
-		}
-		r.client.SetEX(ctx, key, "1", 24*time.Hour)
-	}
-
-	// JSON 序列化
+	// seq 由脚本分配，序列化时先写入占位值
+	msg.Seq = -1
 	data, err := json.Marshal(msg)
+	msg.Seq = 0
 	if err != nil {
 		return fmt.Errorf("JSON 序列化失败: %v", err)
 	}
 
-	zsetKey := fmt.Sprintf("conv:msg:%s", msg.ConversationID)
-	hashKey := fmt.Sprintf("msg:%s", msg.ID)
-	score := float64(msg.Timestamp.UnixNano())
+	// 幂等控制：记录 ClientMsgID 对应的消息 ID，重发时回填首次保存的消息
+	var dedupKey string
+	if msg.ClientMsgID != "" {
+		dedupKey = fmt.Sprintf("msgid:%s:%s", msg.ConversationID, msg.ClientMsgID)
+	}
+	keys := []string{
+		convSeqKey(msg.ConversationID), // 会话内严格递增序号，该 key 不设置过期
+		fmt.Sprintf("conv:msg:%s", msg.ConversationID),
+		convSeqIndexKey(msg.ConversationID),
+		fmt.Sprintf("msg:%s", msg.ID),
+		dedupKey,
+	}
+	if msg.ThreadRoot != "" {
+		keys = append(keys, threadIndexKey(msg.ThreadRoot))
+	}
 
-	pipe := r.client.TxPipeline()
-	pipe.ZAdd(ctx, zsetKey, &redis.Z{Score: score, Member: msg.ID})
-	pipe.HSet(ctx, hashKey, "data", string(data)) // 注意：HSet 第三参数是 interface{}，string 可以
-	pipe.Expire(ctx, zsetKey, 7*24*time.Hour)
-	pipe.Expire(ctx, hashKey, 7*24*time.Hour)
+	res, err := saveScript.Run(ctx, r.client, keys,
+		msg.ID, data, float64(msg.Timestamp.UnixNano()),
+		int(hotTTL.Seconds()), int((24 * time.Hour).Seconds()), seqPlaceholder,
+	).Slice()
+	if err != nil {
+		return err
+	}
+	if len(res) != 2 {
+		return fmt.Errorf("unexpected save script result: %v", res)
+	}
 
-	_, err = pipe.Exec(ctx)
-	return err
+	if saved, _ := res[0].(int64); saved == 0 {
+		if origID, ok := res[1].(string); ok {
+			if data, err := r.client.HGet(ctx, fmt.Sprintf("msg:%s", origID), "data").Result(); err == nil {
+				json.Unmarshal([]byte(data), msg)
+			}
+		}
+		return ErrDuplicateMessage
+	}
+	msg.Seq, _ = res[1].(int64)
+	return nil
 }
 
-func (r *RedisMessageStorage) List(ctx context.Context, convID string, start, end time.Time, limit int, reverse bool) ([]*Message, error) {
-	zsetKey := fmt.Sprintf("conv:msg:%s", convID)
-	minScore := float64(start.UnixNano())
-	maxScore := float64(end.UnixNano())
-
-	zRange := &redis.ZRangeBy{
-		Min:    fmt.Sprintf("%.0f", minScore),
-		Max:    fmt.Sprintf("%.0f", maxScore),
-		Offset: 0,
-		Count:  int64(limit),
+// BatchSave 逐条保存，重复消息跳过
+func (r *RedisMessageStorage) BatchSave(ctx context.Context, messages []*Message) error {
+	for _, msg := range messages {
+		if err := r.Save(ctx, msg); err != nil && err != ErrDuplicateMessage {
+			return err
+		}
 	}
+	return nil
+}
+
+// List 按 conv:seqidx 索引翻页，结果附带表情回应
+func (r *RedisMessageStorage) List(ctx context.Context, convID string, cursor int64, dir Direction, limit int) ([]*Message, error) {
+	zsetKey := convSeqIndexKey(convID)
 
 	var ids []string
 	var err error
-	if reverse {
-		ids, err = r.client.ZRevRangeByScore(ctx, zsetKey, zRange).Result()
+	if dir == Backward {
+		max := "+inf"
+		if cursor > 0 {
+			max = fmt.Sprintf("(%d", cursor)
+		}
+		ids, err = r.client.ZRevRangeByScore(ctx, zsetKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   max,
+			Count: int64(limit),
+		}).Result()
 	} else {
-		ids, err = r.client.ZRangeByScore(ctx, zsetKey, zRange).Result()
+		ids, err = r.client.ZRangeByScore(ctx, zsetKey, &redis.ZRangeBy{
+			Min:   fmt.Sprintf("(%d", cursor),
+			Max:   "+inf",
+			Count: int64(limit),
+		}).Result()
 	}
-
 	if err != nil {
 		return nil, err
 	}
@@ -86,17 +150,136 @@ func (r *RedisMessageStorage) List(ctx context.Context, convID string, start, en
 	if len(ids) == 0 {
 		return []*Message{}, nil
 	}
+	messages, err := loadRedisMessages(ctx, r.client, ids)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachReactions(ctx, r.client, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Get 读取 msg:<id>，hash 不存在（已归档或从未写入）时返回 ErrMessageNotFound
+func (r *RedisMessageStorage) Get(ctx context.Context, msgID string) (*Message, error) {
+	data, err := r.client.HGet(ctx, fmt.Sprintf("msg:%s", msgID), "data").Result()
+	if err == redis.Nil {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{}
+	if err := json.Unmarshal([]byte(data), msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// updateMaxRetries 乐观锁冲突时的最大重试次数
+const updateMaxRetries = 5
+
+// Update 以 WATCH msg:<id> 实现乐观锁，期间有其他修改时重新读取后重试；写回不改变 key 的过期时间
+func (r *RedisMessageStorage) Update(ctx context.Context, msgID string, fn UpdateFunc) (*Message, error) {
+	hashKey := fmt.Sprintf("msg:%s", msgID)
+
+	var updated *Message
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.HGet(ctx, hashKey, "data").Result()
+		if err == redis.Nil {
+			return ErrMessageNotFound
+		}
+		if err != nil {
+			return err
+		}
+		msg := &Message{}
+		if err := json.Unmarshal([]byte(data), msg); err != nil {
+			return err
+		}
+		if err := fn(ctx, msg); err != nil {
+			return err
+		}
+		value, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("JSON 序列化失败: %v", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, hashKey, "data", value)
+			return nil
+		})
+		if err == nil {
+			updated = msg
+		}
+		return err
+	}
+
+	for i := 0; i < updateMaxRetries; i++ {
+		err := r.client.Watch(ctx, txf, hashKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return updated, nil
+	}
+	return nil, fmt.Errorf("update message %s: too many concurrent modifications", msgID)
+}
+
+// ListThread 按话题索引 thread:msg:<root> 翻页，结果附带表情回应
+func (r *RedisMessageStorage) ListThread(ctx context.Context, root *Message, afterSeq int64, limit int) ([]*Message, error) {
+	ids, err := r.client.ZRangeByScore(ctx, threadIndexKey(root.ID), &redis.ZRangeBy{
+		Min:   fmt.Sprintf("(%d", afterSeq),
+		Max:   "+inf",
+		Count: int64(limit),
+	}).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	msgs, err := loadRedisMessages(ctx, r.client, ids)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachReactions(ctx, r.client, msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// LatestSeqs 批量读取 conv:seq:<会话 ID>
+func (r *RedisMessageStorage) LatestSeqs(ctx context.Context, convIDs []string) (map[string]int64, error) {
+	seqs := make(map[string]int64, len(convIDs))
+	if len(convIDs) == 0 {
+		return seqs, nil
+	}
 
 	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(convIDs))
+	for i, convID := range convIDs {
+		cmds[i] = pipe.Get(ctx, convSeqKey(convID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		seq, _ := cmd.Int64()
+		seqs[convIDs[i]] = seq
+	}
+	return seqs, nil
+}
+
+// loadRedisMessages 按 ID 批量读取 msg:<id>，已过期或无法解析的跳过
+func loadRedisMessages(ctx context.Context, client redis.Cmdable, ids []string) ([]*Message, error) {
+	pipe := client.Pipeline()
 	cmds := make([]*redis.StringCmd, len(ids))
 	for i, id := range ids {
 		cmds[i] = pipe.HGet(ctx, fmt.Sprintf("msg:%s", id), "data")
 	}
-	if _, err = pipe.Exec(ctx); err != nil && err != redis.Nil {
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
 		return nil, err
 	}
 
-	var messages []*Message
+	msgs := make([]*Message, 0, len(ids))
 	for _, cmd := range cmds {
 		data, err := cmd.Result()
 		if err != nil {
@@ -106,8 +289,162 @@ func (r *RedisMessageStorage) List(ctx context.Context, convID string, start, en
 		if err := json.Unmarshal([]byte(data), msg); err != nil {
 			continue
 		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// Conversations 使用 SCAN 枚举有热数据的会话，避免阻塞
+func (r *RedisMessageStorage) Conversations(ctx context.Context) ([]string, error) {
+	var convIDs []string
+	var cursor uint64
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, "conv:msg:*", 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			convIDs = append(convIDs, key[len("conv:msg:"):])
+		}
+		if next == 0 {
+			return convIDs, nil
+		}
+		cursor = next
+	}
+}
+
+// Expired 按 conv:seqidx 从最早一条开始取连续的过期消息；只剩索引（hash 已过期）和无法解析的消息转入死信后移出索引
+func (r *RedisMessageStorage) Expired(ctx context.Context, convID string, cutoff time.Time, limit int) ([]*Message, error) {
+	ids, err := r.client.ZRange(ctx, convSeqIndexKey(convID), 0, int64(limit)-1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGet(ctx, fmt.Sprintf("msg:%s", id), "data")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	var messages []*Message
+	var broken []interface{}
+	deadLetters := make(map[string]interface{})
+	for i, cmd := range cmds {
+		data, err := cmd.Result()
+		if err == redis.Nil {
+			// 消息 hash 已过期，只剩索引：内容已丢失，记入死信以便排查
+			deadLetters[ids[i]] = fmt.Sprintf("missing: %s", convID)
+			broken = append(broken, ids[i])
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		msg := &Message{}
+		if err := json.Unmarshal([]byte(data), msg); err != nil {
+			deadLetters[ids[i]] = data
+			broken = append(broken, ids[i])
+			continue
+		}
+		if msg.Timestamp.After(cutoff) {
+			break
+		}
 		messages = append(messages, msg)
 	}
 
+	if len(broken) > 0 {
+		pipe := r.client.TxPipeline()
+		pipe.HSet(ctx, archiveDeadLetterKey, deadLetters)
+		pipe.ZRem(ctx, fmt.Sprintf("conv:msg:%s", convID), broken...)
+		pipe.ZRem(ctx, convSeqIndexKey(convID), broken...)
+		for id := range deadLetters {
+			pipe.Del(ctx, fmt.Sprintf("msg:%s", id))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+		archiveMetrics.DeadLetters.Add(int64(len(deadLetters)))
+		zap.S().Warn("Missing or undecodable messages moved to dead letter",
+			zap.String("conv", convID),
+			zap.Int("count", len(deadLetters)))
+	}
+
+	if err := attachReactions(ctx, r.client, messages); err != nil {
+		return nil, err
+	}
 	return messages, nil
 }
+
+// removeScript 删除消息及其索引、回应，并返回删除时的回应。读取与删除在同一脚本中，
+// 归档读出批次之后新增的回应不会丢失：要么在这里被带出，要么在 msg:<id> 删除后写入冷存储
+// KEYS: conv:msg:<会话>, conv:seqidx:<会话>；ARGV: 依次为消息 ID、话题根 ID（无则为空串）
+// 返回 {{消息 ID, emoji, 用户...}, ...}
+var removeScript = redis.NewScript(`
+local result = {}
+for i = 1, #ARGV, 2 do
+	local id = ARGV[i]
+	local root = ARGV[i + 1]
+	local countKey = "msg:react:" .. id
+	local emojis = redis.call("HKEYS", countKey)
+	for _, emoji in ipairs(emojis) do
+		local usersKey = countKey .. ":" .. emoji
+		local users = redis.call("SMEMBERS", usersKey)
+		if #users > 0 then
+			local entry = {id, emoji}
+			for _, u in ipairs(users) do
+				table.insert(entry, u)
+			end
+			table.insert(result, entry)
+		end
+		redis.call("DEL", usersKey)
+	end
+	redis.call("DEL", countKey, "msg:" .. id)
+	redis.call("ZREM", KEYS[1], id)
+	redis.call("ZREM", KEYS[2], id)
+	if root ~= "" then
+		redis.call("ZREM", "thread:msg:" .. root, id)
+	end
+end
+return result
+`)
+
+// Remove 归档完成后删除消息及其索引、回应，返回删除时各消息的回应
+func (r *RedisMessageStorage) Remove(ctx context.Context, convID string, messages []*Message) (map[string]Reactions, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	args := make([]interface{}, 0, 2*len(messages))
+	for _, m := range messages {
+		args = append(args, m.ID, m.ThreadRoot)
+	}
+	res, err := removeScript.Run(ctx, r.client,
+		[]string{fmt.Sprintf("conv:msg:%s", convID), convSeqIndexKey(convID)}, args...).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	reactions := make(map[string]Reactions)
+	for _, e := range res {
+		entry, ok := e.([]interface{})
+		if !ok || len(entry) < 3 {
+			continue
+		}
+		id, _ := entry[0].(string)
+		emoji, _ := entry[1].(string)
+		users := make([]string, 0, len(entry)-2)
+		for _, u := range entry[2:] {
+			if s, ok := u.(string); ok {
+				users = append(users, s)
+			}
+		}
+		if reactions[id] == nil {
+			reactions[id] = Reactions{}
+		}
+		reactions[id][emoji] = users
+	}
+	return reactions, nil
+}
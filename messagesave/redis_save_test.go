@@ -0,0 +1,33 @@
+package messagesave
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// saveScript 依赖占位值在序列化结果中恰好出现一次，且第一次出现就是 seq 字段
+func TestSeqPlaceholder(t *testing.T) {
+	msg := newTestMessage("a", "user:1:2", time.Now())
+	msg.ClientMsgID = `"seq":-1`
+	msg.Seq = -1
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), seqPlaceholder); n != 1 {
+		t.Fatalf("placeholder occurs %d times in %s", n, data)
+	}
+
+	// 与脚本相同的替换方式
+	i := strings.Index(string(data), seqPlaceholder)
+	replaced := string(data[:i]) + `"seq":42` + string(data[i+len(seqPlaceholder):])
+	decoded := &Message{}
+	if err := json.Unmarshal([]byte(replaced), decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Seq != 42 || decoded.ClientMsgID != `"seq":-1` {
+		t.Fatalf("decoded seq=%d client id=%q", decoded.Seq, decoded.ClientMsgID)
+	}
+}
@@ -0,0 +1,109 @@
+package messagesave
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// RevisionStorage 消息历史版本的存储
+type RevisionStorage interface {
+	// AddRevision 写入一个历史版本，同一消息的同一版本号只能写入一次；ctx 中带有冷存储事务时在事务内写入
+	AddRevision(ctx context.Context, rev *Revision) error
+	// RemoveRevision 按 ID 删除未能随消息修改一起提交的历史版本
+	RemoveRevision(ctx context.Context, rev *Revision) error
+	// ListRevisions 按版本号升序返回消息的历史版本
+	ListRevisions(ctx context.Context, msgID string) ([]*Revision, error)
+}
+
+// revisionStorage 由 InitRevisionStorage 注入
+var revisionStorage RevisionStorage
+
+// InitRevisionStorage 注入历史版本存储（默认 MySQL）
+func InitRevisionStorage(r RevisionStorage) {
+	revisionStorage = r
+}
+
+var (
+	_ RevisionStorage = (*MySQLRevisionStorage)(nil)
+	_ RevisionStorage = (*MemoryRevisionStorage)(nil)
+)
+
+// MySQLRevisionStorage messages_revision 表，(msg_id, version) 唯一
+type MySQLRevisionStorage struct {
+	db *gorm.DB
+}
+
+func NewMySQLRevisionStorage(db *gorm.DB) *MySQLRevisionStorage {
+	return &MySQLRevisionStorage{db: db}
+}
+
+func (s *MySQLRevisionStorage) AddRevision(ctx context.Context, rev *Revision) error {
+	return dbFrom(ctx, s.db).Create(rev).Error
+}
+
+func (s *MySQLRevisionStorage) RemoveRevision(ctx context.Context, rev *Revision) error {
+	return dbFrom(ctx, s.db).Delete(&Revision{}, rev.ID).Error
+}
+
+func (s *MySQLRevisionStorage) ListRevisions(ctx context.Context, msgID string) ([]*Revision, error) {
+	var revs []*Revision
+	if err := s.db.WithContext(ctx).Where("msg_id = ?", msgID).Order("version ASC").Find(&revs).Error; err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+// MemoryRevisionStorage 纯内存实现，用于单元测试和本地调试
+type MemoryRevisionStorage struct {
+	mu     sync.Mutex
+	nextID uint
+	revs   map[string][]*Revision // 消息 ID -> 历史版本
+}
+
+func NewMemoryRevisionStorage() *MemoryRevisionStorage {
+	return &MemoryRevisionStorage{revs: make(map[string][]*Revision)}
+}
+
+func (s *MemoryRevisionStorage) AddRevision(ctx context.Context, rev *Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.revs[rev.MsgID] {
+		if r.Version == rev.Version {
+			return fmt.Errorf("revision %d of message %s already exists", rev.Version, rev.MsgID)
+		}
+	}
+	s.nextID++
+	rev.ID = s.nextID
+	cp := *rev
+	s.revs[rev.MsgID] = append(s.revs[rev.MsgID], &cp)
+	return nil
+}
+
+func (s *MemoryRevisionStorage) RemoveRevision(ctx context.Context, rev *Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.revs[rev.MsgID]
+	for i, r := range list {
+		if r.ID == rev.ID {
+			s.revs[rev.MsgID] = append(list[:i:i], list[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *MemoryRevisionStorage) ListRevisions(ctx context.Context, msgID string) ([]*Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revs := make([]*Revision, 0, len(s.revs[msgID]))
+	for _, r := range s.revs[msgID] {
+		cp := *r
+		revs = append(revs, &cp)
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Version < revs[j].Version })
+	return revs, nil
+}
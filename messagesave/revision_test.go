@@ -0,0 +1,145 @@
+package messagesave
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// useMemoryRevisions 以内存实现替换注入的历史版本存储
+func useMemoryRevisions(t *testing.T) *MemoryRevisionStorage {
+	t.Helper()
+	revs := NewMemoryRevisionStorage()
+	prev := revisionStorage
+	InitRevisionStorage(revs)
+	t.Cleanup(func() { InitRevisionStorage(prev) })
+	return revs
+}
+
+// retryingStorage 模拟热存储的乐观锁冲突：每次 Update 先在副本上调用一次 fn 再丢弃，随后正常提交
+type retryingStorage struct {
+	*MemoryMessageStorage
+}
+
+func (s retryingStorage) Update(ctx context.Context, msgID string, fn UpdateFunc) (*Message, error) {
+	msg, err := s.Get(ctx, msgID)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(ctx, msg); err != nil {
+		return nil, err
+	}
+	return s.MemoryMessageStorage.Update(ctx, msgID, fn)
+}
+
+// failingStorage fn 执行后提交失败（如事务回滚）
+type failingStorage struct {
+	*MemoryMessageStorage
+}
+
+func (s failingStorage) Update(ctx context.Context, msgID string, fn UpdateFunc) (*Message, error) {
+	msg, err := s.Get(ctx, msgID)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(ctx, msg); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("commit failed")
+}
+
+func TestEditRecordsRevisions(t *testing.T) {
+	hot, _ := useMemoryStorage(t)
+	revs := useMemoryRevisions(t)
+	ctx := context.Background()
+
+	msg := newTestMessage("a", "user:1:2", time.Now())
+	hot.Save(ctx, msg)
+
+	// 两次编辑都基于调用方手里的旧副本，版本号仍以存储中的为准
+	stale := *msg
+	if err := Edit(ctx, msg, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Edit(ctx, &stale, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if stale.RevisionCount != 2 || string(stale.Content) != "v2" || stale.EditedAt == nil {
+		t.Fatalf("msg not backfilled: %+v", stale)
+	}
+
+	list, _ := revs.ListRevisions(ctx, "a")
+	if len(list) != 2 || list[0].Version != 0 || string(list[0].Content) != "hello a" ||
+		list[1].Version != 1 || string(list[1].Content) != "v1" {
+		t.Fatalf("revisions = %+v", list)
+	}
+}
+
+func TestEditRecalledMessage(t *testing.T) {
+	hot, _ := useMemoryStorage(t)
+	revs := useMemoryRevisions(t)
+	ctx := context.Background()
+
+	msg := newTestMessage("a", "user:1:2", time.Now())
+	msg.Recalled = true
+	hot.Save(ctx, msg)
+
+	if err := Edit(ctx, msg, []byte("v1")); err == nil {
+		t.Fatal("edited a recalled message")
+	}
+	if list, _ := revs.ListRevisions(ctx, "a"); len(list) != 0 {
+		t.Fatalf("revisions = %+v", list)
+	}
+}
+
+func TestEditDiscardsRevisionOfRetriedAttempt(t *testing.T) {
+	mem := NewMemoryMessageStorage()
+	prevHot, prevCold := hotStorage, coldStorage
+	InitStorage(retryingStorage{mem}, NewMemoryMessageStorage())
+	t.Cleanup(func() { InitStorage(prevHot, prevCold) })
+	revs := useMemoryRevisions(t)
+	ctx := context.Background()
+
+	msg := newTestMessage("a", "user:1:2", time.Now())
+	mem.Save(ctx, msg)
+	if err := Edit(ctx, msg, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := revs.ListRevisions(ctx, "a"); len(list) != 1 || list[0].Version != 0 {
+		t.Fatalf("revisions = %+v", list)
+	}
+}
+
+func TestEditDiscardsRevisionWhenCommitFails(t *testing.T) {
+	prevHot, prevCold := hotStorage, coldStorage
+	cold := NewMemoryMessageStorage()
+	InitStorage(NewMemoryMessageStorage(), failingStorage{cold})
+	t.Cleanup(func() { InitStorage(prevHot, prevCold) })
+	revs := useMemoryRevisions(t)
+	ctx := context.Background()
+
+	msg := newTestMessage("a", "user:1:2", time.Now())
+	msg.Seq = 1
+	cold.Save(ctx, msg)
+	if err := Edit(ctx, msg, []byte("v1")); err == nil {
+		t.Fatal("edit succeeded although commit failed")
+	}
+	if list, _ := revs.ListRevisions(ctx, "a"); len(list) != 0 {
+		t.Fatalf("revisions = %+v", list)
+	}
+	if got, _ := cold.Get(ctx, "a"); string(got.Content) != "hello a" || got.RevisionCount != 0 {
+		t.Fatalf("message changed: %+v", got)
+	}
+}
+
+func TestMemoryRevisionVersionUnique(t *testing.T) {
+	revs := NewMemoryRevisionStorage()
+	ctx := context.Background()
+	if err := revs.AddRevision(ctx, &Revision{MsgID: "a", Version: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := revs.AddRevision(ctx, &Revision{MsgID: "a", Version: 0}); err == nil {
+		t.Fatal("duplicate version accepted")
+	}
+}
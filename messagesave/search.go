@@ -0,0 +1,168 @@
+package messagesave
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SearchQuery 检索条件，ConversationIDs 为空时不返回任何结果（调用方负责限定可见会话）
+type SearchQuery struct {
+	Keyword         string
+	ConversationIDs []string
+	SenderID        string
+	Start, End      time.Time // 零值表示不限
+	Limit, Offset   int
+}
+
+// SearchHit 检索结果
+type SearchHit struct {
+	MsgID          string    `json:"msg_id"`
+	ConversationID string    `json:"conversation_id"`
+	SenderID       string    `json:"sender_id"`
+	Content        string    `json:"content"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// SearchIndex 消息全文检索，默认由 MySQL FULLTEXT（ngram 分词）实现，可替换为其他引擎
+type SearchIndex interface {
+	// Index 写入或覆盖消息的索引，非文本、已撤回的消息会被移除
+	Index(ctx context.Context, messages []*Message) error
+	Remove(ctx context.Context, msgIDs ...string) error
+	// RemoveBefore 删除某类会话（private / group）早于 before 的索引，与冷数据保留策略一致
+	RemoveBefore(ctx context.Context, chatType string, before time.Time) error
+	Search(ctx context.Context, q SearchQuery) ([]*SearchHit, error)
+}
+
+// searchIndex 由 InitSearchIndex 注入，未注入时不建立索引
+var searchIndex SearchIndex
+
+// InitSearchIndex 注入全文检索实现
+func InitSearchIndex(idx SearchIndex) {
+	searchIndex = idx
+}
+
+// Search 使用注入的检索实现查询
+func Search(ctx context.Context, q SearchQuery) ([]*SearchHit, error) {
+	if searchIndex == nil || len(q.ConversationIDs) == 0 || strings.TrimSpace(q.Keyword) == "" {
+		return []*SearchHit{}, nil
+	}
+	return searchIndex.Search(ctx, q)
+}
+
+// indexMessages 更新索引，失败只记录日志：归档时会再索引一次
+func indexMessages(ctx context.Context, messages ...*Message) {
+	if searchIndex == nil {
+		return
+	}
+	if err := searchIndex.Index(ctx, messages); err != nil {
+		zap.S().Warn("Index messages failed", zap.Int("count", len(messages)), zap.Error(err))
+	}
+}
+
+// SearchDocument messages_search 表，独立于分表的冷数据，热数据写入时即可检索
+type SearchDocument struct {
+	MsgID          string    `gorm:"column:msg_id;primaryKey;size:32"`
+	ConversationID string    `gorm:"column:conversation_id;size:64;index:idx_search_conv_time,priority:1"`
+	SenderID       string    `gorm:"column:sender_id;size:32"`
+	Content        string    `gorm:"column:content;type:text;index:idx_search_content,class:FULLTEXT,option:WITH PARSER ngram"`
+	Timestamp      time.Time `gorm:"column:timestamp;index:idx_search_conv_time,priority:2"`
+}
+
+// TableName GORM 表名
+func (SearchDocument) TableName() string {
+	return "messages_search"
+}
+
+// MySQLSearchIndex 基于 MySQL FULLTEXT + ngram 分词，中文无需额外分词
+type MySQLSearchIndex struct {
+	db *gorm.DB
+}
+
+func NewMySQLSearchIndex(db *gorm.DB) *MySQLSearchIndex {
+	return &MySQLSearchIndex{db: db}
+}
+
+func (s *MySQLSearchIndex) Index(ctx context.Context, messages []*Message) error {
+	var docs []*SearchDocument
+	var drop []string
+	for _, m := range messages {
+		if m.MsgType != "text" || m.Recalled || len(m.Content) == 0 {
+			drop = append(drop, m.ID)
+			continue
+		}
+		docs = append(docs, &SearchDocument{
+			MsgID:          m.ID,
+			ConversationID: m.ConversationID,
+			SenderID:       m.SenderID,
+			Content:        string(m.Content),
+			Timestamp:      m.Timestamp,
+		})
+	}
+
+	if len(docs) > 0 {
+		if err := s.db.WithContext(ctx).
+			Clauses(clause.OnConflict{UpdateAll: true}).
+			CreateInBatches(docs, 100).Error; err != nil {
+			return err
+		}
+	}
+	return s.Remove(ctx, drop...)
+}
+
+func (s *MySQLSearchIndex) Remove(ctx context.Context, msgIDs ...string) error {
+	if len(msgIDs) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Where("msg_id IN ?", msgIDs).Delete(&SearchDocument{}).Error
+}
+
+func (s *MySQLSearchIndex) RemoveBefore(ctx context.Context, chatType string, before time.Time) error {
+	prefix := "user:%"
+	if chatType == "group" {
+		prefix = "group:%"
+	}
+	return s.db.WithContext(ctx).
+		Where("conversation_id LIKE ? AND timestamp < ?", prefix, before).
+		Delete(&SearchDocument{}).Error
+}
+
+// Search 以短语方式匹配关键词，按时间倒序返回
+func (s *MySQLSearchIndex) Search(ctx context.Context, q SearchQuery) ([]*SearchHit, error) {
+	// BOOLEAN MODE 下用双引号包裹作为短语，去掉关键词里的双引号避免破坏语法
+	phrase := `"` + strings.ReplaceAll(strings.TrimSpace(q.Keyword), `"`, " ") + `"`
+
+	db := s.db.WithContext(ctx).Model(&SearchDocument{}).
+		Where("MATCH(content) AGAINST(? IN BOOLEAN MODE)", phrase).
+		Where("conversation_id IN ?", q.ConversationIDs)
+	if q.SenderID != "" {
+		db = db.Where("sender_id = ?", q.SenderID)
+	}
+	if !q.Start.IsZero() {
+		db = db.Where("timestamp >= ?", q.Start)
+	}
+	if !q.End.IsZero() {
+		db = db.Where("timestamp <= ?", q.End)
+	}
+
+	var docs []*SearchDocument
+	if err := db.Order("timestamp DESC").Limit(q.Limit).Offset(q.Offset).Find(&docs).Error; err != nil {
+		return nil, err
+	}
+
+	hits := make([]*SearchHit, 0, len(docs))
+	for _, d := range docs {
+		hits = append(hits, &SearchHit{
+			MsgID:          d.MsgID,
+			ConversationID: d.ConversationID,
+			SenderID:       d.SenderID,
+			Content:        d.Content,
+			Timestamp:      d.Timestamp,
+		})
+	}
+	return hits, nil
+}
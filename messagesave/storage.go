@@ -6,13 +6,68 @@ import (
 )
 
 type Message struct {
-	ID             string    `json:"id" gorm:"column:id;primaryKey"`
-	ConversationID string    `json:"conversation_id" gorm:"column:conversation_id;index:idx_conv_time"`
-	SenderID       string    `json:"sender_id" gorm:"column:sender_id"`
-	Content        []byte    `json:"content" gorm:"column:content"`
-	MsgType        string    `json:"msg_type" gorm:"column:msg_type"`
-	Timestamp      time.Time `json:"timestamp" gorm:"column:timestamp"`
-	ClientMsgID    string    `json:"client_msg_id,omitempty" gorm:"-"` // 不存入数据库
+	ID             string     `json:"id" gorm:"column:id;primaryKey"`
+	ConversationID string     `json:"conversation_id" gorm:"column:conversation_id;index:idx_conv_time;index:idx_conv_seq,priority:1"`
+	Seq            int64      `json:"seq" gorm:"column:seq;index:idx_conv_seq,priority:2"` // 会话内严格递增序号
+	SenderID       string     `json:"sender_id" gorm:"column:sender_id"`
+	Content        []byte     `json:"content" gorm:"column:content"`
+	MsgType        string     `json:"msg_type" gorm:"column:msg_type"`
+	Timestamp      time.Time  `json:"timestamp" gorm:"column:timestamp"`
+	ClientMsgID    string     `json:"client_msg_id,omitempty" gorm:"-"` // 不存入数据库
+	Recalled       bool       `json:"recalled,omitempty" gorm:"column:recalled"`
+	RevisionCount  int        `json:"revision_count,omitempty" gorm:"column:revision_count"` // 被编辑的次数
+	EditedAt       *time.Time `json:"edited_at,omitempty" gorm:"column:edited_at"`
+	ReplyTo        string     `json:"reply_to,omitempty" gorm:"column:reply_to;size:32"`                             // 引用/回复的消息 ID
+	ThreadRoot     string     `json:"thread_root,omitempty" gorm:"column:thread_root;size:32;index:idx_thread_root"` // 所在话题的根消息 ID
+	Reactions      Reactions  `json:"reactions,omitempty" gorm:"column:reactions;type:json;serializer:json"`         // 归档时写入，热数据阶段在 msg:react:<id>
+	Mentions       []string   `json:"mentions,omitempty" gorm:"column:mentions;type:json;serializer:json"`           // 群聊中被 @ 的用户 ID
+}
+
+// MessageView 对外返回的消息结构（Content 以文本形式输出）
+type MessageView struct {
+	ID             string         `json:"id"`
+	ConversationID string         `json:"conversation_id"`
+	Seq            int64          `json:"seq"`
+	SenderID       string         `json:"sender_id"`
+	Content        string         `json:"content"`
+	MsgType        string         `json:"msg_type"`
+	Timestamp      time.Time      `json:"timestamp"`
+	Recalled       bool           `json:"recalled,omitempty"`
+	RevisionCount  int            `json:"revision_count"`
+	EditedAt       *time.Time     `json:"edited_at,omitempty"`
+	ReplyTo        string         `json:"reply_to,omitempty"`
+	ThreadRoot     string         `json:"thread_root,omitempty"`
+	Reactions      map[string]int `json:"reactions,omitempty"` // emoji -> 人数
+	Mentions       []string       `json:"mentions,omitempty"`
+}
+
+// View 转换为对外返回的结构
+func (m *Message) View() *MessageView {
+	return &MessageView{
+		ID:             m.ID,
+		ConversationID: m.ConversationID,
+		Seq:            m.Seq,
+		SenderID:       m.SenderID,
+		Content:        string(m.Content),
+		MsgType:        m.MsgType,
+		Timestamp:      m.Timestamp,
+		Recalled:       m.Recalled,
+		RevisionCount:  m.RevisionCount,
+		EditedAt:       m.EditedAt,
+		ReplyTo:        m.ReplyTo,
+		ThreadRoot:     m.ThreadRoot,
+		Reactions:      m.Reactions.Counts(),
+		Mentions:       m.Mentions,
+	}
+}
+
+// Views 批量转换
+func Views(msgs []*Message) []*MessageView {
+	views := make([]*MessageView, 0, len(msgs))
+	for _, m := range msgs {
+		views = append(views, m.View())
+	}
+	return views
 }
 
 // TableName GORM 表名
@@ -20,7 +75,76 @@ func (Message) TableName() string {
 	return "messages_cold"
 }
 
+// Revision 消息被编辑前的历史版本
+type Revision struct {
+	ID             uint      `gorm:"primaryKey"`
+	MsgID          string    `gorm:"column:msg_id;uniqueIndex:uniq_msg_version,priority:1"`
+	ConversationID string    `gorm:"column:conversation_id"`
+	Version        int       `gorm:"column:version;uniqueIndex:uniq_msg_version,priority:2"` // 0 为原始内容，同一消息的版本号唯一
+	Content        []byte    `gorm:"column:content"`
+	EditedAt       time.Time `gorm:"column:edited_at"` // 该版本被替换的时间
+}
+
+// TableName GORM 表名
+func (Revision) TableName() string {
+	return "messages_revision"
+}
+
+// RevisionView 对外返回的历史版本
+type RevisionView struct {
+	MsgID    string    `json:"msg_id"`
+	Version  int       `json:"version"`
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+// Direction 按 seq 翻页的方向
+type Direction int
+
+const (
+	// Backward 取 cursor 之前（更早）的消息，按 seq 降序返回
+	Backward Direction = iota
+	// Forward 取 cursor 之后（更新）的消息，按 seq 升序返回
+	Forward
+)
+
+// UpdateFunc 在存储内修改一条消息，返回错误时放弃本次修改；ctx 携带存储自身的事务（如有）
+type UpdateFunc func(ctx context.Context, msg *Message) error
+
 type MessageStorage interface {
 	Save(ctx context.Context, msg *Message) error
-	List(ctx context.Context, convID string, start, end time.Time, limit int) ([]*Message, error)
+	BatchSave(ctx context.Context, messages []*Message) error
+	// List 以会话内 seq 为游标翻页，cursor 本身不包含在结果中；Backward 且 cursor <= 0 时从最新一条开始
+	List(ctx context.Context, convID string, cursor int64, dir Direction, limit int) ([]*Message, error)
+	// Get 按消息 ID 查询，不存在时返回 ErrMessageNotFound
+	Get(ctx context.Context, msgID string) (*Message, error)
+	// Update 原子地读取、修改并写回一条消息，返回修改后的消息；不存在时返回 ErrMessageNotFound。
+	// 并发修改时实现可以重试，fn 可能被调用多次
+	Update(ctx context.Context, msgID string, fn UpdateFunc) (*Message, error)
+	// ListThread 按 seq 升序返回话题 root 中 seq 大于 afterSeq 的回复，最多 limit 条
+	ListThread(ctx context.Context, root *Message, afterSeq int64, limit int) ([]*Message, error)
+	// React 添加或取消 userID 对消息的 emoji 回应，返回是否发生变化以及该 emoji 当前人数；
+	// 消息不存在时返回 ErrMessageNotFound
+	React(ctx context.Context, msgID, userID, emoji string, add bool) (bool, int, error)
+}
+
+// HotStorage 热存储：除读写外还要维护会话的 seq，并支持归档任务枚举会话、取出过期消息并在落入冷存储后删除
+type HotStorage interface {
+	MessageStorage
+	// LatestSeqs 批量查询会话已分配的最大 seq，没有消息的会话为 0
+	LatestSeqs(ctx context.Context, convIDs []string) (map[string]int64, error)
+	Conversations(ctx context.Context) ([]string, error)
+	// Expired 按 seq 升序返回会话开头连续的、发送时间不晚于 cutoff 的消息（最多 limit 条），
+	// 保证检查点之前的消息都已处理过
+	Expired(ctx context.Context, convID string, cutoff time.Time, limit int) ([]*Message, error)
+	// Remove 删除消息并返回删除时各消息的回应，Expired 之后新增的回应据此补写到冷存储
+	Remove(ctx context.Context, convID string, messages []*Message) (map[string]Reactions, error)
 }
+
+var (
+	_ HotStorage     = (*RedisMessageStorage)(nil)
+	_ HotStorage     = (*MemoryMessageStorage)(nil)
+	_ MessageStorage = (*MySQLMessageStorage)(nil)
+	_ ArchiveState   = (*RedisArchiveState)(nil)
+	_ ArchiveState   = (*MemoryArchiveState)(nil)
+)
@@ -1,153 +1,260 @@
 package messagesave
 
 import (
-	"HiChat/global"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
 )
 
-// Save：只写 Redis（热存储），归档任务负责落库
+var (
+	// ErrDuplicateMessage 客户端重发（ClientMsgID 相同）的消息，msg 已被回填为首次保存的内容
+	ErrDuplicateMessage = errors.New("duplicate message")
+	// ErrMessageNotFound 存储中没有该消息
+	ErrMessageNotFound = errors.New("message not found")
+)
+
+// 热/冷存储实现，由 InitStorage 注入
+var (
+	hotStorage  HotStorage
+	coldStorage MessageStorage
+)
+
+// InitStorage 注入热存储（默认 Redis）和冷存储（默认 MySQL）
+func InitStorage(hot HotStorage, cold MessageStorage) {
+	hotStorage = hot
+	coldStorage = cold
+}
+
+// Save：只写热存储，归档任务负责落库
+// 保存成功后 msg.Seq 为会话内分配的序号；写入失败时返回错误，调用方不能继续投递
 func Save(ctx context.Context, msg *Message) error {
-	// 直接使用全局 redisdb
-	if err := saveToRedis(ctx, msg); err != nil {
-		zap.S().Info("Redis 写入失败: %v", err)
+	if err := hotStorage.Save(ctx, msg); err != nil {
+		if !errors.Is(err, ErrDuplicateMessage) {
+			zap.S().Error("Save message to hot storage failed", zap.String("msg_id", msg.ID), zap.String("conv", msg.ConversationID), zap.Error(err))
+		}
+		return err
 	}
+	// 热数据即可检索，不阻塞发送
+	go indexMessages(context.Background(), msg)
 	return nil
 }
 
-func saveToRedis(ctx context.Context, msg *Message) error {
-	if msg.ID == "" || msg.ConversationID == "" || msg.Timestamp.IsZero() {
-		return fmt.Errorf("消息缺少必要字段")
-	}
-
-	// 幂等控制
-	if msg.ClientMsgID != "" {
-		key := fmt.Sprintf("msgid:%s:%s", msg.ConversationID, msg.ClientMsgID)
-		exists, err := global.RedisDB.Exists(ctx, key).Result()
-		if err != nil {
+// ClearHot 删除会话的全部热数据及其检索索引，已归档的冷数据不受影响；已分配的 seq 不回收
+func ClearHot(ctx context.Context, convID string) error {
+	for {
+		batch, err := hotStorage.List(ctx, convID, 0, Backward, archiveBatchSize)
+		if err != nil || len(batch) == 0 {
 			return err
 		}
-		if exists > 0 {
+		if _, err := hotStorage.Remove(ctx, convID, batch); err != nil {
+			return err
+		}
+		if searchIndex != nil {
+			ids := make([]string, 0, len(batch))
+			for _, m := range batch {
+				ids = append(ids, m.ID)
+			}
+			if err := searchIndex.Remove(ctx, ids...); err != nil {
+				zap.S().Warn("Remove cleared messages from search index failed", zap.String("conv", convID), zap.Error(err))
+			}
+		}
+		if len(batch) < archiveBatchSize {
 			return nil
 		}
-		global.RedisDB.SetEX(ctx, key, "1", 24*time.Hour)
 	}
+}
 
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("JSON 序列化失败: %v", err)
-	}
+func convSeqKey(convID string) string {
+	return fmt.Sprintf("conv:seq:%s", convID)
+}
 
-	zsetKey := fmt.Sprintf("conv:msg:%s", msg.ConversationID)
-	hashKey := fmt.Sprintf("msg:%s", msg.ID)
-	score := float64(msg.Timestamp.UnixNano())
+// convSeqIndexKey 会话内按 seq 排序的消息索引，用于断线重连补洞
+func convSeqIndexKey(convID string) string {
+	return fmt.Sprintf("conv:seqidx:%s", convID)
+}
 
-	pipe := global.RedisDB.TxPipeline()
-	pipe.ZAdd(ctx, zsetKey, &redis.Z{Score: score, Member: msg.ID})
-	pipe.HSet(ctx, hashKey, "data", data)
-	pipe.Expire(ctx, zsetKey, 7*24*time.Hour)
-	pipe.Expire(ctx, hashKey, 7*24*time.Hour)
+// threadIndexKey 话题内回复索引，按 seq 排序
+func threadIndexKey(rootID string) string {
+	return fmt.Sprintf("thread:msg:%s", rootID)
+}
 
-	_, err = pipe.Exec(ctx)
-	return err
+// LatestSeqs 批量查询会话当前最大 seq
+func LatestSeqs(ctx context.Context, convIDs []string) (map[string]int64, error) {
+	return hotStorage.LatestSeqs(ctx, convIDs)
 }
 
-// StartArchiveJob 定时将旧消息从 Redis 归档到 MySQL
-func StartArchiveJob(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	for range ticker.C {
-		archiveOldMessages()
+// Get 按消息 ID 查询，先查热存储，未命中再查冷存储
+func Get(ctx context.Context, msgID string) (*Message, error) {
+	msg, err := hotStorage.Get(ctx, msgID)
+	if errors.Is(err, ErrMessageNotFound) {
+		return coldStorage.Get(ctx, msgID)
 	}
+	return msg, err
 }
 
-// archiveOldMessages 执行归档逻辑
-func archiveOldMessages() {
-	cutoff := time.Now().Add(-7 * 24 * time.Hour) // 7天前
+// Recall 将消息标记为已撤回并清空内容（热数据与已归档的冷数据都会更新），msg 回填为撤回后的状态
+func Recall(ctx context.Context, msg *Message) error {
+	updated, err := updateMessage(ctx, msg.ID, func(ctx context.Context, m *Message) error {
+		m.Recalled = true
+		m.Content = nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*msg = *updated
+	indexMessages(ctx, msg)
+	return nil
+}
 
-	// 使用 SCAN 避免阻塞（生产推荐）
-	var cursor uint64
-	for {
-		var keys []string
-		var err error
-		keys, cursor, err = global.RedisDB.Scan(context.Background(), cursor, "conv:msg:*", 100).Result()
-		if err != nil {
-			return
+// Edit 修改消息内容，修改前的内容作为一个历史版本写入 messages_revision；msg 回填为修改后的状态。
+// 版本号取存储中最新的 revision_count，与内容修改一起原子提交：冷存储中两者在同一事务内，
+// 热存储乐观锁冲突重试或最终失败时撤销已写入的历史版本；(msg_id, version) 唯一，并发编辑时后到的一方失败
+func Edit(ctx context.Context, msg *Message, content []byte) error {
+	now := time.Now()
+	var added *Revision
+	updated, err := updateMessage(ctx, msg.ID, func(ctx context.Context, m *Message) error {
+		if added != nil {
+			if err := revisionStorage.RemoveRevision(ctx, added); err != nil {
+				return err
+			}
+			added = nil
 		}
-
-		for _, key := range keys {
-			convID := key[len("conv:msg:"):]
-			archiveConversation(convID, cutoff)
+		if m.Recalled {
+			return errors.New("message has been recalled")
 		}
-
-		if cursor == 0 {
-			break
+		rev := &Revision{
+			MsgID:          m.ID,
+			ConversationID: m.ConversationID,
+			Version:        m.RevisionCount,
+			Content:        m.Content,
+			EditedAt:       now,
 		}
+		if err := revisionStorage.AddRevision(ctx, rev); err != nil {
+			return err
+		}
+		added = rev
+		m.Content = content
+		m.RevisionCount++
+		m.EditedAt = &now
+		return nil
+	})
+	if err != nil {
+		if added != nil {
+			if err := revisionStorage.RemoveRevision(context.Background(), added); err != nil {
+				zap.S().Warn("Remove uncommitted revision failed", zap.String("msg_id", msg.ID), zap.Error(err))
+			}
+		}
+		return err
+	}
+	*msg = *updated
+	indexMessages(ctx, msg)
+	return nil
+}
+
+// ListRevisions 按版本顺序返回消息的历史版本
+func ListRevisions(ctx context.Context, msgID string) ([]*RevisionView, error) {
+	revs, err := revisionStorage.ListRevisions(ctx, msgID)
+	if err != nil {
+		return nil, err
+	}
+	views := make([]*RevisionView, 0, len(revs))
+	for _, r := range revs {
+		views = append(views, &RevisionView{
+			MsgID:    r.MsgID,
+			Version:  r.Version,
+			Content:  string(r.Content),
+			EditedAt: r.EditedAt,
+		})
 	}
+	return views, nil
 }
 
-// archiveConversation 归档单个会话的消息
-func archiveConversation(convID string, cutoff time.Time) {
-	key := fmt.Sprintf("conv:msg:%s", convID)
+// updateMessage 修改消息：还在热存储中的在热存储中修改，并同步归档过程中已写入冷存储的副本；
+// 已归档的直接修改冷存储。热存储修改成功即视为成功，同步冷存储副本失败只记录日志，
+// 调用方需要据此广播和改写离线队列，返回错误会让修改生效却无人知晓
+func updateMessage(ctx context.Context, msgID string, fn UpdateFunc) (*Message, error) {
+	updated, err := hotStorage.Update(ctx, msgID, fn)
+	if errors.Is(err, ErrMessageNotFound) {
+		return coldStorage.Update(ctx, msgID, fn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = coldStorage.Update(ctx, msgID, func(ctx context.Context, m *Message) error {
+		m.Content = updated.Content
+		m.Recalled = updated.Recalled
+		m.RevisionCount = updated.RevisionCount
+		m.EditedAt = updated.EditedAt
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrMessageNotFound) {
+		zap.S().Error("Sync archived copy failed", zap.String("msg_id", msgID), zap.Error(err))
+	}
+	return updated, nil
+}
 
-	// 查找过期消息
-	msgs, err := global.RedisDB.ZRangeByScoreWithScores(context.Background(), key, &redis.ZRangeBy{
-		Min: "0",
-		Max: fmt.Sprintf("%.0f", float64(cutoff.UnixNano())),
-	}).Result()
-	if err != nil || len(msgs) == 0 {
-		return
+// SyncAfter 返回会话中 seq 大于 afterSeq 的消息（按 seq 升序），
+// Redis 中缺失的早期部分（已归档）从 MySQL 补齐
+func SyncAfter(ctx context.Context, convID string, afterSeq int64, limit int) ([]*Message, bool, error) {
+	hot, err := hotStorage.List(ctx, convID, afterSeq, Forward, limit+1)
+	if err != nil {
+		return nil, false, err
 	}
 
-	var toArchive []*Message
-	for _, z := range msgs {
-		msgID := z.Member.(string)
-		data, err := global.RedisDB.HGet(context.Background(), fmt.Sprintf("msg:%s", msgID), "data").Result()
+	var msgs []*Message
+	if len(hot) == 0 || hot[0].Seq > afterSeq+1 {
+		cold, err := coldStorage.List(ctx, convID, afterSeq, Forward, limit+1)
 		if err != nil {
-			continue
+			return nil, false, err
 		}
-		msg := &Message{}
-		if err := json.Unmarshal([]byte(data), msg); err != nil {
-			continue
+		for _, m := range cold {
+			if len(hot) > 0 && m.Seq >= hot[0].Seq {
+				break
+			}
+			msgs = append(msgs, m)
 		}
-		toArchive = append(toArchive, msg)
 	}
+	msgs = append(msgs, hot...)
 
-	if len(toArchive) == 0 {
-		return
+	hasMore := len(msgs) > limit
+	if hasMore {
+		msgs = msgs[:limit]
 	}
+	return msgs, hasMore, nil
+}
 
-	// 批量写入 MySQL
-	if err := global.DB.CreateInBatches(toArchive, 100).Error; err != nil {
-		// 可记录日志，后续重试
-		return
+// ListThread 按 seq 升序返回话题中 seq 大于 afterSeq 的回复，合并冷热存储中的数据
+func ListThread(ctx context.Context, root *Message, afterSeq int64, limit int) ([]*Message, bool, error) {
+	cold, err := coldStorage.ListThread(ctx, root, afterSeq, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	hot, err := hotStorage.ListThread(ctx, root, afterSeq, limit+1)
+	if err != nil {
+		return nil, false, err
 	}
 
-	// 从 Redis 删除
-	var members []interface{}
-	for _, m := range toArchive {
-		members = append(members, m.ID)
+	// 归档过程中同一条消息可能同时存在于两边
+	seen := make(map[string]bool, len(cold)+len(hot))
+	msgs := make([]*Message, 0, len(cold)+len(hot))
+	for _, m := range append(hot, cold...) {
+		if seen[m.ID] {
+			continue
+		}
+		seen[m.ID] = true
+		msgs = append(msgs, m)
 	}
-	global.RedisDB.ZRem(context.Background(), key, members...)
-	for _, m := range toArchive {
-		global.RedisDB.Del(context.Background(), fmt.Sprintf("msg:%s", m.ID))
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Seq < msgs[j].Seq })
+
+	hasMore := len(msgs) > limit
+	if hasMore {
+		msgs = msgs[:limit]
 	}
+	return msgs, hasMore, nil
 }
-
-// service := messagesave.NewStorageService() // 无参数！
-
-// msg := &Message{
-//     ID:             "msg_001",
-//     ConversationID: "group_1",
-//     SenderID:       "user_1",
-//     Content:        "Hello",
-//     MsgType:        "text",
-//     Timestamp:      time.Now(),
-//     ClientMsgID:    "client_123",
-// }
-
-// service.Save(context.Background(), msg)
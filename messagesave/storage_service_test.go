@@ -0,0 +1,206 @@
+package messagesave
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// useMemoryStorage 以内存实现替换注入的热/冷存储，测试结束后恢复
+func useMemoryStorage(t *testing.T) (*MemoryMessageStorage, *MemoryMessageStorage) {
+	t.Helper()
+	hot, cold := NewMemoryMessageStorage(), NewMemoryMessageStorage()
+	prevHot, prevCold := hotStorage, coldStorage
+	InitStorage(hot, cold)
+	t.Cleanup(func() { InitStorage(prevHot, prevCold) })
+	return hot, cold
+}
+
+func TestSaveReturnsHotStorageError(t *testing.T) {
+	useMemoryStorage(t)
+	ctx := context.Background()
+
+	// 缺少必要字段，热存储拒绝写入
+	if err := Save(ctx, &Message{ID: "a", ConversationID: "user:1:2"}); err == nil {
+		t.Fatal("Save swallowed the hot storage error")
+	}
+
+	msg := newTestMessage("b", "user:1:2", time.Now())
+	msg.ClientMsgID = "c1"
+	if err := Save(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	dup := newTestMessage("c", "user:1:2", time.Now())
+	dup.ClientMsgID = "c1"
+	if err := Save(ctx, dup); !errors.Is(err, ErrDuplicateMessage) {
+		t.Fatalf("err = %v, want ErrDuplicateMessage", err)
+	}
+}
+
+func TestGetFallsBackToCold(t *testing.T) {
+	hot, cold := useMemoryStorage(t)
+	ctx := context.Background()
+
+	hot.Save(ctx, newTestMessage("a", "user:1:2", time.Now()))
+	archived := newTestMessage("b", "user:1:2", time.Now())
+	archived.Seq = 7
+	cold.Save(ctx, archived)
+
+	if msg, err := Get(ctx, "a"); err != nil || msg.ID != "a" {
+		t.Fatalf("hot get = %v, %v", msg, err)
+	}
+	if msg, err := Get(ctx, "b"); err != nil || msg.Seq != 7 {
+		t.Fatalf("cold get = %v, %v", msg, err)
+	}
+	if _, err := Get(ctx, "missing"); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("err = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestRecallUpdatesHotAndArchivedCopy(t *testing.T) {
+	hot, cold := useMemoryStorage(t)
+	ctx := context.Background()
+
+	msg := newTestMessage("a", "user:1:2", time.Now())
+	hot.Save(ctx, msg)
+	// 归档过程中：已写入冷存储，热数据尚未删除
+	cold.Save(ctx, msg)
+
+	stale := *msg
+	if err := Recall(ctx, &stale); err != nil {
+		t.Fatal(err)
+	}
+	if !stale.Recalled || stale.Content != nil {
+		t.Fatalf("msg not backfilled: %+v", stale)
+	}
+	for name, s := range map[string]*MemoryMessageStorage{"hot": hot, "cold": cold} {
+		got, err := s.Get(ctx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Recalled || got.Content != nil {
+			t.Fatalf("%s copy not recalled: %+v", name, got)
+		}
+	}
+}
+
+func TestRecallSucceedsWhenArchivedCopySyncFails(t *testing.T) {
+	hot := NewMemoryMessageStorage()
+	cold := NewMemoryMessageStorage()
+	prevHot, prevCold := hotStorage, coldStorage
+	InitStorage(hot, failingStorage{cold})
+	t.Cleanup(func() { InitStorage(prevHot, prevCold) })
+	ctx := context.Background()
+
+	msg := newTestMessage("a", "user:1:2", time.Now())
+	hot.Save(ctx, msg)
+	cold.Save(ctx, msg)
+
+	// 热存储已撤回，调用方必须拿到成功才会广播
+	stale := *msg
+	if err := Recall(ctx, &stale); err != nil {
+		t.Fatalf("recall err = %v, want nil", err)
+	}
+	if !stale.Recalled {
+		t.Fatalf("msg not backfilled: %+v", stale)
+	}
+	if got, _ := hot.Get(ctx, "a"); !got.Recalled {
+		t.Fatal("hot copy not recalled")
+	}
+}
+
+func TestRecallArchivedOnly(t *testing.T) {
+	_, cold := useMemoryStorage(t)
+	ctx := context.Background()
+
+	msg := newTestMessage("a", "group:1", time.Now())
+	msg.Seq = 3
+	cold.Save(ctx, msg)
+
+	if err := Recall(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cold.Get(ctx, "a"); !got.Recalled {
+		t.Fatal("archived message not recalled")
+	}
+	if err := Recall(ctx, newTestMessage("missing", "group:1", time.Now())); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("err = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestLatestSeqs(t *testing.T) {
+	hot, _ := useMemoryStorage(t)
+	ctx := context.Background()
+
+	hot.Save(ctx, newTestMessage("a", "user:1:2", time.Now()))
+	hot.Save(ctx, newTestMessage("b", "user:1:2", time.Now()))
+	seqs, err := LatestSeqs(ctx, []string{"user:1:2", "group:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seqs["user:1:2"] != 2 || seqs["group:1"] != 0 {
+		t.Fatalf("seqs = %v", seqs)
+	}
+}
+
+func TestListThreadMergesColdAndHot(t *testing.T) {
+	hot, cold := useMemoryStorage(t)
+	ctx := context.Background()
+	conv := "group:1"
+	now := time.Now()
+
+	root := newTestMessage("root", conv, now)
+	hot.Save(ctx, root)
+	var replies []*Message
+	for _, id := range []string{"r1", "r2", "r3", "r4"} {
+		r := newTestMessage(id, conv, now)
+		r.ReplyTo, r.ThreadRoot = "root", "root"
+		hot.Save(ctx, r)
+		replies = append(replies, r)
+	}
+	hot.Save(ctx, newTestMessage("other", conv, now))
+
+	// r1、r2 已归档，r2 的热数据尚未删除
+	cold.BatchSave(ctx, replies[:2])
+	hot.Remove(ctx, conv, replies[:1])
+
+	msgs, hasMore, err := ListThread(ctx, root, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := seqsOf(msgs); len(got) != 3 || got[0] != 2 || got[1] != 3 || got[2] != 4 || !hasMore {
+		t.Fatalf("page 1 = %v hasMore=%v", got, hasMore)
+	}
+	msgs, hasMore, _ = ListThread(ctx, root, 4, 3)
+	if got := seqsOf(msgs); len(got) != 1 || got[0] != 5 || hasMore {
+		t.Fatalf("page 2 = %v hasMore=%v", got, hasMore)
+	}
+}
+
+func TestSyncAfterFillsFromCold(t *testing.T) {
+	hot, cold := useMemoryStorage(t)
+	ctx := context.Background()
+	conv := "user:1:2"
+
+	var msgs []*Message
+	for _, id := range []string{"a", "b", "c", "d"} {
+		m := newTestMessage(id, conv, time.Now())
+		hot.Save(ctx, m)
+		msgs = append(msgs, m)
+	}
+	cold.BatchSave(ctx, msgs[:2])
+	hot.Remove(ctx, conv, msgs[:2])
+
+	got, hasMore, err := SyncAfter(ctx, conv, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := seqsOf(got); len(s) != 4 || s[0] != 1 || s[3] != 4 || hasMore {
+		t.Fatalf("sync = %v hasMore=%v", s, hasMore)
+	}
+	got, hasMore, _ = SyncAfter(ctx, conv, 1, 2)
+	if s := seqsOf(got); len(s) != 2 || s[0] != 2 || s[1] != 3 || !hasMore {
+		t.Fatalf("sync after 1 = %v hasMore=%v", s, hasMore)
+	}
+}
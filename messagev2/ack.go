@@ -0,0 +1,193 @@
+package messagev2
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	ackTimeout          = 10 * time.Second // 超过该时间未收到 ACK 则重发
+	maxDeliveryAttempts = 3                // 重发次数用尽后转入离线队列
+	pendingWindow       = 256              // 每个连接允许的未确认消息上限
+)
+
+// pendingMsg 已写入连接但尚未收到客户端 ACK 的消息
+type pendingMsg struct {
+	payload   []byte
+	firstSent time.Time
+	lastSent  time.Time
+	attempts  int
+}
+
+// ackWindow 单个连接的待确认窗口
+type ackWindow struct {
+	mu      sync.Mutex
+	pending map[string]*pendingMsg // MsgID -> pendingMsg
+	closed  bool
+}
+
+func newAckWindow() *ackWindow {
+	return &ackWindow{pending: make(map[string]*pendingMsg)}
+}
+
+// payloadMsgID 从下行帧中取出 MsgID，没有 MsgID 的帧（事件、控制帧）不需要确认
+func payloadMsgID(payload []byte) string {
+	var m struct {
+		MsgID string
+	}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return ""
+	}
+	return m.MsgID
+}
+
+// Push 写入发送缓冲区并登记待确认；缓冲区或确认窗口已满时返回 false，由调用方转离线
+func (c *Client) Push(payload []byte) bool {
+	msgID := payloadMsgID(payload)
+	if msgID == "" {
+		c.applyToPending(payload)
+		select {
+		case c.Send <- payload:
+			return true
+		default:
+			return false
+		}
+	}
+
+	w := c.acks
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return false
+	}
+	if _, ok := w.pending[msgID]; !ok && len(w.pending) >= pendingWindow {
+		return false
+	}
+
+	select {
+	case c.Send <- payload:
+	default:
+		return false
+	}
+
+	now := time.Now()
+	if p, ok := w.pending[msgID]; ok {
+		p.lastSent = now
+		p.attempts++
+		return true
+	}
+	w.pending[msgID] = &pendingMsg{payload: payload, firstSent: now, lastSent: now, attempts: 1}
+	return true
+}
+
+// applyToPending 撤回、编辑通知经过连接时同步待确认窗口中的原消息，之后重发或转入离线队列的不再是旧内容：
+// 撤回的直接移出窗口（撤回通知已经发给该连接），编辑的替换为最新内容
+func (c *Client) applyToPending(payload []byte) {
+	var event struct {
+		Type          string `json:"type"`
+		MsgID         string `json:"msg_id"`
+		Content       string `json:"content"`
+		RevisionCount int    `json:"revision_count"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil || event.MsgID == "" {
+		return
+	}
+	if event.Type != "recall" && event.Type != "edited" {
+		return
+	}
+
+	w := c.acks
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p, ok := w.pending[event.MsgID]
+	if !ok {
+		return
+	}
+	if event.Type == "recall" {
+		delete(w.pending, event.MsgID)
+		return
+	}
+	p.payload = patchEdited(p.payload, event.Content, event.RevisionCount)
+}
+
+// Ack 客户端确认收到消息
+func (c *Client) Ack(msgID string) {
+	c.acks.mu.Lock()
+	delete(c.acks.pending, msgID)
+	c.acks.mu.Unlock()
+}
+
+// retryLoop 定期重发超时未确认的消息，重试耗尽的消息转入离线队列
+func (c *Client) retryLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(ackTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		var expired [][]byte
+		now := time.Now()
+
+		w := c.acks
+		w.mu.Lock()
+		for msgID, p := range w.pending {
+			if now.Sub(p.lastSent) < ackTimeout {
+				continue
+			}
+			if p.attempts >= maxDeliveryAttempts {
+				expired = append(expired, p.payload)
+				delete(w.pending, msgID)
+				continue
+			}
+			select {
+			case c.Send <- p.payload:
+				p.lastSent = now
+				p.attempts++
+			default:
+				// 缓冲区满，下一轮再试
+			}
+		}
+		w.mu.Unlock()
+
+		for _, payload := range expired {
+			if err := saveOfflineMessage(c.UserID, payload); err != nil {
+				zap.S().Error("Failed to save unacked message", zap.String("user", c.UserID), zap.Error(err))
+			}
+		}
+		if len(expired) > 0 {
+			zap.S().Warn("Unacked messages moved to offline queue",
+				zap.String("user", c.UserID),
+				zap.Int("count", len(expired)))
+		}
+	}
+}
+
+// flushPending 连接断开时把未确认消息按首次发送顺序放回离线队列，重连后重新投递
+func (c *Client) flushPending() {
+	w := c.acks
+	w.mu.Lock()
+	w.closed = true
+	pending := make([]*pendingMsg, 0, len(w.pending))
+	for _, p := range w.pending {
+		pending = append(pending, p)
+	}
+	w.pending = make(map[string]*pendingMsg)
+	w.mu.Unlock()
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].firstSent.Before(pending[j].firstSent)
+	})
+	for _, p := range pending {
+		if err := saveOfflineMessage(c.UserID, p.payload); err != nil {
+			zap.S().Error("Failed to save unacked message", zap.String("user", c.UserID), zap.Error(err))
+		}
+	}
+}
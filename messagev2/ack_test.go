@@ -0,0 +1,66 @@
+package messagev2
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func newTestClient() *Client {
+	return &Client{UserID: "2", Send: make(chan []byte, 16), acks: newAckWindow()}
+}
+
+func pushMessage(t *testing.T, c *Client, msgID, content string) {
+	t.Helper()
+	value, _ := json.Marshal(Message{MsgID: msgID, From: "1", To: "2", Content: content, Timestamp: time.Now()})
+	if !c.Push(value) {
+		t.Fatal("push rejected")
+	}
+}
+
+func TestPushTracksPendingUntilAck(t *testing.T) {
+	c := newTestClient()
+	pushMessage(t, c, "m1", "hello")
+	if _, ok := c.acks.pending["m1"]; !ok {
+		t.Fatal("message not tracked")
+	}
+	c.Ack("m1")
+	if _, ok := c.acks.pending["m1"]; ok {
+		t.Fatal("acked message still pending")
+	}
+}
+
+func TestEditedEventPatchesPending(t *testing.T) {
+	c := newTestClient()
+	pushMessage(t, c, "m1", "hello")
+
+	event, _ := json.Marshal(EditedEvent{Type: "edited", MsgID: "m1", Content: "hello!", RevisionCount: 1, EditedAt: time.Now()})
+	if !c.Push(event) {
+		t.Fatal("push rejected")
+	}
+
+	var m Message
+	if err := json.Unmarshal(c.acks.pending["m1"].payload, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Content != "hello!" || m.RevisionCount != 1 || m.MsgID != "m1" {
+		t.Fatalf("pending not patched: %+v", m)
+	}
+}
+
+func TestRecallEventDropsPending(t *testing.T) {
+	c := newTestClient()
+	pushMessage(t, c, "m1", "hello")
+	pushMessage(t, c, "m2", "world")
+
+	event, _ := json.Marshal(RecallEvent{Type: "recall", MsgID: "m1", Operator: "1", Timestamp: time.Now()})
+	if !c.Push(event) {
+		t.Fatal("push rejected")
+	}
+	if _, ok := c.acks.pending["m1"]; ok {
+		t.Fatal("recalled message still pending")
+	}
+	if _, ok := c.acks.pending["m2"]; !ok {
+		t.Fatal("unrelated message dropped")
+	}
+}
@@ -0,0 +1,30 @@
+package messagev2
+
+import (
+	"HiChat/dao"
+	"errors"
+	"strconv"
+)
+
+// ErrBlocked 私聊双方存在拉黑关系
+var ErrBlocked = errors.New("message rejected: blocked")
+
+// checkBlocked 私聊前检查双方是否存在任一方向的拉黑
+func checkBlocked(from, to string) error {
+	fromID, err := strconv.ParseUint(from, 10, 64)
+	if err != nil {
+		return err
+	}
+	toID, err := strconv.ParseUint(to, 10, 64)
+	if err != nil {
+		return err
+	}
+	blocked, err := dao.BlockedBetween(uint(fromID), uint(toID))
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package messagev2
+
+import (
+	"HiChat/global"
+	"HiChat/messagesave"
+	"HiChat/models"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// useBlockDB 以内存 SQLite 替换 global.DB，只建拉黑检查用到的关系表
+func useBlockDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Relation{}); err != nil {
+		t.Fatal(err)
+	}
+	prev := global.DB
+	global.DB = db
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		global.DB = prev
+	})
+}
+
+func TestSendMessageRejectsBlocked(t *testing.T) {
+	useBlockDB(t)
+	hot, cold := messagesave.NewMemoryMessageStorage(), messagesave.NewMemoryMessageStorage()
+	messagesave.InitStorage(hot, cold)
+
+	// 2 拉黑了 1
+	if err := global.DB.Create(&models.Relation{OwnerId: 2, TargetID: 1, Type: 3}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewGateway("gw-test", 0)
+	for _, c := range []struct{ from, to string }{{"1", "2"}, {"2", "1"}} {
+		if err := g.SendMessage(c.from, c.to, "hi", "", ""); !errors.Is(err, ErrBlocked) {
+			t.Fatalf("send %s -> %s err = %v, want ErrBlocked", c.from, c.to, err)
+		}
+	}
+
+	// 被拒绝的消息不落库
+	if convs, _ := hot.Conversations(context.Background()); len(convs) != 0 {
+		t.Fatalf("blocked messages stored in %v", convs)
+	}
+}
+
+func TestCheckBlockedAllowsOthers(t *testing.T) {
+	useBlockDB(t)
+	if err := global.DB.Create(&models.Relation{OwnerId: 2, TargetID: 1, Type: 3}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBlocked("1", "3"); err != nil {
+		t.Fatalf("unrelated pair rejected: %v", err)
+	}
+	if err := checkBlocked("1", "2"); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("err = %v, want ErrBlocked", err)
+	}
+}
@@ -8,8 +8,6 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"math/rand"
 	"time"
 
 	"strconv"
@@ -26,12 +24,20 @@ const (
 )
 
 type Message struct {
-	MsgID     string // 可用雪花算法生成唯一 ID
+	MsgID     string // 雪花算法生成，按时间有序
+	Seq       int64  // 会话内严格递增序号，客户端据此发现空洞并 sync
 	ChatType  string
 	From      string
 	To        string
 	Content   string
 	Timestamp time.Time // 或者 time.Time，看你存什么
+
+	RevisionCount int      `json:",omitempty"` // 离线期间被编辑过时，离线队列中的副本会更新为最新内容
+	ReplyTo       string   `json:",omitempty"` // 引用/回复的消息 ID
+	ThreadRoot    string   `json:",omitempty"` // 所在话题的根消息 ID
+	Mentions      []string `json:",omitempty"` // 群聊中被 @ 的用户 ID，all 表示所有人
+	Muted         bool     `json:",omitempty"` // 接收方对该会话开启了免打扰，客户端不弹通知
+	MsgType       string   `json:",omitempty"` // 普通聊天消息为空，system 为群系统通知
 }
 
 // ReadPump —— 读取消息
@@ -44,8 +50,10 @@ func (c *Client) ReadPump() {
 				zap.String("gateway_id", c.Gateway))
 			// 继续关闭连接
 		} else {
-			gateway.RemoveClient(c.UserID)
+			gateway.RemoveClient(c)
 		}
+		close(c.done)
+		c.flushPending()
 		c.Conn.Close()
 	}()
 
@@ -56,9 +64,7 @@ func (c *Client) ReadPump() {
 		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 
 		// 2. 刷新 Redis 中的在线状态 TTL（新增逻辑）
-		ctx := context.Background()
-		key := "user_conn:" + c.UserID
-		if err := global.RedisDB.Expire(ctx, key, ConnTTL).Err(); err != nil {
+		if err := RefreshUserGateway(c.UserID); err != nil {
 			zap.S().Warn("Failed to refresh user online TTL in Redis",
 				zap.String("user_id", c.UserID),
 				zap.Error(err))
@@ -114,10 +120,19 @@ func (c *Client) WritePump() {
 // 处理客户端发来的消息
 func (c *Client) handleMessage(message []byte) {
 	var msg struct {
-		To          string `json:"to"`
-		Chattype    string `json:"chat_type"`
-		Content     string `json:"content"`
-		ClientMsgID string `json:"client_msg_id"`
+		Type        string   `json:"type"` // 为空表示聊天消息；ack 为投递确认；sync 为按 seq 补拉；read 为已读回执；signal 为瞬时信号；recall 为撤回；edit 为编辑；react/unreact 为表情回应
+		MsgID       string   `json:"msg_id"`
+		ConvID      string   `json:"conversation_id"`
+		Seq         int64    `json:"seq"`
+		Limit       int      `json:"limit"`
+		To          string   `json:"to"`
+		Chattype    string   `json:"chat_type"`
+		Content     string   `json:"content"`
+		ClientMsgID string   `json:"client_msg_id"`
+		Signal      string   `json:"signal"`
+		ReplyTo     string   `json:"reply_to"`
+		Emoji       string   `json:"emoji"`
+		Mentions    []string `json:"mentions"`
 	}
 	if err := json.Unmarshal(message, &msg); err != nil {
 		return
@@ -129,87 +144,221 @@ func (c *Client) handleMessage(message []byte) {
 		return
 	}
 
-	switch msg.Chattype {
-	case "group":
-		gateway.SendGroupMessage(c.UserID, msg.To, msg.Content, msg.ClientMsgID)
-	case "private", "":
-		gateway.SendMessage(c.UserID, msg.To, msg.Content, msg.ClientMsgID)
+	switch msg.Type {
+	case "ack":
+		c.Ack(msg.MsgID)
+	case "sync":
+		c.handleSync(ResolveConversationID(c.UserID, msg.ConvID, msg.To, msg.Chattype), msg.Seq, msg.Limit)
+	case "read":
+		convID := ResolveConversationID(c.UserID, msg.ConvID, msg.To, msg.Chattype)
+		if err := gateway.MarkRead(c.UserID, convID, msg.Seq, msg.MsgID); err != nil {
+			zap.S().Warn("Mark read failed", zap.String("user", c.UserID), zap.String("conv", convID), zap.Error(err))
+		}
+	case "signal":
+		c.handleSignal(gateway, msg.To, msg.Chattype, msg.Signal)
+	case "recall":
+		if err := gateway.RecallMessage(c.UserID, msg.MsgID); err != nil {
+			zap.S().Info("Recall failed", zap.String("user", c.UserID), zap.String("msg_id", msg.MsgID), zap.Error(err))
+		}
+	case "edit":
+		if err := gateway.EditMessage(c.UserID, msg.MsgID, msg.Content); err != nil {
+			zap.S().Info("Edit failed", zap.String("user", c.UserID), zap.String("msg_id", msg.MsgID), zap.Error(err))
+		}
+	case "react", "unreact":
+		if err := gateway.ReactMessage(c.UserID, msg.MsgID, msg.Emoji, msg.Type == "react"); err != nil {
+			zap.S().Info("React failed", zap.String("user", c.UserID), zap.String("msg_id", msg.MsgID), zap.Error(err))
+		}
+	case "", "message":
+		switch msg.Chattype {
+		case "group":
+			if err := gateway.SendGroupMessage(c.UserID, msg.To, msg.Content, msg.ClientMsgID, msg.ReplyTo, msg.Mentions); err != nil {
+				zap.S().Info("Send group message failed", zap.String("user", c.UserID), zap.String("group", msg.To), zap.Error(err))
+				c.replySendFailed(msg.ClientMsgID, msg.Chattype, msg.To, err)
+			}
+		case "private", "":
+			if err := gateway.SendMessage(c.UserID, msg.To, msg.Content, msg.ClientMsgID, msg.ReplyTo); err != nil {
+				zap.S().Info("Send message failed", zap.String("user", c.UserID), zap.String("to", msg.To), zap.Error(err))
+				c.replySendFailed(msg.ClientMsgID, msg.Chattype, msg.To, err)
+			}
+		default:
+			zap.S().Warn("Unsupported chat type", zap.String("type", msg.Chattype))
+		}
 	default:
-		zap.S().Warn("Unsupported chat type", zap.String("type", msg.Chattype))
+		zap.S().Warn("Unsupported frame type", zap.String("type", msg.Type))
 	}
 }
 
-const KafkaTopic = "im.msg.route"
+// SendFailedFrame 消息未能发送（被拒绝或未能落库，未投递给任何人）时回复给发送方，客户端据 client_msg_id 标记失败
+type SendFailedFrame struct {
+	Type        string `json:"type"` // 固定为 send_failed
+	ClientMsgID string `json:"client_msg_id"`
+	ChatType    string `json:"chat_type"`
+	To          string `json:"to"`
+	Error       string `json:"error"`
+}
 
-func (g *Gateway) SendGroupMessage(from, groupID, content, clientMsgID string) error {
-	// 1. 校验权限
-	inGroup, err := dao.IsUserInGroup(groupID, from)
-	if err != nil {
-		zap.S().Error("find user group faild", zap.Error(err))
-		return err
-	}
-	if !inGroup {
-		return errors.New("user not in group")
+// replySendFailed 通知发送方所在连接消息发送失败，不需要确认
+func (c *Client) replySendFailed(clientMsgID, chatType, to string, err error) {
+	value, _ := json.Marshal(SendFailedFrame{
+		Type:        "send_failed",
+		ClientMsgID: clientMsgID,
+		ChatType:    chatType,
+		To:          to,
+		Error:       err.Error(),
+	})
+	if !c.Push(value) {
+		zap.S().Warn("Reply send failure dropped", zap.String("user", c.UserID), zap.String("client_msg_id", clientMsgID))
 	}
+}
+
+const KafkaTopic = "im.msg.route"
 
-	// 2. 使用统一 conversation_id
+func (g *Gateway) SendGroupMessage(from, groupID, content, clientMsgID, replyTo string, mentions []string) error {
+	return g.sendGroupMessage(from, groupID, content, "text", clientMsgID, replyTo, mentions, nil)
+}
+
+// SendGroupSystemMessage 群系统通知（退群、踢人、解散等），在操作完成后以 operator 的名义发出。
+// 权限已由操作本身校验，这里不再要求 operator 仍在群中或未被禁言；recipients 为操作前的成员，保证被移出的人也能收到
+func (g *Gateway) SendGroupSystemMessage(operator, groupID, content string, recipients []string) error {
+	return g.sendGroupMessage(operator, groupID, content, "system", "", "", nil, recipients)
+}
+
+func (g *Gateway) sendGroupMessage(from, groupID, content, msgType, clientMsgID, replyTo string, mentions []string, recipients []string) error {
+	system := msgType == "system"
 	convID := GetGroupConvID(groupID) // "group:123"
 
-	// 3. 构造消息并保存一次
-	msgID := generateMsgID()
-	msg := Message{
-		MsgID:     msgID,
-		ChatType:  "group",
-		From:      from,
-		To:        groupID,
-		Content:   content,
-		Timestamp: time.Now(),
+	var threadRoot string
+	memberIDs := recipients
+	if !system {
+		// 1. 校验权限（成员且未被禁言）
+		member, err := dao.GroupMembership(groupID, from)
+		if err != nil {
+			zap.S().Error("find user group faild", zap.Error(err))
+			return err
+		}
+		if member == nil {
+			return errors.New("user not in group")
+		}
+		if member.MutedUntil != nil && member.MutedUntil.After(time.Now()) {
+			return errors.New("user is muted in group")
+		}
+
+		// 2. 使用统一 conversation_id
+		threadRoot, err = resolveThread(convID, replyTo)
+		if err != nil {
+			return err
+		}
+
+		// 3. 获取所有成员，校验 @ 列表
+		uintid, err := strconv.ParseUint(groupID, 10, 64)
+		if err != nil {
+			zap.S().Error("groupid parsed faild", zap.Error(err))
+			return err
+		}
+		members, err := models.FindUsers(uint(uintid))
+		if err != nil {
+			zap.S().Error("find group members faild", zap.Error(err))
+			return err
+		}
+		memberIDs = make([]string, 0, len(*members))
+		for _, memberID := range *members {
+			memberIDs = append(memberIDs, strconv.FormatUint(uint64(memberID), 10))
+		}
+		mentions, err = normalizeMentions(from, groupID, memberIDs, mentions)
+		if err != nil {
+			return err
+		}
 	}
 
-	value, _ := json.Marshal(msg)
+	// 4. 构造消息并保存一次
+	msgID := g.ids.NextString()
+	msg := Message{
+		MsgID:      msgID,
+		ChatType:   "group",
+		From:       from,
+		To:         groupID,
+		Content:    content,
+		Timestamp:  time.Now(),
+		ReplyTo:    replyTo,
+		ThreadRoot: threadRoot,
+		Mentions:   mentions,
+	}
+	if system {
+		msg.MsgType = msgType
+	}
 
 	// ✅ 只保存一次
-	messagesave.Save(context.Background(), &messagesave.Message{
+	stored := &messagesave.Message{
 		ID:             msgID,
 		ConversationID: convID,
 		SenderID:       from,
 		Content:        []byte(content),
-		MsgType:        "text",
+		MsgType:        msgType,
 		Timestamp:      msg.Timestamp,
 		ClientMsgID:    clientMsgID,
-	})
-
-	// 4. 获取所有成员并广播
-	uintid, err := strconv.ParseUint(groupID, 10, 64)
-	if err != nil {
-		zap.S().Error("groupid parsed faild", zap.Error(err))
+		ReplyTo:        replyTo,
+		ThreadRoot:     threadRoot,
+		Mentions:       mentions,
+	}
+	if err := messagesave.Save(context.Background(), stored); errors.Is(err, messagesave.ErrDuplicateMessage) {
+		// 客户端重发，首次已广播
+		return nil
+	} else if err != nil {
+		// 未落库的消息不广播，由发送方重试
 		return err
 	}
-	members, _ := models.FindUsers(uint(uintid))
-	for _, memberID := range *members {
-		idstring := strconv.FormatUint(uint64(memberID), 10)
-		if idstring == from {
+	msg.Seq = stored.Seq
+	markSentRead(from, convID, stored.Seq)
+	touchConversation(convID, memberIDs, msg.Timestamp)
+	if err := recordMentions(from, convID, memberIDs, mentions); err != nil {
+		zap.S().Warn("Record mentions failed", zap.String("conv", convID), zap.Error(err))
+	}
+
+	value, _ := json.Marshal(msg)
+	msg.Muted = true
+	mutedValue, _ := json.Marshal(msg)
+	muted := mutedMembers(convID, msg.Timestamp)
+
+	// 5. 广播（被 @ 的离线成员进入优先离线队列，免打扰的成员照常投递但带上标记；系统通知也发给操作者的其他设备）
+	for _, idstring := range memberIDs {
+		if idstring == from && !system {
 			continue
 		}
 		// 发送给 memberID（走本地 or Kafka）
-		g.sendToMember(idstring, value)
+		if muted[idstring] {
+			g.sendToMember(idstring, mutedValue)
+		} else {
+			g.sendToMember(idstring, value)
+		}
 	}
 
 	return nil
 }
 
-// sendToMember 将消息发送给指定用户（群聊或私聊复用）
+// sendToMember 将消息发送给指定用户的所有在线设备（群聊或私聊复用）
+// 用户离线、本地投递失败或跨网关路由失败时都会落入离线队列，保证消息最终送达
 func (g *Gateway) sendToMember(userID string, message []byte) {
-	// 1. 查询用户所在网关，判断是否在线
-	targetGateway, online, err := GetUserGateway(userID)
+	g.route(userID, message, false)
+}
+
+// sendEphemeral 瞬时消息只投递给在线设备，任何失败都直接丢弃
+func (g *Gateway) sendEphemeral(userID string, message []byte) {
+	g.route(userID, message, true)
+}
+
+func (g *Gateway) route(userID string, message []byte, ephemeral bool) {
+	// 1. 查询用户各设备所在网关，判断是否在线
+	devices, err := GetUserGateways(userID)
 	if err != nil {
 		zap.S().Error("Failed to query user gateway from Redis",
 			zap.String("user_id", userID),
 			zap.Error(err))
-		return
 	}
 
-	if !online {
+	if len(devices) == 0 {
+		if ephemeral {
+			return
+		}
 		// 用户离线，保存到其离线队列（以 userID 为 key）
 		if err := saveOfflineMessage(userID, message); err != nil {
 			zap.S().Error("Failed to save offline message",
@@ -223,124 +372,116 @@ func (g *Gateway) sendToMember(userID string, message []byte) {
 		return
 	}
 
-	// 2. 如果目标用户在本网关，尝试本地投递
-	if targetGateway == g.ID {
-		if client, ok := g.GetClient(userID); ok {
-			select {
-			case client.Send <- message:
-				zap.S().Debug("Message delivered locally",
-					zap.String("user_id", userID),
-					zap.String("gateway", g.ID))
-			default:
-				zap.S().Warn("Client send buffer full, dropping message",
-					zap.String("user_id", userID))
-				// 可选：转为离线？但通常说明客户端异常，可记录或断开
+	// 2. 每个网关只投递一次，由目标网关扇出到该用户在其上的所有设备
+	targets := make(map[string]struct{}, len(devices))
+	for _, gatewayID := range devices {
+		targets[gatewayID] = struct{}{}
+	}
+	for targetGateway := range targets {
+		if targetGateway == g.ID {
+			g.deliverLocal(userID, message, ephemeral)
+			continue
+		}
+
+		// 3. 目标设备在其他网关，通过 Kafka 路由
+		if err := ProduceControl(targetGateway, routeEnvelope{
+			UserID:    userID,
+			Payload:   message,
+			Ephemeral: ephemeral,
+		}); err != nil {
+			if ephemeral {
+				continue
 			}
-		} else {
-			// Redis 认为在线，但本地没找到：状态不一致（可能刚断开）
-			zap.S().Warn("User marked online in Redis but not found in gateway",
+			zap.S().Error("Failed to route message via Kafka, falling back to offline queue",
 				zap.String("user_id", userID),
-				zap.String("gateway", g.ID))
-			// 可选：清理 Redis？或忽略（下次心跳会过期）
+				zap.String("target_gateway", targetGateway),
+				zap.Error(err))
+			if err := saveOfflineMessage(userID, message); err != nil {
+				zap.S().Error("Failed to save offline message",
+					zap.String("to", userID),
+					zap.Error(err))
+			}
+			continue
 		}
-		return
-	}
 
-	// 3. 目标用户在其他网关，通过 Kafka 路由
-	if err := ProduceMessage(targetGateway, message); err != nil {
-		zap.S().Error("Failed to route message via Kafka",
+		zap.S().Debug("Message routed via Kafka",
 			zap.String("user_id", userID),
-			zap.String("target_gateway", targetGateway),
-			zap.Error(err))
-		return
+			zap.String("target_gateway", targetGateway))
 	}
-
-	zap.S().Debug("Message routed via Kafka",
-		zap.String("user_id", userID),
-		zap.String("target_gateway", targetGateway))
 }
 
 // SendMessage 发送消息主逻辑
-func (g *Gateway) SendMessage(from, to, content, clientMsgID string) error {
-	// 1. 构造消息体
-	msg := Message{
-		MsgID:     generateMsgID(), // 可用雪花算法生成唯一 ID
-		From:      from,
-		To:        to,
-		Content:   content,
-		Timestamp: time.Now(),
+func (g *Gateway) SendMessage(from, to, content, clientMsgID, replyTo string) error {
+	// 任一方拉黑对方时拒绝，消息不落库
+	if err := checkBlocked(from, to); err != nil {
+		return err
 	}
 
-	// 2. 序列化
-	value, err := json.Marshal(msg)
+	convID := GetConversationID(from, to)
+	threadRoot, err := resolveThread(convID, replyTo)
 	if err != nil {
-		zap.S().Error("Message marshal failed", zap.Error(err))
 		return err
 	}
 
-	err = messagesave.Save(context.Background(), &messagesave.Message{
+	// 1. 构造消息体
+	msg := Message{
+		MsgID:      g.ids.NextString(),
+		From:       from,
+		To:         to,
+		Content:    content,
+		Timestamp:  time.Now(),
+		ReplyTo:    replyTo,
+		ThreadRoot: threadRoot,
+	}
+
+	// 2. 保存（分配会话 seq）
+	stored := &messagesave.Message{
 		ID:             msg.MsgID,
-		ConversationID: GetConversationID(from, to), // 见下方辅助函数
+		ConversationID: convID, // 见下方辅助函数
 		SenderID:       from,
 		Content:        []byte(content), // 或封装更复杂的结构
 		MsgType:        "text",          // 可扩展
 		Timestamp:      msg.Timestamp,
 		ClientMsgID:    clientMsgID, // 如果客户端传了去重ID，可从 handleMessage 解析传入
-	})
-	if err != nil {
-		zap.S().Error("Failed to save message", zap.String("msg_id", msg.MsgID), zap.Error(err))
-		// ❗注意：这里不 return！消息可继续发送，只是未持久化
-		// 你可以选择：继续发送 or 阻止发送？
-		// 建议：继续发送，但记录日志，后续重试补录
+		ReplyTo:        replyTo,
+		ThreadRoot:     threadRoot,
 	}
-
-	// 3. 查询目标用户所在网关
-	targetGatewayID, online, err := GetUserGateway(to)
-	if err != nil {
-		zap.S().Error("Redis query failed", zap.String("to", to), zap.Error(err))
-		return err
-	}
-
-	if !online {
-		err = saveOfflineMessage(to, value)
-		if err != nil {
-			zap.S().Error("Failed to save offline message", zap.String("to", to), zap.String("msg_id", msg.MsgID), zap.Error(err))
-			// 可以选择忽略或返回错误
-			return nil // 通常离线消息失败不应阻塞主流程
-		}
-		zap.S().Info("Message saved to offline queue", zap.String("to", to), zap.String("msg_id", msg.MsgID))
+	err = messagesave.Save(context.Background(), stored)
+	if errors.Is(err, messagesave.ErrDuplicateMessage) {
+		// 客户端重发，首次已投递
 		return nil
 	}
-
-	// 4. 判断是否在本网关
-	if targetGatewayID == g.ID {
-		if client, ok := g.GetClient(to); ok {
-			select {
-			case client.Send <- value:
-				zap.S().Debug("Delivered locally", zap.String("to", to))
-			default:
-				zap.S().Warn("Send buffer full", zap.String("to", to))
-			}
-			return nil
-		}
-		// 如果 Redis 说在本网关，但实际没找到，可能是状态不一致
-		zap.S().Warn("User claimed online in this gateway but not found", zap.String("user", to))
+	if err != nil {
+		// 未落库的消息不投递，由发送方重试
+		return err
 	}
+	msg.Seq = stored.Seq
+	markSentRead(from, stored.ConversationID, stored.Seq)
+	touchConversation(stored.ConversationID, []string{from, to}, msg.Timestamp)
 
-	// 🚀 发送到 Kafka，跨网关路由
-	err = ProduceMessage(targetGatewayID, value)
+	// 3. 序列化（对端开启免打扰时带上标记）
+	msg.Muted = mutedMembers(stored.ConversationID, msg.Timestamp)[to]
+	value, err := json.Marshal(msg)
 	if err != nil {
-		zap.S().Error("Kafka produce failed", zap.String("to", to), zap.Error(err))
+		zap.S().Error("Message marshal failed", zap.Error(err))
 		return err
 	}
-	zap.S().Debug("Message routed via Kafka", zap.String("from", from), zap.String("to", to))
+
+	// 4. 投递（本地 / Kafka / 离线）
+	g.sendToMember(to, value)
+	zap.S().Debug("Message dispatched", zap.String("from", from), zap.String("to", to), zap.String("msg_id", msg.MsgID))
 
 	return nil
 }
 
+// saveOfflineMessage 写入用户的离线队列，成功后触发离线推送；所有转离线的路径（离线、路由失败、重发耗尽、断线）都经过这里
 func saveOfflineMessage(userID string, messageData []byte) error {
 	ctx := context.Background()
-	key := fmt.Sprintf("offline:messages:%s", userID)
+	key := offlineQueueKey(userID)
+	if payloadMentions(messageData, userID) {
+		// @ 到该用户的消息进入优先队列，上线后先投递
+		key = offlinePriorityKey(userID)
+	}
 
 	// 设置过期时间（例如 7 天）
 	_, err := global.RedisDB.RPush(ctx, key, messageData).Result()
@@ -350,7 +491,11 @@ func saveOfflineMessage(userID string, messageData []byte) error {
 
 	// 设置 key 过期时间（如果之前没有设置）
 	_, err = global.RedisDB.Expire(ctx, key, 7*24*time.Hour).Result()
-	return err
+	if err != nil {
+		return err
+	}
+	notifyOffline(userID, messageData)
+	return nil
 }
 
 func GetConversationID(uid1, uid2 string) string {
@@ -363,9 +508,3 @@ func GetConversationID(uid1, uid2 string) string {
 func GetGroupConvID(groupID string) string {
 	return "group:" + groupID
 }
-
-// generateMsgID 生成唯一消息 ID（示例：时间戳 + 随机数）
-// 建议使用雪花算法（snowflake）替代
-func generateMsgID() string {
-	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Intn(10000))
-}
@@ -0,0 +1,94 @@
+package messagev2
+
+import (
+	"HiChat/dao"
+	"HiChat/models"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ParseConversationID 解析会话 ID："user:<a>:<b>" 为私聊，"group:<id>" 为群聊
+func ParseConversationID(convID string) (chatType string, ids []string, err error) {
+	parts := strings.Split(convID, ":")
+	switch {
+	case len(parts) == 3 && parts[0] == "user" && parts[1] != "" && parts[2] != "":
+		return "private", parts[1:], nil
+	case len(parts) == 2 && parts[0] == "group" && parts[1] != "":
+		return "group", parts[1:], nil
+	default:
+		return "", nil, errors.New("invalid conversation id")
+	}
+}
+
+// ResolveConversationID 客户端可以直接传会话 ID，也可以传对端/群 ID 加聊天类型
+func ResolveConversationID(userID, convID, to, chatType string) string {
+	if convID != "" {
+		return convID
+	}
+	if to == "" {
+		return ""
+	}
+	if chatType == "group" {
+		return GetGroupConvID(to)
+	}
+	return GetConversationID(userID, to)
+}
+
+// CanAccessConversation 判断用户是否属于该会话
+func CanAccessConversation(userID, convID string) (bool, error) {
+	chatType, ids, err := ParseConversationID(convID)
+	if err != nil {
+		return false, err
+	}
+	if chatType == "group" {
+		return dao.IsUserInGroup(ids[0], userID)
+	}
+	return ids[0] == userID || ids[1] == userID, nil
+}
+
+// ConversationMembers 返回会话的所有成员用户 ID
+func ConversationMembers(convID string) ([]string, error) {
+	chatType, ids, err := ParseConversationID(convID)
+	if err != nil {
+		return nil, err
+	}
+	if chatType == "private" {
+		return ids, nil
+	}
+
+	gid, err := strconv.ParseUint(ids[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	members, err := models.FindUsers(uint(gid))
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]string, 0, len(*members))
+	for _, m := range *members {
+		userIDs = append(userIDs, strconv.FormatUint(uint64(m), 10))
+	}
+	return userIDs, nil
+}
+
+// UserConversations 根据好友和群关系列出用户的所有会话 ID
+func UserConversations(userID string) ([]string, error) {
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	friends, groups, err := dao.RelationTargets(uint(uid))
+	if err != nil {
+		return nil, err
+	}
+
+	convIDs := make([]string, 0, len(friends)+len(groups))
+	for _, f := range friends {
+		convIDs = append(convIDs, GetConversationID(userID, strconv.FormatUint(uint64(f), 10)))
+	}
+	for _, g := range groups {
+		convIDs = append(convIDs, GetGroupConvID(strconv.FormatUint(uint64(g), 10)))
+	}
+	return convIDs, nil
+}
@@ -0,0 +1,425 @@
+package messagev2
+
+import (
+	"HiChat/dao"
+	"HiChat/global"
+	"HiChat/messagesave"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	// UserConvsPrefix 用户的会话列表：zset，member 为会话 ID，score 为最后活跃时间（毫秒）
+	UserConvsPrefix = "user:convs:"
+
+	defaultConvListLimit = 20
+	maxConvListLimit     = 100
+	previewRunes         = 50
+)
+
+// ConversationItem 会话列表中的一项
+type ConversationItem struct {
+	ConversationID string       `json:"conversation_id"`
+	ChatType       string       `json:"chat_type"`
+	TargetID       string       `json:"target_id"` // 私聊为对端用户 ID，群聊为群 ID
+	Name           string       `json:"name"`
+	Avatar         string       `json:"avatar"`
+	LastMessage    *LastMessage `json:"last_message,omitempty"`
+	LastActiveAt   int64        `json:"last_active_at"` // 毫秒
+	Unread         int64        `json:"unread"`
+	Mentions       int64        `json:"mentions"`
+	Pinned         bool         `json:"pinned"`
+	Muted          bool         `json:"muted"`
+	MutedUntil     *time.Time   `json:"muted_until,omitempty"`
+	Archived       bool         `json:"archived"`
+}
+
+// LastMessage 最后一条消息的预览
+type LastMessage struct {
+	MsgID     string    `json:"msg_id"`
+	SenderID  string    `json:"sender_id"`
+	MsgType   string    `json:"msg_type"`
+	Preview   string    `json:"preview"`
+	Recalled  bool      `json:"recalled,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// touchConversation 更新所有成员会话列表中该会话的活跃时间
+func touchConversation(convID string, members []string, at time.Time) {
+	ctx := context.Background()
+	score := float64(at.UnixMilli())
+	pipe := global.RedisDB.Pipeline()
+	for _, memberID := range members {
+		pipe.ZAdd(ctx, UserConvsPrefix+memberID, &redis.Z{Score: score, Member: convID})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		zap.S().Warn("Touch conversation failed", zap.String("conv", convID), zap.Error(err))
+	}
+}
+
+// removeConversation 从用户的会话列表中移除会话（退群、被移出、群解散）
+func removeConversation(convID string, userIDs ...string) {
+	ctx := context.Background()
+	pipe := global.RedisDB.Pipeline()
+	for _, userID := range userIDs {
+		pipe.ZRem(ctx, UserConvsPrefix+userID, convID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		zap.S().Warn("Remove conversation from list failed", zap.String("conv", convID), zap.Error(err))
+	}
+}
+
+// visibleConversation 群会话只对仍在群中的成员可见：已退群、被移出或群已解散时顺带从列表中清理。
+// 查询失败时不隐藏，避免临时故障让会话从列表中消失
+func visibleConversation(userID, convID string) bool {
+	chatType, ids, err := ParseConversationID(convID)
+	if err != nil || chatType != "group" {
+		return true
+	}
+	member, err := dao.GroupMembership(ids[0], userID)
+	if err != nil {
+		zap.S().Warn("Check group membership failed", zap.String("conv", convID), zap.Error(err))
+		return true
+	}
+	if member == nil {
+		removeConversation(convID, userID)
+		return false
+	}
+	return true
+}
+
+// seedConversations 用户第一次拉取列表时，用好友和群关系及各会话最后一条消息初始化
+func seedConversations(ctx context.Context, userID string) error {
+	convIDs, err := UserConversations(userID)
+	if err != nil || len(convIDs) == 0 {
+		return err
+	}
+
+	history := messagesave.DefaultHistory()
+	zs := make([]*redis.Z, 0, len(convIDs))
+	for _, convID := range convIDs {
+		msgs, _, _, err := history.Before(ctx, convID, 0, 1)
+		if err != nil || len(msgs) == 0 {
+			continue
+		}
+		zs = append(zs, &redis.Z{Score: float64(msgs[0].Timestamp.UnixMilli()), Member: convID})
+	}
+	if len(zs) == 0 {
+		return nil
+	}
+	return global.RedisDB.ZAdd(ctx, UserConvsPrefix+userID, zs...).Err()
+}
+
+// convCursor 会话列表游标：上一页最后一项的活跃时间（毫秒）和会话 ID。
+// zset 中同一分值的成员按 member 倒序排列，游标带上会话 ID 才能在同一毫秒内的会话之间接着翻页
+type convCursor struct {
+	score  int64
+	convID string
+}
+
+// parseConvCursor 解析 "<毫秒>:<会话 ID>"；只有毫秒（旧版游标）时同一毫秒的会话全部视为已返回
+func parseConvCursor(s string) (convCursor, bool) {
+	if s == "" {
+		return convCursor{}, false
+	}
+	scorePart, convID := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		scorePart, convID = s[:i], s[i+1:]
+	}
+	score, err := strconv.ParseInt(scorePart, 10, 64)
+	if err != nil || score <= 0 {
+		return convCursor{}, false
+	}
+	return convCursor{score: score, convID: convID}, true
+}
+
+func (c convCursor) String() string {
+	return strconv.FormatInt(c.score, 10) + ":" + c.convID
+}
+
+// after 判断 z 是否排在游标之后（按分值倒序、同分值按会话 ID 倒序）
+func (c convCursor) after(z redis.Z) bool {
+	score := int64(z.Score)
+	if score != c.score {
+		return score < c.score
+	}
+	return c.convID != "" && z.Member.(string) < c.convID
+}
+
+// ConversationList 按最后活跃时间倒序分页返回会话列表；cursor 为上一页返回的游标，空表示从最新开始。
+// 置顶会话只在第一页最前面返回一次；已归档会话不在列表中，archived 为 true 时只返回已归档会话
+func ConversationList(userID, cursor string, limit int, archived bool) ([]*ConversationItem, string, bool, error) {
+	if limit <= 0 {
+		limit = defaultConvListLimit
+	}
+	if limit > maxConvListLimit {
+		limit = maxConvListLimit
+	}
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	ctx := context.Background()
+	key := UserConvsPrefix + userID
+	cur, paging := parseConvCursor(cursor)
+	if !paging {
+		if n, err := global.RedisDB.Exists(ctx, key).Result(); err == nil && n == 0 {
+			if err := seedConversations(ctx, userID); err != nil {
+				zap.S().Warn("Seed conversations failed", zap.String("user", userID), zap.Error(err))
+			}
+		}
+	}
+
+	flagged, err := dao.PinnedOrArchived(uint(uid))
+	if err != nil {
+		return nil, "", false, err
+	}
+	pinnedSet := make(map[string]bool)
+	archivedSet := make(map[string]bool)
+	for _, s := range flagged {
+		if s.Archived {
+			archivedSet[s.ConversationID] = true
+		} else if s.Pinned {
+			pinnedSet[s.ConversationID] = true
+		}
+	}
+
+	var pinned []redis.Z
+	if !archived && !paging {
+		if pinned, err = pinnedConversations(ctx, userID, key, pinnedSet); err != nil {
+			return nil, "", false, err
+		}
+	}
+
+	// 过滤会让一页变少，按剩余数量继续往后取，直到凑满或取完
+	var zs []redis.Z
+	for len(zs) <= limit {
+		want := limit + 1 - len(zs)
+		max := "+inf"
+		var ties int64
+		if paging {
+			// 分值取闭区间，与游标同一毫秒、排在它之前的会话多取出来再跳过
+			max = strconv.FormatInt(cur.score, 10)
+			if ties, err = global.RedisDB.ZCount(ctx, key, max, max).Result(); err != nil {
+				return nil, "", false, err
+			}
+		}
+		batch, err := global.RedisDB.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   max,
+			Count: int64(want) + ties,
+		}).Result()
+		if err != nil {
+			return nil, "", false, err
+		}
+
+		next, advanced := cur, false
+		for _, z := range batch {
+			if paging && !cur.after(z) {
+				continue
+			}
+			next, advanced = convCursor{score: int64(z.Score), convID: z.Member.(string)}, true
+			convID := z.Member.(string)
+			if archived != archivedSet[convID] || pinnedSet[convID] || !visibleConversation(userID, convID) {
+				continue
+			}
+			zs = append(zs, z)
+		}
+		if len(batch) < want+int(ties) || !advanced {
+			break
+		}
+		cur, paging = next, true
+	}
+	hasMore := len(zs) > limit
+	if hasMore {
+		zs = zs[:limit]
+	}
+	var next string
+	if len(zs) > 0 {
+		last := zs[len(zs)-1]
+		next = convCursor{score: int64(last.Score), convID: last.Member.(string)}.String()
+	}
+	zs = append(pinned, zs...)
+
+	items := make([]*ConversationItem, 0, len(zs))
+	convIDs := make([]string, 0, len(zs))
+	for _, z := range zs {
+		convID := z.Member.(string)
+		chatType, ids, err := ParseConversationID(convID)
+		if err != nil {
+			continue
+		}
+		item := &ConversationItem{
+			ConversationID: convID,
+			ChatType:       chatType,
+			LastActiveAt:   int64(z.Score),
+		}
+		if chatType == "group" {
+			item.TargetID = ids[0]
+		} else if ids[0] == userID {
+			item.TargetID = ids[1]
+		} else {
+			item.TargetID = ids[0]
+		}
+		items = append(items, item)
+		convIDs = append(convIDs, convID)
+	}
+
+	if err := fillConversationMeta(items); err != nil {
+		return nil, "", false, err
+	}
+	if err := fillConversationSettings(uint(uid), items); err != nil {
+		return nil, "", false, err
+	}
+
+	counts, err := unreadCounts(userID, convIDs)
+	if err != nil {
+		return nil, "", false, err
+	}
+	history := messagesave.DefaultHistory()
+	for i, item := range items {
+		item.Unread = counts[i].Unread
+		item.Mentions = counts[i].Mentions
+
+		msgs, _, _, err := history.Before(ctx, item.ConversationID, 0, 1)
+		if err != nil {
+			zap.S().Warn("Load last message failed", zap.String("conv", item.ConversationID), zap.Error(err))
+			continue
+		}
+		if len(msgs) > 0 {
+			item.LastMessage = previewOf(msgs[0])
+		}
+	}
+
+	return items, next, hasMore, nil
+}
+
+// pinnedConversations 取置顶会话的活跃时间并按时间倒序排列，没有活跃记录的排在最后；已不在其中的群会话不返回
+func pinnedConversations(ctx context.Context, userID, key string, pinnedSet map[string]bool) ([]redis.Z, error) {
+	if len(pinnedSet) == 0 {
+		return nil, nil
+	}
+	pipe := global.RedisDB.Pipeline()
+	cmds := make(map[string]*redis.FloatCmd, len(pinnedSet))
+	for convID := range pinnedSet {
+		cmds[convID] = pipe.ZScore(ctx, key, convID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	pinned := make([]redis.Z, 0, len(cmds))
+	for convID, cmd := range cmds {
+		if !visibleConversation(userID, convID) {
+			continue
+		}
+		score, _ := cmd.Result()
+		pinned = append(pinned, redis.Z{Score: score, Member: convID})
+	}
+	sort.Slice(pinned, func(i, j int) bool {
+		if pinned[i].Score != pinned[j].Score {
+			return pinned[i].Score > pinned[j].Score
+		}
+		return pinned[i].Member.(string) < pinned[j].Member.(string)
+	})
+	return pinned, nil
+}
+
+// fillConversationSettings 补充用户自己的置顶、免打扰、归档设置
+func fillConversationSettings(ownerID uint, items []*ConversationItem) error {
+	convIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		convIDs = append(convIDs, item.ConversationID)
+	}
+	settings, err := dao.ConversationSettings(ownerID, convIDs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, item := range items {
+		s, ok := settings[item.ConversationID]
+		if !ok {
+			continue
+		}
+		item.Pinned = s.Pinned
+		item.Archived = s.Archived
+		if s.IsMuted(now) {
+			item.Muted = true
+			item.MutedUntil = s.MutedUntil
+		}
+	}
+	return nil
+}
+
+// fillConversationMeta 批量补充对端用户或群的名称、头像
+func fillConversationMeta(items []*ConversationItem) error {
+	var userIDs, groupIDs []uint
+	for _, item := range items {
+		id, err := strconv.ParseUint(item.TargetID, 10, 64)
+		if err != nil {
+			continue
+		}
+		if item.ChatType == "group" {
+			groupIDs = append(groupIDs, uint(id))
+		} else {
+			userIDs = append(userIDs, uint(id))
+		}
+	}
+
+	users, err := dao.FindUsersByIDs(userIDs)
+	if err != nil {
+		return err
+	}
+	communities, err := dao.FindCommunitiesByIDs(groupIDs)
+	if err != nil {
+		return err
+	}
+
+	type meta struct{ name, avatar string }
+	userMeta := make(map[string]meta, len(users))
+	for _, u := range users {
+		userMeta[strconv.FormatUint(uint64(u.ID), 10)] = meta{u.Name, u.Avatar}
+	}
+	groupMeta := make(map[string]meta, len(communities))
+	for _, c := range communities {
+		groupMeta[strconv.FormatUint(uint64(c.ID), 10)] = meta{c.Name, c.Image}
+	}
+
+	for _, item := range items {
+		m := userMeta[item.TargetID]
+		if item.ChatType == "group" {
+			m = groupMeta[item.TargetID]
+		}
+		item.Name, item.Avatar = m.name, m.avatar
+	}
+	return nil
+}
+
+// previewOf 生成最后一条消息的预览，文本超过 previewRunes 个字符时截断
+func previewOf(m *messagesave.Message) *LastMessage {
+	last := &LastMessage{
+		MsgID:     m.ID,
+		SenderID:  m.SenderID,
+		MsgType:   m.MsgType,
+		Recalled:  m.Recalled,
+		Timestamp: m.Timestamp,
+	}
+	if m.Recalled || (m.MsgType != "text" && m.MsgType != "system") {
+		return last
+	}
+	content := string(m.Content)
+	if utf8.RuneCountInString(content) > previewRunes {
+		content = string([]rune(content)[:previewRunes]) + "…"
+	}
+	last.Preview = content
+	return last
+}
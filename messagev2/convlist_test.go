@@ -0,0 +1,91 @@
+package messagev2
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestParseConvCursor(t *testing.T) {
+	c, ok := parseConvCursor("1700000000000:user:1:2")
+	if !ok || c.score != 1700000000000 || c.convID != "user:1:2" {
+		t.Fatalf("parsed %+v, %v", c, ok)
+	}
+	if got := c.String(); got != "1700000000000:user:1:2" {
+		t.Fatalf("String() = %q", got)
+	}
+
+	// 旧版游标只有毫秒
+	c, ok = parseConvCursor("1700000000000")
+	if !ok || c.score != 1700000000000 || c.convID != "" {
+		t.Fatalf("parsed legacy %+v, %v", c, ok)
+	}
+	for _, bad := range []string{"", "abc:group:1", "0:group:1", "-5"} {
+		if _, ok := parseConvCursor(bad); ok {
+			t.Errorf("parseConvCursor(%q) accepted", bad)
+		}
+	}
+}
+
+// 按 zset 的倒序（分值倒序、同分值 member 倒序）逐页翻完，同一毫秒的会话不能被跳过或重复
+func TestConvCursorPagesThroughTies(t *testing.T) {
+	all := []redis.Z{
+		{Score: 300, Member: "user:1:5"},
+		{Score: 200, Member: "group:1"},
+		{Score: 200, Member: "group:2"},
+		{Score: 200, Member: "group:3"},
+		{Score: 200, Member: "user:1:2"},
+		{Score: 100, Member: "user:1:3"},
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Score != all[j].Score {
+			return all[i].Score > all[j].Score
+		}
+		return all[i].Member.(string) > all[j].Member.(string)
+	})
+
+	seen := make(map[string]int)
+	var cur convCursor
+	paging := false
+	for pages := 0; pages < 10; pages++ {
+		var page []redis.Z
+		for _, z := range all {
+			if paging && !cur.after(z) {
+				continue
+			}
+			page = append(page, z)
+			if len(page) == 2 {
+				break
+			}
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, z := range page {
+			seen[z.Member.(string)]++
+		}
+		last := page[len(page)-1]
+		next, _ := parseConvCursor(convCursor{score: int64(last.Score), convID: last.Member.(string)}.String())
+		cur, paging = next, true
+	}
+
+	if len(seen) != len(all) {
+		t.Fatalf("visited %d conversations, want %d: %v", len(seen), len(all), seen)
+	}
+	for convID, n := range seen {
+		if n != 1 {
+			t.Errorf("%s returned %d times", convID, n)
+		}
+	}
+}
+
+func TestLegacyCursorIsExclusive(t *testing.T) {
+	cur, _ := parseConvCursor("200")
+	if cur.after(redis.Z{Score: 200, Member: "group:1"}) {
+		t.Fatal("legacy cursor returned a conversation from the same millisecond")
+	}
+	if !cur.after(redis.Z{Score: 199, Member: "group:1"}) {
+		t.Fatal("legacy cursor skipped an older conversation")
+	}
+}
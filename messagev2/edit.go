@@ -0,0 +1,107 @@
+package messagev2
+
+import (
+	"HiChat/messagesave"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// EditedEvent 编辑通知
+type EditedEvent struct {
+	Type           string    `json:"type"` // 固定为 edited
+	MsgID          string    `json:"msg_id"`
+	ConversationID string    `json:"conversation_id"`
+	Seq            int64     `json:"seq"`
+	Content        string    `json:"content"`
+	RevisionCount  int       `json:"revision_count"`
+	EditedAt       time.Time `json:"edited_at"`
+}
+
+// EditMessage 发送者修改已发送的文本消息
+func (g *Gateway) EditMessage(operator, msgID, content string) error {
+	if content == "" {
+		return errors.New("content is empty")
+	}
+
+	ctx := context.Background()
+	msg, err := messagesave.Get(ctx, msgID)
+	if err != nil {
+		return errors.New("message not found")
+	}
+	if msg.SenderID != operator {
+		return errors.New("only the sender can edit this message")
+	}
+	if msg.Recalled {
+		return errors.New("message has been recalled")
+	}
+	if msg.MsgType != "text" {
+		return errors.New("only text messages can be edited")
+	}
+
+	if err := messagesave.Edit(ctx, msg, []byte(content)); err != nil {
+		return err
+	}
+
+	value, _ := json.Marshal(EditedEvent{
+		Type:           "edited",
+		MsgID:          msg.ID,
+		ConversationID: msg.ConversationID,
+		Seq:            msg.Seq,
+		Content:        content,
+		RevisionCount:  msg.RevisionCount,
+		EditedAt:       *msg.EditedAt,
+	})
+
+	members, err := ConversationMembers(msg.ConversationID)
+	if err != nil {
+		return err
+	}
+	for _, memberID := range members {
+		// 离线用户：更新离线队列中尚未投递的副本，上线后直接看到最新内容
+		if _, err := rewriteOfflineMessage(memberID, msg.ID, func(old []byte) []byte {
+			return patchEdited(old, content, msg.RevisionCount)
+		}); err != nil {
+			zap.S().Warn("Patch offline message failed", zap.String("user", memberID), zap.Error(err))
+		}
+
+		// 在线用户实时推送
+		g.sendEphemeral(memberID, value)
+	}
+	return nil
+}
+
+// patchEdited 把尚未确认或尚未投递的下行消息替换为编辑后的内容，无法解析时原样返回
+func patchEdited(old []byte, content string, revisionCount int) []byte {
+	var m Message
+	if err := json.Unmarshal(old, &m); err != nil {
+		return old
+	}
+	m.Content = content
+	m.RevisionCount = revisionCount
+	patched, err := json.Marshal(m)
+	if err != nil {
+		return old
+	}
+	return patched
+}
+
+// MessageRevisions 返回消息的编辑历史，调用者必须属于该会话
+func MessageRevisions(userID, msgID string) ([]*messagesave.RevisionView, error) {
+	ctx := context.Background()
+	msg, err := messagesave.Get(ctx, msgID)
+	if err != nil {
+		return nil, errors.New("message not found")
+	}
+	ok, err := CanAccessConversation(userID, msg.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("not a member of this conversation")
+	}
+	return messagesave.ListRevisions(ctx, msgID)
+}
@@ -0,0 +1,73 @@
+package messagev2
+
+import (
+	"HiChat/global"
+	"HiChat/messagesave"
+	"HiChat/models"
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// FriendRequestEvent 好友申请通知：新申请推送给被申请人，处理结果推送给双方
+type FriendRequestEvent struct {
+	Type      string    `json:"type"` // 固定为 friend_request
+	RequestID uint      `json:"request_id"`
+	FromID    string    `json:"from_id"`
+	ToID      string    `json:"to_id"`
+	Status    string    `json:"status"` // pending / accepted / rejected / expired
+	Greeting  string    `json:"greeting,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// FriendRequestStatus 申请状态的文字表示
+func FriendRequestStatus(status int) string {
+	switch status {
+	case models.FriendRequestPending:
+		return "pending"
+	case models.FriendRequestAccepted:
+		return "accepted"
+	case models.FriendRequestRejected:
+		return "rejected"
+	default:
+		return "expired"
+	}
+}
+
+// NotifyFriendRequest 推送好友申请或处理结果，离线用户上线后从离线队列收到
+func (g *Gateway) NotifyFriendRequest(req *models.FriendRequest) {
+	fromID := strconv.FormatUint(uint64(req.FromId), 10)
+	toID := strconv.FormatUint(uint64(req.ToId), 10)
+	value, _ := json.Marshal(FriendRequestEvent{
+		Type:      "friend_request",
+		RequestID: req.ID,
+		FromID:    fromID,
+		ToID:      toID,
+		Status:    FriendRequestStatus(req.Status),
+		Greeting:  req.Greeting,
+		Timestamp: time.Now(),
+	})
+
+	g.sendToMember(toID, value)
+	if req.Status != models.FriendRequestPending {
+		g.sendToMember(fromID, value)
+	}
+}
+
+// ClearPrivateHistory 删除好友时清空双方私聊的热数据，并把会话从双方的会话列表中移除
+func ClearPrivateHistory(userID, friendID string) error {
+	ctx := context.Background()
+	convID := GetConversationID(userID, friendID)
+	if err := messagesave.ClearHot(ctx, convID); err != nil {
+		return err
+	}
+
+	pipe := global.RedisDB.Pipeline()
+	for _, uid := range []string{userID, friendID} {
+		pipe.ZRem(ctx, UserConvsPrefix+uid, convID)
+		pipe.HDel(ctx, MentionCountPrefix+uid, convID)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -4,10 +4,15 @@ package messagev2
 
 import (
 	"HiChat/global"
+	"HiChat/idgen"
+	"HiChat/middlewear"
 	"context"
+	"errors"
 	"fmt"
-	"hash/fnv"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,25 +23,80 @@ import (
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // 允许所有来源
-	},
+	CheckOrigin: checkOrigin,
 }
 
-// Client 代表一个用户 WebSocket 连接
+// checkOrigin 按配置的白名单校验来源；未配置白名单时只允许同源
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // 非浏览器客户端不带 Origin，依赖 token 鉴权
+	}
+
+	allowed := global.ServiceConfig.WebSocket.AllowedOrigins
+	if len(allowed) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsProtocolBearer 浏览器无法自定义握手头，通过 Sec-WebSocket-Protocol: bearer, <token> 传 token
+const wsProtocolBearer = "bearer"
+
+// authenticate 校验握手请求中的 JWT，返回 token 中的用户 ID 及需要回写的子协议
+func authenticate(r *http.Request) (userID string, protocol string, err error) {
+	token := middlewear.TokenFromHeader(r)
+	if token == "" {
+		protocols := websocket.Subprotocols(r)
+		for i, p := range protocols {
+			if strings.EqualFold(p, wsProtocolBearer) && i+1 < len(protocols) {
+				token = protocols[i+1]
+				protocol = p
+				break
+			}
+		}
+	}
+	if token == "" {
+		return "", "", errors.New("missing token")
+	}
+
+	claims, err := middlewear.ParseToken(token)
+	if err != nil {
+		return "", "", err
+	}
+	return strconv.FormatUint(uint64(claims.UserID), 10), protocol, nil
+}
+
+// Client 代表一个用户设备的 WebSocket 连接
 type Client struct {
-	UserID  string
-	Gateway string
-	Conn    *websocket.Conn
-	Send    chan []byte
+	UserID      string
+	DeviceID    string
+	Platform    string
+	ConnectedAt time.Time
+	Gateway     string
+	Conn        *websocket.Conn
+	Send        chan []byte
+
+	acks *ackWindow    // 待确认消息窗口
+	done chan struct{} // 连接关闭信号
 }
 
 // Gateway 代表一个网关节点（可运行多个实例）
 type Gateway struct {
 	ID      string
 	Port    int
-	Clients map[string]*Client // userID -> Client
+	Clients map[string]map[string]*Client // userID -> deviceID -> Client
 	Mu      sync.RWMutex
+
+	assigned chan int       // 成员注册表分配给本网关的 Kafka partition
+	ids      *idgen.Node    // 消息 ID 生成器，worker ID 从 Redis 租用
+	signals  *senderLimiter // 瞬时信号按发送者限流
 }
 
 var (
@@ -58,88 +118,143 @@ func GetGatewayByID(gatewayID string) (*Gateway, bool) {
 	return g, ok
 }
 
+// DefaultGateway 返回本进程中任意一个网关，供 REST 接口复用网关的路由能力
+func DefaultGateway() (*Gateway, bool) {
+	gatewaysMu.RLock()
+	defer gatewaysMu.RUnlock()
+	var picked *Gateway
+	for _, g := range gateways {
+		if picked == nil || g.ID < picked.ID {
+			picked = g
+		}
+	}
+	return picked, picked != nil
+}
+
 // NewGateway 创建新网关
 func NewGateway(id string, port int) *Gateway {
 	return &Gateway{
-		ID:      id,
-		Port:    port,
-		Clients: make(map[string]*Client),
+		ID:       id,
+		Port:     port,
+		Clients:  make(map[string]map[string]*Client),
+		assigned: make(chan int, 1),
+		signals:  newSenderLimiter(signalRate, signalBurst),
 	}
 }
 
-// AddClient 注册客户端
+// AddClient 注册客户端；同一设备重复连接时替换旧连接
 func (g *Gateway) AddClient(client *Client) {
 	g.Mu.Lock()
-	g.Clients[client.UserID] = client
+	devices, ok := g.Clients[client.UserID]
+	if !ok {
+		devices = make(map[string]*Client)
+		g.Clients[client.UserID] = devices
+	}
+	old := devices[client.DeviceID]
+	devices[client.DeviceID] = client
 	g.Mu.Unlock()
 
-	// 向 Redis 注册用户所在网关（用于路由）
-	ctx := context.Background()
-	err := global.RedisDB.Set(ctx, "user_conn:"+client.UserID, g.ID, 30*time.Second).Err()
-	if err != nil {
-		zap.S().Warn("Redis set failed", zap.String("user", client.UserID), zap.Error(err))
+	if old != nil {
+		old.Kick("replaced by new connection")
 	}
-	zap.S().Info("User connected", zap.String("user", client.UserID), zap.String("gateway", g.ID))
+
+	// 向 Redis 注册设备所在网关（用于路由）
+	SetUserGateway(client.UserID, client.DeviceID, client.Platform, g.ID, client.ConnectedAt)
+	zap.S().Info("User connected",
+		zap.String("user", client.UserID),
+		zap.String("device", client.DeviceID),
+		zap.String("platform", client.Platform),
+		zap.String("gateway", g.ID))
+
+	g.enforceDevicePolicy(client)
 }
 
-// RemoveClient 注销客户端
-func (g *Gateway) RemoveClient(userID string) {
+// RemoveClient 注销客户端；仅当本地登记的仍是该连接时才移除（避免误删重连后的新连接）
+func (g *Gateway) RemoveClient(client *Client) {
 	g.Mu.Lock()
-	delete(g.Clients, userID)
+	devices := g.Clients[client.UserID]
+	if devices[client.DeviceID] != client {
+		g.Mu.Unlock()
+		return
+	}
+	delete(devices, client.DeviceID)
+	if len(devices) == 0 {
+		delete(g.Clients, client.UserID)
+	}
 	g.Mu.Unlock()
 
 	// 从 Redis 删除
-	ctx := context.Background()
-	global.RedisDB.Del(ctx, "user_conn:"+userID)
-	zap.S().Info("User disconnected", zap.String("user", userID))
+	RemoveUserGateway(client.UserID, client.DeviceID, g.ID)
+	zap.S().Info("User disconnected",
+		zap.String("user", client.UserID),
+		zap.String("device", client.DeviceID))
 }
 
-// func GetClientByUserID(userID string) (*Client, bool) {
-// 	// 1. 查 Redis：用户在哪个网关？
-// 	gatewayID, online, err := GetUserGateway(userID)
-// 	if !online || err != nil {
-// 		return nil, false
-// 	}
-
-// 	// 2. 获取网关实例
-// 	gateway, ok := GetGatewayByID(gatewayID)
-// 	if !ok {
-// 		return nil, false
-// 	}
-
-// 	// 3. 在网关中查找 Client
-// 	return gateway.GetClient(userID)
-// }
+// GetClients 获取用户在本网关上的所有设备连接
+func (g *Gateway) GetClients(userID string) []*Client {
+	g.Mu.RLock()
+	defer g.Mu.RUnlock()
+	devices := g.Clients[userID]
+	clients := make([]*Client, 0, len(devices))
+	for _, c := range devices {
+		clients = append(clients, c)
+	}
+	return clients
+}
 
-// GetClient 获取本地客户端
-func (g *Gateway) GetClient(userID string) (*Client, bool) {
+// GetClient 获取本地指定设备的连接
+func (g *Gateway) GetClient(userID, deviceID string) (*Client, bool) {
 	g.Mu.RLock()
 	defer g.Mu.RUnlock()
-	c, ok := g.Clients[userID]
+	c, ok := g.Clients[userID][deviceID]
 	return c, ok
 }
 
 // HandleWebSocket 处理 WebSocket 连接（所有网关共用）
 func (g *Gateway) HandleWebSocket(c *gin.Context) {
-	userID := c.Query("userId")
-	if userID == "" {
-		c.JSON(400, gin.H{"error": "missing userId"})
+	// 升级前鉴权，用户 ID 以 token 为准
+	userID, protocol, err := authenticate(c.Request)
+	if err != nil {
+		zap.S().Info("WebSocket auth failed", zap.String("remote", c.ClientIP()), zap.Error(err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		c.Abort()
 		return
 	}
+	if q := c.Query("userId"); q != "" && q != userID {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "userId does not match token"})
+		c.Abort()
+		return
+	}
+
+	var header http.Header
+	if protocol != "" {
+		header = http.Header{"Sec-Websocket-Protocol": []string{protocol}}
+	}
 
 	// 升级为 WebSocket 连接
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, header)
 	if err != nil {
 		zap.L().Error("WebSocket upgrade failed", zap.Error(err))
 		return
 	}
 
+	// 设备 ID 由客户端持久化后传入，未传时视为一次性会话
+	deviceID := c.Query("deviceId")
+	if deviceID == "" {
+		deviceID = "anon-" + g.ids.NextString()
+	}
+	platform := c.DefaultQuery("platform", "web")
+
 	client := &Client{
-		UserID:  userID,
-		Gateway: g.ID,
-		Conn:    conn,
-		Send:    make(chan []byte, 1000),
+		UserID:      userID,
+		DeviceID:    deviceID,
+		Platform:    platform,
+		ConnectedAt: time.Now(),
+		Gateway:     g.ID,
+		Conn:        conn,
+		Send:        make(chan []byte, 1000),
+		acks:        newAckWindow(),
+		done:        make(chan struct{}),
 	}
 	// 注册到本地
 	g.AddClient(client)
@@ -148,18 +263,24 @@ func (g *Gateway) HandleWebSocket(c *gin.Context) {
 	// 启动读写协程
 	go client.WritePump()
 	go client.ReadPump()
-}
-
-// helper: 根据 gateway ID 计算 partition（确定性）
-func gatewayIDToPartition(gatewayID string, numPartitions int) int {
-	h := fnv.New32a()
-	h.Write([]byte(gatewayID))
-	return int(h.Sum32()) % numPartitions
+	go client.retryLoop(client.done)
 }
 
 // Start 启动网关服务（HTTP + Kafka 消费）
 func (g *Gateway) Start(ctx context.Context) {
 	RegisterGateway(g)
+
+	// 从 Redis 租用雪花算法 worker ID，保证跨网关消息 ID 不冲突
+	lease, err := idgen.AcquireWorker(ctx, global.RedisDB, g.ID)
+	if err != nil {
+		zap.S().Fatal("Acquire worker id failed", zap.String("gateway", g.ID), zap.Error(err))
+	}
+	g.ids, _ = idgen.NewNode(lease.WorkerID())
+	go lease.KeepAlive(ctx, g.ids)
+	zap.L().Info("Gateway worker id leased",
+		zap.String("gateway", g.ID),
+		zap.Int64("worker", lease.WorkerID()))
+
 	r := gin.New()
 
 	r.Use(gin.Recovery())
@@ -188,52 +309,86 @@ func (g *Gateway) Start(ctx context.Context) {
 		}
 	}()
 
-	// 启动 Kafka 消费者：每个网关消费一个固定 partition
-	const totalPartitions = 3 // 必须与 Kafka topic 的 partition 数一致
-	var partition int
+	// 启动 Kafka 消费者：partition 由 Redis 成员注册表按存活网关动态分配
+	go g.StartConsumerForPartition(ctx)
+	go g.runMembership(ctx)
+}
 
-	// 可选：硬编码映射（便于调试）
-	partitionMap := map[string]int{
-		"gateway-1": 0,
-		"gateway-2": 1,
-		"gateway-3": 2,
-	}
-	if p, ok := partitionMap[g.ID]; ok {
-		partition = p
-	} else {
-		// fallback: hash 方式（适用于动态网关 ID）
-		partition = gatewayIDToPartition(g.ID, totalPartitions)
+// Leave 从成员注册表摘除本网关，其余节点在下一次续约时重新分配 partition
+func (g *Gateway) Leave() {
+	if err := LeaveCluster(g.ID); err != nil {
+		zap.S().Warn("Gateway leave cluster failed", zap.String("gateway", g.ID), zap.Error(err))
 	}
+}
 
-	zap.L().Info("Assigning Kafka partition to gateway",
-		zap.String("gateway", g.ID),
-		zap.Int("partition", partition))
+// deliverLocal 投递给用户在本网关上的所有设备，没有任何设备能接收时转入离线队列（瞬时消息直接丢弃）
+func (g *Gateway) deliverLocal(userID string, message []byte, ephemeral bool) {
+	clients := g.GetClients(userID)
+	delivered := 0
+	for _, client := range clients {
+		if client.Push(message) {
+			delivered++
+		} else {
+			// 该设备重连后通过 sync 补齐
+			zap.S().Warn("Client cannot accept message",
+				zap.String("user_id", userID),
+				zap.String("device", client.DeviceID))
+		}
+	}
+	if delivered > 0 || ephemeral {
+		zap.S().Debug("Message delivered locally",
+			zap.String("user_id", userID),
+			zap.String("gateway", g.ID),
+			zap.Int("devices", delivered))
+		return
+	}
 
-	go g.StartConsumerForPartition(ctx)
+	if len(clients) == 0 {
+		// Redis 认为在线，但本地没找到：状态不一致（可能刚断开）
+		zap.S().Warn("User marked online in Redis but not found in gateway",
+			zap.String("user_id", userID),
+			zap.String("gateway", g.ID))
+	}
+	if err := saveOfflineMessage(userID, message); err != nil {
+		zap.S().Error("Failed to save offline message",
+			zap.String("to", userID),
+			zap.Error(err))
+	}
 }
 
 func DeliverOfflineMessages(userID string, client *Client) {
+	// 先投递 @ 到自己的消息，客户端可以在拉取其余积压前先提示；优先队列没投完时普通队列下次再投
+	if deliverOfflineQueue(userID, offlinePriorityKey(userID), client) {
+		deliverOfflineQueue(userID, offlineQueueKey(userID), client)
+	}
+}
+
+// deliverOfflineQueue 投递一个离线队列，返回是否已全部投递
+func deliverOfflineQueue(userID, key string, client *Client) bool {
 	ctx := context.Background()
-	key := fmt.Sprintf("offline:messages:%s", userID)
 
 	// 获取所有离线消息
 	values, err := global.RedisDB.LRange(ctx, key, 0, -1).Result()
-	if err != nil || len(values) == 0 {
-		return
+	if err != nil {
+		return false
+	}
+	if len(values) == 0 {
+		return true
 	}
 
-	// 逆序发送（最新的在后面？根据业务定），也可以正序
+	// 按入队顺序投递，投递后进入待确认窗口；窗口或缓冲区满时剩余消息留在队列中
+	delivered := 0
 	for _, msgData := range values {
-		select {
-		case client.Send <- []byte(msgData):
-			zap.S().Debug("Delivered offline message", zap.String("user", userID))
-		default:
-			zap.S().Warn("Client offline buffer full during delivery", zap.String("user", userID))
-			// 可记录未送达，或断开连接``
-			continue
+		if !client.Push([]byte(msgData)) {
+			zap.S().Warn("Client buffer full during offline delivery",
+				zap.String("user", userID),
+				zap.Int("remaining", len(values)-delivered))
+			break
 		}
+		delivered++
 	}
 
-	// 全部投递成功后清除离线消息
-	global.RedisDB.Del(ctx, key)
+	// 只裁掉已投递的部分，保留剩余消息及投递期间新入队的消息
+	global.RedisDB.LTrim(ctx, key, int64(delivered), -1)
+	return delivered == len(values)
 }
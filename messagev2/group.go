@@ -0,0 +1,102 @@
+package messagev2
+
+import (
+	"HiChat/dao"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// displayName 系统通知中展示的用户名，查不到时使用 ID
+func displayName(userID uint) string {
+	if user, err := dao.FindUserID(userID); err == nil && user.Name != "" {
+		return user.Name
+	}
+	return strconv.FormatUint(uint64(userID), 10)
+}
+
+// groupSnapshot 操作前的群成员，系统通知据此投递，保证被移出的成员也能收到；
+// 系统通知会刷新收件人的会话列表，离开群的成员要在通知之后再从会话列表中移除
+func groupSnapshot(groupID string) []string {
+	members, err := ConversationMembers(GetGroupConvID(groupID))
+	if err != nil {
+		zap.S().Warn("Load group members failed", zap.String("group", groupID), zap.Error(err))
+	}
+	return members
+}
+
+// notifyGroup 发送群系统通知，失败只记录日志，不影响已完成的操作
+func (g *Gateway) notifyGroup(operator, groupID, content string, recipients []string) {
+	if len(recipients) == 0 {
+		return
+	}
+	if err := g.SendGroupSystemMessage(operator, groupID, content, recipients); err != nil {
+		zap.S().Warn("Send group system message failed", zap.String("group", groupID), zap.Error(err))
+	}
+}
+
+// LeaveGroup 退出群聊并通知群成员
+func (g *Gateway) LeaveGroup(userID, groupID string) error {
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(groupID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	recipients := groupSnapshot(groupID)
+	if err := dao.LeaveGroup(uint(gid), uint(uid)); err != nil {
+		return err
+	}
+	g.notifyGroup(userID, groupID, fmt.Sprintf("%s 退出了群聊", displayName(uint(uid))), recipients)
+	removeConversation(GetGroupConvID(groupID), userID)
+	return nil
+}
+
+// KickMember 群主或管理员移出成员并通知群成员（含被移出的人）
+func (g *Gateway) KickMember(operator, groupID, target string) error {
+	opID, err := strconv.ParseUint(operator, 10, 64)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(groupID, 10, 64)
+	if err != nil {
+		return err
+	}
+	targetID, err := strconv.ParseUint(target, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	recipients := groupSnapshot(groupID)
+	if err := dao.KickGroupMember(uint(gid), uint(opID), uint(targetID)); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("%s 将 %s 移出了群聊", displayName(uint(opID)), displayName(uint(targetID)))
+	g.notifyGroup(operator, groupID, content, recipients)
+	removeConversation(GetGroupConvID(groupID), target)
+	return nil
+}
+
+// DissolveGroup 群主解散群并通知所有成员
+func (g *Gateway) DissolveGroup(operator, groupID string) error {
+	opID, err := strconv.ParseUint(operator, 10, 64)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(groupID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	recipients := groupSnapshot(groupID)
+	if err := dao.DissolveGroup(uint(gid), uint(opID)); err != nil {
+		return err
+	}
+	g.notifyGroup(operator, groupID, fmt.Sprintf("%s 解散了群聊", displayName(uint(opID))), recipients)
+	removeConversation(GetGroupConvID(groupID), recipients...)
+	return nil
+}
@@ -4,49 +4,52 @@ import (
 	"HiChat/global"
 	"context"
 	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
 
-	"fmt"
-
+	"github.com/go-redis/redis/v8"
 	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
 )
 
-// GatewayIDToPartition 返回网关对应的 partition（确定性映射）
-func GatewayIDToPartition(gatewayID string) (int, error) {
-	switch gatewayID {
-	case "gateway-1":
-		return 0, nil
-	case "gateway-2":
-		return 1, nil
-	case "gateway-3":
-		return 2, nil
-	default:
-		return -1, fmt.Errorf("unknown gateway ID: %s", gatewayID)
-	}
+// routeEnvelope 跨网关路由的消息外壳，携带目标网关和接收者，
+// 群消息的 To 是群 ID，不能靠 payload 判断接收者
+type routeEnvelope struct {
+	Gateway  string          `json:"gateway"`
+	UserID   string          `json:"user_id"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Control  string          `json:"control,omitempty"` // 控制指令（如踢下线），为空表示投递 Payload
+	DeviceID string          `json:"device_id,omitempty"`
+	Reason   string          `json:"reason,omitempty"`
+	// Ephemeral 瞬时消息，目标网关投递失败时不转离线
+	Ephemeral bool `json:"ephemeral,omitempty"`
 }
 
-// PartitionToGatewayID 反向映射（用于日志或校验）
-func PartitionToGatewayID(partition int) (string, error) {
-	switch partition {
-	case 0:
-		return "gateway-1", nil
-	case 1:
-		return "gateway-2", nil
-	case 2:
-		return "gateway-3", nil
-	default:
-		return "", fmt.Errorf("invalid partition: %d", partition)
-	}
+// controlKick 踢掉指定设备
+const controlKick = "kick"
+
+func ProduceMessage(targetGatewayID, userID string, payload []byte) error {
+	return ProduceControl(targetGatewayID, routeEnvelope{
+		UserID:  userID,
+		Payload: payload,
+	})
 }
 
-func ProduceMessage(targetGatewayID string, value []byte) error {
+// ProduceControl 将外壳写入目标网关的 partition
+func ProduceControl(targetGatewayID string, env routeEnvelope) error {
 	partition, err := GatewayIDToPartition(targetGatewayID)
 	if err != nil {
 		zap.S().Error("Invalid target gateway", zap.String("gateway", targetGatewayID), zap.Error(err))
 		return err
 	}
 
+	env.Gateway = targetGatewayID
+	value, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
 	msg := kafka.Message{
 		Value:     value,
 		Time:      time.Now(),
@@ -65,25 +68,117 @@ func ProduceMessage(targetGatewayID string, value []byte) error {
 	return err
 }
 
-// messagev2/consumer.go
+// GatewayOffsetsPrefix 网关在各 partition 上已处理到的位置：hash，field 为 partition，
+// value 为 "下一个 offset:提交时间（毫秒）"。读 partition 不走消费组，offset 由网关自己提交
+const GatewayOffsetsPrefix = "gateway:offsets:"
+
+const (
+	// offsetCommitInterval 消费过程中提交 offset 的最小间隔，退出时总会再提交一次
+	offsetCommitInterval = time.Second
+	// offsetResumeWindow 提交时间在此窗口内才从提交位置续读，太旧的位置会重放大量别的网关的消息
+	offsetResumeWindow = 10 * time.Minute
+)
+
+// partitionConsumer 正在运行的 partition reader
+type partitionConsumer struct {
+	stop context.CancelFunc
+	done chan struct{} // reader 提交 offset 并关闭后关闭
+}
+
+// StartConsumerForPartition 按成员注册表分配的 partition 消费，重新分配时切换 reader。
+// 旧 reader 不会立刻关闭：成员视图未刷新的生产者仍可能写入旧 partition，
+// 旧 reader 继续消费一个租约周期后提交 offset 再退出
 func (g *Gateway) StartConsumerForPartition(ctx context.Context) {
-	partition, err := GatewayIDToPartition(g.ID)
-	if err != nil {
-		zap.S().Fatal("Gateway has invalid ID", zap.String("gateway", g.ID), zap.Error(err))
+	consumers := make(map[int]*partitionConsumer)
+	current := -1
+	for {
+		select {
+		case <-ctx.Done():
+			for _, c := range consumers {
+				<-c.done
+			}
+			return
+		case partition := <-g.assigned:
+			if partition == current {
+				continue
+			}
+			if old, ok := consumers[current]; ok {
+				drainPartition(old, GatewayLeaseTTL)
+			}
+			// 切回仍在收尾的 partition 时先等旧 reader 提交，避免两个 reader 重复投递
+			if prev, ok := consumers[partition]; ok {
+				prev.stop()
+				<-prev.done
+			}
+			for p, c := range consumers {
+				select {
+				case <-c.done:
+					delete(consumers, p)
+				default:
+				}
+			}
+
+			pctx, cancel := context.WithCancel(ctx)
+			c := &partitionConsumer{stop: cancel, done: make(chan struct{})}
+			consumers[partition] = c
+			current = partition
+			go func() {
+				defer close(c.done)
+				g.consumePartition(pctx, partition, time.Now().Add(-GatewayLeaseTTL))
+			}()
+		}
 	}
+}
 
+// drainPartition 让旧 reader 再消费 grace 时长后停止
+func drainPartition(c *partitionConsumer, grace time.Duration) {
+	go func() {
+		select {
+		case <-c.done:
+		case <-time.After(grace):
+			c.stop()
+		}
+	}()
+}
+
+// consumePartition 消费指定 partition，从本网关提交的 offset 续读，
+// 没有可用的提交位置时从 since 时刻开始读
+func (g *Gateway) consumePartition(ctx context.Context, partition int, since time.Time) {
 	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:   []string{"localhost:9092"},
-		Topic:     "im.msg.route",
+		Brokers:   global.ServiceConfig.Kafka.Brokers,
+		Topic:     global.ServiceConfig.Kafka.Topic,
 		Partition: partition, // 👈 只读这个 partition
 		MinBytes:  10e3,
 		MaxBytes:  10e6,
 	})
 	defer reader.Close()
 
+	if offset, ok := g.committedOffset(partition, time.Now()); ok {
+		err := reader.SetOffset(offset)
+		if err != nil {
+			zap.S().Error("Kafka set offset failed", zap.Int("partition", partition), zap.Error(err))
+			return
+		}
+	} else if err := reader.SetOffsetAt(ctx, since); err != nil {
+		if ctx.Err() == nil {
+			zap.S().Error("Kafka set offset failed", zap.Int("partition", partition), zap.Error(err))
+		}
+		return
+	}
+
 	zap.S().Info("Kafka consumer started",
 		zap.String("gateway", g.ID),
-		zap.Int("partition", partition))
+		zap.Int("partition", partition),
+		zap.Int64("offset", reader.Offset()))
+
+	next := int64(-1)
+	var committedAt time.Time
+	defer func() {
+		// 显式提交，下次分配到该 partition 时从这里续读
+		if next >= 0 {
+			g.commitOffset(partition, next)
+		}
+	}()
 
 	for {
 		msg, err := reader.ReadMessage(ctx)
@@ -94,32 +189,79 @@ func (g *Gateway) StartConsumerForPartition(ctx context.Context) {
 			zap.S().Error("Kafka read error", zap.Error(err))
 			continue
 		}
+		next = msg.Offset + 1
+		if time.Since(committedAt) >= offsetCommitInterval {
+			g.commitOffset(partition, next)
+			committedAt = time.Now()
+		}
 
 		// 解析消息
-		var m Message
-		if err := json.Unmarshal(msg.Value, &m); err != nil {
+		var env routeEnvelope
+		if err := json.Unmarshal(msg.Value, &env); err != nil {
 			zap.S().Warn("Unmarshal failed", zap.Error(err))
 			continue
 		}
 
-		// ✅ 信任 partition 隔离：这个 partition 的所有消息都属于本网关
-		// 但仍建议做轻量校验（防御性编程）
-		expectedGateway, online, _ := GetUserGateway(m.To)
-		if !online || expectedGateway != g.ID {
-			zap.S().Warn("Message arrived but user not online or in wrong gateway",
-				zap.String("user", m.To),
-				zap.String("expected_gateway", expectedGateway),
-				zap.Bool("online", online))
-			// 可选：存离线？但通常发送方已处理
+		// partition 可能被多个网关共享（网关数多于 partition），只处理发给本网关的消息
+		if env.Gateway != g.ID {
 			continue
 		}
 
-		if client, ok := g.GetClient(m.To); ok {
-			select {
-			case client.Send <- msg.Value:
-			default:
-				zap.S().Warn("Client send buffer full", zap.String("user", m.To))
-			}
+		if env.Control == controlKick {
+			g.KickDevice(env.UserID, env.DeviceID, g.ID, env.Reason)
+			continue
 		}
+
+		// 用户已迁移或断开时 deliverLocal 会转入离线队列
+		g.deliverLocal(env.UserID, env.Payload, env.Ephemeral)
+	}
+}
+
+// commitOffset 记录本网关在 partition 上下一个要读的 offset
+func (g *Gateway) commitOffset(partition int, next int64) {
+	value := formatCommittedOffset(next, time.Now())
+	err := global.RedisDB.HSet(context.Background(), GatewayOffsetsPrefix+g.ID, strconv.Itoa(partition), value).Err()
+	if err != nil {
+		zap.S().Warn("Kafka commit offset failed",
+			zap.String("gateway", g.ID),
+			zap.Int("partition", partition),
+			zap.Int64("offset", next),
+			zap.Error(err))
+	}
+}
+
+// committedOffset 返回可续读的提交位置，没有提交或提交已过期时 ok 为 false
+func (g *Gateway) committedOffset(partition int, now time.Time) (int64, bool) {
+	v, err := global.RedisDB.HGet(context.Background(), GatewayOffsetsPrefix+g.ID, strconv.Itoa(partition)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			zap.S().Warn("Kafka load offset failed", zap.Int("partition", partition), zap.Error(err))
+		}
+		return 0, false
+	}
+	offset, at, ok := parseCommittedOffset(v)
+	if !ok || now.Sub(at) > offsetResumeWindow {
+		return 0, false
+	}
+	return offset, true
+}
+
+func formatCommittedOffset(next int64, at time.Time) string {
+	return strconv.FormatInt(next, 10) + ":" + strconv.FormatInt(at.UnixMilli(), 10)
+}
+
+func parseCommittedOffset(v string) (int64, time.Time, bool) {
+	offsetStr, atStr, found := strings.Cut(v, ":")
+	if !found {
+		return 0, time.Time{}, false
+	}
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(atStr, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
 	}
+	return offset, time.UnixMilli(ms), true
 }
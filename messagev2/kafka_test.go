@@ -0,0 +1,33 @@
+package messagev2
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommittedOffsetRoundTrip(t *testing.T) {
+	at := time.UnixMilli(1700000000123)
+	offset, got, ok := parseCommittedOffset(formatCommittedOffset(42, at))
+	if !ok || offset != 42 || !got.Equal(at) {
+		t.Fatalf("round trip = (%d, %v, %v), want (42, %v, true)", offset, got, ok, at)
+	}
+}
+
+func TestParseCommittedOffsetRejectsMalformed(t *testing.T) {
+	for _, v := range []string{"", "42", "x:1", "42:y", "-1:1"} {
+		if _, _, ok := parseCommittedOffset(v); ok {
+			t.Errorf("parseCommittedOffset(%q) ok, want rejected", v)
+		}
+	}
+}
+
+func TestDrainPartitionStopsAfterGrace(t *testing.T) {
+	stopped := make(chan struct{})
+	c := &partitionConsumer{stop: func() { close(stopped) }, done: make(chan struct{})}
+	drainPartition(c, 10*time.Millisecond)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("consumer not stopped after grace period")
+	}
+}
@@ -0,0 +1,106 @@
+package messagev2
+
+import (
+	"HiChat/dao"
+	"HiChat/global"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// MentionAll @所有人，仅群主可用
+	MentionAll = "all"
+	// MentionCountPrefix 用户被 @ 的次数：hash，field 为会话 ID，标记已读时清零
+	MentionCountPrefix = "mention:"
+)
+
+// offlineQueueKey 普通离线队列
+func offlineQueueKey(userID string) string {
+	return fmt.Sprintf("offline:messages:%s", userID)
+}
+
+// offlinePriorityKey 优先离线队列：@ 到该用户的消息，上线时先于普通队列投递
+func offlinePriorityKey(userID string) string {
+	return fmt.Sprintf("offline:priority:%s", userID)
+}
+
+// normalizeMentions 去重并校验 @ 列表：用户必须是群成员，all 只允许群主使用
+func normalizeMentions(from, groupID string, members []string, mentions []string) ([]string, error) {
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+
+	inGroup := make(map[string]bool, len(members))
+	for _, m := range members {
+		inGroup[m] = true
+	}
+
+	seen := make(map[string]bool, len(mentions))
+	result := make([]string, 0, len(mentions))
+	for _, m := range mentions {
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+
+		if m == MentionAll {
+			ownerID, err := dao.GroupOwner(groupID)
+			if err != nil {
+				return nil, err
+			}
+			if strconv.FormatUint(uint64(ownerID), 10) != from {
+				return nil, errors.New("only the group owner can mention all")
+			}
+		} else if !inGroup[m] {
+			return nil, fmt.Errorf("mentioned user %s is not in group", m)
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// isMentioned 判断 @ 列表是否包含该用户（含 all）
+func isMentioned(mentions []string, userID string) bool {
+	for _, m := range mentions {
+		if m == userID || m == MentionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// payloadMentions 判断下行消息是否 @ 了该用户，用于决定写入哪个离线队列
+func payloadMentions(payload []byte, userID string) bool {
+	var m struct {
+		Mentions []string
+	}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return false
+	}
+	return isMentioned(m.Mentions, userID)
+}
+
+// recordMentions 为被 @ 的成员累加会话内的提及计数（发送者自身除外）
+func recordMentions(from, convID string, members, mentions []string) error {
+	if len(mentions) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	pipe := global.RedisDB.Pipeline()
+	for _, memberID := range members {
+		if memberID == from || !isMentioned(mentions, memberID) {
+			continue
+		}
+		pipe.HIncrBy(ctx, MentionCountPrefix+memberID, convID, 1)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// clearMentions 用户已读会话后清空提及计数
+func clearMentions(userID, convID string) error {
+	return global.RedisDB.HDel(context.Background(), MentionCountPrefix+userID, convID).Err()
+}
@@ -0,0 +1,73 @@
+package messagev2
+
+import (
+	"HiChat/messagesave"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+	"unicode/utf8"
+)
+
+// maxEmojiLen 单个回应的最大字符数（组合 emoji 可能由多个码点构成）
+const maxEmojiLen = 16
+
+// ReactionEvent 回应变化通知，只推送给在线设备，离线用户通过历史记录中的汇总获取
+type ReactionEvent struct {
+	Type           string    `json:"type"` // 固定为 reaction
+	MsgID          string    `json:"msg_id"`
+	ConversationID string    `json:"conversation_id"`
+	UserID         string    `json:"user_id"`
+	Emoji          string    `json:"emoji"`
+	Added          bool      `json:"added"` // false 表示取消
+	Count          int       `json:"count"` // 该 emoji 当前人数
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// ReactMessage 添加（add 为 true）或取消用户对消息的表情回应
+func (g *Gateway) ReactMessage(userID, msgID, emoji string, add bool) error {
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxEmojiLen {
+		return errors.New("invalid emoji")
+	}
+
+	ctx := context.Background()
+	msg, err := messagesave.Get(ctx, msgID)
+	if err != nil {
+		return errors.New("message not found")
+	}
+	ok, err := CanAccessConversation(userID, msg.ConversationID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("not a member of this conversation")
+	}
+	if msg.Recalled {
+		return errors.New("message has been recalled")
+	}
+
+	changed, count, err := messagesave.React(ctx, msg, userID, emoji, add)
+	if err != nil || !changed {
+		return err
+	}
+
+	value, _ := json.Marshal(ReactionEvent{
+		Type:           "reaction",
+		MsgID:          msg.ID,
+		ConversationID: msg.ConversationID,
+		UserID:         userID,
+		Emoji:          emoji,
+		Added:          add,
+		Count:          count,
+		Timestamp:      time.Now(),
+	})
+
+	members, err := ConversationMembers(msg.ConversationID)
+	if err != nil {
+		return err
+	}
+	for _, memberID := range members {
+		g.sendEphemeral(memberID, value)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package messagev2
+
+import (
+	"HiChat/global"
+	"HiChat/messagesave"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// defaultRecallWindow 未配置 message.recall_window 时的撤回时限
+const defaultRecallWindow = 2 * time.Minute
+
+// RecallEvent 撤回通知
+type RecallEvent struct {
+	Type           string    `json:"type"` // 固定为 recall
+	MsgID          string    `json:"msg_id"`
+	ConversationID string    `json:"conversation_id"`
+	Seq            int64     `json:"seq"`
+	Operator       string    `json:"operator"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+func recallWindow() time.Duration {
+	if w := global.ServiceConfig.Message.RecallWindow; w > 0 {
+		return w
+	}
+	return defaultRecallWindow
+}
+
+// RecallMessage 撤回消息：只有发送者能在时间窗口内撤回
+func (g *Gateway) RecallMessage(operator, msgID string) error {
+	ctx := context.Background()
+	msg, err := messagesave.Get(ctx, msgID)
+	if err != nil {
+		return errors.New("message not found")
+	}
+	if msg.SenderID != operator {
+		return errors.New("only the sender can recall this message")
+	}
+	if msg.Recalled {
+		return nil
+	}
+	if time.Since(msg.Timestamp) > recallWindow() {
+		return errors.New("recall window has expired")
+	}
+
+	if err := messagesave.Recall(ctx, msg); err != nil {
+		return err
+	}
+
+	value, _ := json.Marshal(RecallEvent{
+		Type:           "recall",
+		MsgID:          msg.ID,
+		ConversationID: msg.ConversationID,
+		Seq:            msg.Seq,
+		Operator:       operator,
+		Timestamp:      time.Now(),
+	})
+
+	members, err := ConversationMembers(msg.ConversationID)
+	if err != nil {
+		return err
+	}
+	for _, memberID := range members {
+		// 离线队列里还没投递的原消息直接替换为撤回通知，不再额外推送
+		replaced, err := rewriteOfflineMessage(memberID, msg.ID, func([]byte) []byte { return value })
+		if err != nil {
+			zap.S().Warn("Replace offline message failed", zap.String("user", memberID), zap.Error(err))
+		}
+		if !replaced {
+			g.sendToMember(memberID, value)
+		}
+	}
+	return nil
+}
+
+// replaceQueuedScript 在同一个脚本内查找并替换离线队列中与原值完全相同的元素，返回替换的个数；
+// 读取与替换之间队列被投递或裁剪时按值匹配不会改错位置
+// KEYS: 离线队列；ARGV: 原值, 新值
+var replaceQueuedScript = redis.NewScript(`
+local n = 0
+local values = redis.call("LRANGE", KEYS[1], 0, -1)
+for i, v in ipairs(values) do
+	if v == ARGV[1] then
+		redis.call("LSET", KEYS[1], i - 1, ARGV[2])
+		n = n + 1
+	end
+end
+return n
+`)
+
+// rewriteOfflineMessage 用 rewrite 的结果替换离线队列（含优先队列）中指定 MsgID 的消息，返回是否找到
+func rewriteOfflineMessage(userID, msgID string, rewrite func(old []byte) []byte) (bool, error) {
+	ctx := context.Background()
+
+	for _, key := range []string{offlinePriorityKey(userID), offlineQueueKey(userID)} {
+		values, err := global.RedisDB.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return false, err
+		}
+		replaced := false
+		done := make(map[string]bool)
+		for _, v := range values {
+			if done[v] || payloadMsgID([]byte(v)) != msgID {
+				continue
+			}
+			done[v] = true
+			n, err := replaceQueuedScript.Run(ctx, global.RedisDB, []string{key}, v, rewrite([]byte(v))).Int()
+			if err != nil {
+				return false, err
+			}
+			// 为 0 说明读取后已被投递，由调用方按在线处理
+			replaced = replaced || n > 0
+		}
+		if replaced {
+			return true, nil
+		}
+	}
+	return false, nil
+}
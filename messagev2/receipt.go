@@ -0,0 +1,182 @@
+package messagev2
+
+import (
+	"HiChat/global"
+	"HiChat/messagesave"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// ReadStatePrefix 用户已读位置：hash，field 为会话 ID，value 为已读到的 seq
+const ReadStatePrefix = "read:"
+
+// advanceReadScript 已读位置只前进不后退，返回是否有更新
+var advanceReadScript = redis.NewScript(`
+local cur = tonumber(redis.call("HGET", KEYS[1], ARGV[1]) or "0")
+if tonumber(ARGV[2]) > cur then
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// ReadEvent 已读回执，推送给会话其他成员及本人其他设备
+type ReadEvent struct {
+	Type           string `json:"type"` // 固定为 read
+	ConversationID string `json:"conversation_id"`
+	UserID         string `json:"user_id"`
+	Seq            int64  `json:"seq"`
+}
+
+// UnreadCount 单个会话的未读数
+type UnreadCount struct {
+	ConversationID string `json:"conversation_id"`
+	LastSeq        int64  `json:"last_seq"`
+	ReadSeq        int64  `json:"read_seq"`
+	Unread         int64  `json:"unread"`
+	Mentions       int64  `json:"mentions"` // 未读消息中 @ 自己的次数
+}
+
+// advanceRead 推进用户在会话中的已读位置
+func advanceRead(userID, convID string, seq int64) (bool, error) {
+	ctx := context.Background()
+	n, err := advanceReadScript.Run(ctx, global.RedisDB, []string{ReadStatePrefix + userID}, convID, seq).Int()
+	return n == 1, err
+}
+
+// MarkRead 记录已读位置并通知会话成员；msgID 非空时以该消息的 seq 为准
+func (g *Gateway) MarkRead(userID, convID string, seq int64, msgID string) error {
+	ok, err := CanAccessConversation(userID, convID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("not a member of this conversation")
+	}
+
+	if msgID != "" {
+		msg, err := messagesave.Get(context.Background(), msgID)
+		if err != nil {
+			return err
+		}
+		if msg.ConversationID != convID {
+			return errors.New("message does not belong to this conversation")
+		}
+		seq = msg.Seq
+	}
+	latest, err := messagesave.LatestSeqs(context.Background(), []string{convID})
+	if err != nil {
+		return err
+	}
+	seq, err = clampReadSeq(seq, latest[convID])
+	if err != nil {
+		return err
+	}
+
+	advanced, err := advanceRead(userID, convID, seq)
+	if err != nil || !advanced {
+		return err
+	}
+	if err := clearMentions(userID, convID); err != nil {
+		zap.S().Warn("Clear mentions failed", zap.String("user", userID), zap.String("conv", convID), zap.Error(err))
+	}
+
+	members, err := ConversationMembers(convID)
+	if err != nil {
+		return err
+	}
+	value, _ := json.Marshal(ReadEvent{
+		Type:           "read",
+		ConversationID: convID,
+		UserID:         userID,
+		Seq:            seq,
+	})
+	for _, memberID := range members {
+		g.sendToMember(memberID, value)
+	}
+	return nil
+}
+
+// clampReadSeq 校验客户端上报的已读位置：必须为正，超过会话最新 seq 的按最新 seq 处理
+func clampReadSeq(seq, latest int64) (int64, error) {
+	if seq <= 0 {
+		return 0, errors.New("invalid seq")
+	}
+	if seq > latest {
+		seq = latest
+	}
+	if seq <= 0 {
+		return 0, errors.New("conversation has no messages")
+	}
+	return seq, nil
+}
+
+// UnreadCounts 返回用户所有会话的未读数。
+// 发送消息时会把发送者的已读位置推进到该消息，因此 last_seq - read_seq 即为他人发来的未读条数
+func UnreadCounts(userID string) ([]UnreadCount, error) {
+	convIDs, err := UserConversations(userID)
+	if err != nil {
+		return nil, err
+	}
+	return unreadCounts(userID, convIDs)
+}
+
+// unreadCounts 计算指定会话的未读数，结果与 convIDs 顺序一致
+func unreadCounts(userID string, convIDs []string) ([]UnreadCount, error) {
+	if len(convIDs) == 0 {
+		return []UnreadCount{}, nil
+	}
+
+	ctx := context.Background()
+	latest, err := messagesave.LatestSeqs(ctx, convIDs)
+	if err != nil {
+		return nil, err
+	}
+	read, err := global.RedisDB.HMGet(ctx, ReadStatePrefix+userID, convIDs...).Result()
+	if err != nil {
+		return nil, err
+	}
+	mentioned, err := global.RedisDB.HMGet(ctx, MentionCountPrefix+userID, convIDs...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]UnreadCount, 0, len(convIDs))
+	for i, convID := range convIDs {
+		var readSeq int64
+		if s, ok := read[i].(string); ok {
+			readSeq, _ = strconv.ParseInt(s, 10, 64)
+		}
+		var mentions int64
+		if s, ok := mentioned[i].(string); ok {
+			mentions, _ = strconv.ParseInt(s, 10, 64)
+		}
+		unread := latest[convID] - readSeq
+		if unread < 0 {
+			unread = 0
+		}
+		counts = append(counts, UnreadCount{
+			ConversationID: convID,
+			LastSeq:        latest[convID],
+			ReadSeq:        readSeq,
+			Unread:         unread,
+			Mentions:       mentions,
+		})
+	}
+	return counts, nil
+}
+
+// markSentRead 发送消息即视为已读到该条
+func markSentRead(userID, convID string, seq int64) {
+	if seq <= 0 {
+		return
+	}
+	if _, err := advanceRead(userID, convID, seq); err != nil {
+		zap.S().Warn("Advance sender read state failed", zap.String("user", userID), zap.Error(err))
+	}
+}
@@ -0,0 +1,24 @@
+package messagev2
+
+import "testing"
+
+func TestClampReadSeq(t *testing.T) {
+	cases := []struct {
+		seq, latest int64
+		want        int64
+		wantErr     bool
+	}{
+		{seq: 3, latest: 10, want: 3},
+		{seq: 10, latest: 10, want: 10},
+		{seq: 1 << 40, latest: 10, want: 10}, // 超前的位置按最新 seq 处理
+		{seq: 0, latest: 10, wantErr: true},
+		{seq: -5, latest: 10, wantErr: true},
+		{seq: 1, latest: 0, wantErr: true}, // 会话还没有消息
+	}
+	for _, c := range cases {
+		got, err := clampReadSeq(c.seq, c.latest)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("clampReadSeq(%d, %d) = %d, %v; want %d, err=%v", c.seq, c.latest, got, err, c.want, c.wantErr)
+		}
+	}
+}
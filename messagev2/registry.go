@@ -0,0 +1,161 @@
+package messagev2
+
+import (
+	"HiChat/global"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	// GatewayMembersKey 存活网关集合：member 为网关 ID，score 为租约到期时间（毫秒）
+	GatewayMembersKey = "gateway:members"
+	// GatewayLeaseTTL 网关租约有效期，续约周期为 TTL 的 1/3
+	GatewayLeaseTTL = 10 * time.Second
+)
+
+// membership 本进程缓存的集群成员视图，由各网关的续约协程刷新
+var membership = struct {
+	sync.RWMutex
+	members []string // 按 ID 排序的存活网关
+}{}
+
+// JoinCluster 注册（或续约）网关租约
+func JoinCluster(gatewayID string) error {
+	ctx := context.Background()
+	deadline := time.Now().Add(GatewayLeaseTTL).UnixMilli()
+	return global.RedisDB.ZAdd(ctx, GatewayMembersKey, &redis.Z{
+		Score:  float64(deadline),
+		Member: gatewayID,
+	}).Err()
+}
+
+// LeaveCluster 网关下线时主动释放租约，触发其他节点重新分配 partition
+func LeaveCluster(gatewayID string) error {
+	ctx := context.Background()
+	return global.RedisDB.ZRem(ctx, GatewayMembersKey, gatewayID).Err()
+}
+
+// LiveGateways 清理过期租约并返回当前存活的网关（按 ID 排序）
+func LiveGateways() ([]string, error) {
+	ctx := context.Background()
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	pipe := global.RedisDB.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, GatewayMembersKey, "-inf", "("+now)
+	members := pipe.ZRange(ctx, GatewayMembersKey, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	ids := members.Val()
+	sort.Strings(ids)
+
+	membership.Lock()
+	membership.members = ids
+	membership.Unlock()
+	return ids, nil
+}
+
+// isLiveGateway 判断网关是否持有有效租约；本地视图未命中时回源 Redis（新节点刚加入）
+func isLiveGateway(gatewayID string) bool {
+	if containsGateway(gatewayID) {
+		return true
+	}
+	members, err := LiveGateways()
+	if err != nil {
+		return true // Redis 异常时不做过滤，避免误判离线
+	}
+	i := sort.SearchStrings(members, gatewayID)
+	return i < len(members) && members[i] == gatewayID
+}
+
+func containsGateway(gatewayID string) bool {
+	membership.RLock()
+	defer membership.RUnlock()
+	i := sort.SearchStrings(membership.members, gatewayID)
+	return i < len(membership.members) && membership.members[i] == gatewayID
+}
+
+// assignPartition 按排序后的位置分配 partition；
+// 网关数多于 partition 时多个网关共享同一 partition，由消费端按用户所在网关过滤
+func assignPartition(members []string, gatewayID string, numPartitions int) (int, bool) {
+	for i, id := range members {
+		if id == gatewayID {
+			return i % numPartitions, true
+		}
+	}
+	return -1, false
+}
+
+// GatewayIDToPartition 根据存活成员视图返回网关当前负责的 partition
+func GatewayIDToPartition(gatewayID string) (int, error) {
+	numPartitions := global.ServiceConfig.Kafka.Partitions
+
+	membership.RLock()
+	partition, ok := assignPartition(membership.members, gatewayID, numPartitions)
+	membership.RUnlock()
+	if ok {
+		return partition, nil
+	}
+
+	// 本地视图可能落后（新节点刚加入），回源 Redis 再查一次
+	members, err := LiveGateways()
+	if err != nil {
+		return -1, err
+	}
+	if partition, ok := assignPartition(members, gatewayID, numPartitions); ok {
+		return partition, nil
+	}
+	return -1, fmt.Errorf("unknown gateway ID: %s", gatewayID)
+}
+
+// PartitionToGatewayIDs 反向映射（用于日志或校验），一个 partition 可能对应多个网关
+func PartitionToGatewayIDs(partition int) []string {
+	numPartitions := global.ServiceConfig.Kafka.Partitions
+
+	membership.RLock()
+	defer membership.RUnlock()
+
+	var ids []string
+	for i, id := range membership.members {
+		if i%numPartitions == partition {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// runMembership 周期性续约并刷新成员视图，partition 变化时通知消费者重新分配
+func (g *Gateway) runMembership(ctx context.Context) {
+	ticker := time.NewTicker(GatewayLeaseTTL / 3)
+	defer ticker.Stop()
+
+	current := -1
+	for {
+		if err := JoinCluster(g.ID); err != nil {
+			zap.S().Warn("Gateway lease renew failed", zap.String("gateway", g.ID), zap.Error(err))
+		} else if members, err := LiveGateways(); err != nil {
+			zap.S().Warn("Load gateway members failed", zap.String("gateway", g.ID), zap.Error(err))
+		} else if partition, ok := assignPartition(members, g.ID, global.ServiceConfig.Kafka.Partitions); ok && partition != current {
+			zap.L().Info("Assigning Kafka partition to gateway",
+				zap.String("gateway", g.ID),
+				zap.Int("partition", partition),
+				zap.Int("members", len(members)))
+			current = partition
+			g.assigned <- partition
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,96 +3,140 @@ package messagev2
 import (
 	"HiChat/global"
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
 )
 
 const (
-	// Redis Key 前缀
+	// Redis Key 前缀：hash，field 为设备 ID，value 为所在网关
 	UserConnPrefix = "user_conn:"
+	// Redis Key 前缀：hash，field 为设备 ID，value 为 "<platform>|<连接时间纳秒>"
+	UserDevicePrefix = "user_device:"
 	// 连接有效期（TTL），心跳周期为 TTL 的 2/3
 	ConnTTL = 30 * time.Second
 )
 
-// SetUserGateway 注册用户到指定网关节点
-func SetUserGateway(userID, gatewayID string) error {
-	key := UserConnPrefix + userID
+// DeviceSession 用户的一个在线设备
+type DeviceSession struct {
+	DeviceID    string
+	Gateway     string
+	Platform    string
+	ConnectedAt time.Time
+}
+
+// SetUserGateway 注册用户设备到指定网关节点
+func SetUserGateway(userID, deviceID, platform, gatewayID string, connectedAt time.Time) error {
 	Ctx := context.Background()
-	err := global.RedisDB.Set(Ctx, key, gatewayID, ConnTTL).Err()
+	connKey := UserConnPrefix + userID
+	devKey := UserDevicePrefix + userID
+
+	pipe := global.RedisDB.TxPipeline()
+	pipe.HSet(Ctx, connKey, deviceID, gatewayID)
+	pipe.HSet(Ctx, devKey, deviceID, fmt.Sprintf("%s|%d", platform, connectedAt.UnixNano()))
+	pipe.Expire(Ctx, connKey, ConnTTL)
+	pipe.Expire(Ctx, devKey, ConnTTL)
+	_, err := pipe.Exec(Ctx)
 	if err != nil {
-		zap.S().Info("Redis SetUserGateway failed: user=%s, gateway=%s, err=%v", userID, gatewayID, err)
-	} else {
-		zap.S().Info("User %s registered to %s (TTL: %v)", userID, gatewayID, ConnTTL)
+		zap.S().Warn("Redis SetUserGateway failed",
+			zap.String("user", userID),
+			zap.String("device", deviceID),
+			zap.String("gateway", gatewayID),
+			zap.Error(err))
 	}
 	return err
 }
 
-// GetUserGateway 查询用户当前连接的网关节点
-func GetUserGateway(userID string) (string, bool, error) {
-	key := UserConnPrefix + userID
+// GetUserGateways 查询用户所有在线设备所在的网关（device -> gateway），
+// 已失去成员租约的网关上的设备视为离线
+func GetUserGateways(userID string) (map[string]string, error) {
 	Ctx := context.Background()
-	gatewayID, err := global.RedisDB.Get(Ctx, key).Result()
+	devices, err := global.RedisDB.HGetAll(Ctx, UserConnPrefix+userID).Result()
 	if err != nil {
-		if err == redis.Nil {
-			return "", false, nil // 用户不在线
+		zap.S().Info("Redis GetUserGateways error", zap.Error(err))
+		return nil, err
+	}
+	for deviceID, gatewayID := range devices {
+		if !isLiveGateway(gatewayID) {
+			// 网关宕机未来得及清理的设备
+			delete(devices, deviceID)
+			global.RedisDB.HDel(Ctx, UserConnPrefix+userID, deviceID)
+			global.RedisDB.HDel(Ctx, UserDevicePrefix+userID, deviceID)
 		}
-		zap.S().Info("Redis GetUserGateway error: %v", err)
-		return "", false, err
 	}
-	return gatewayID, true, nil
+	return devices, nil
 }
 
-// RemoveUserGateway 用户断开时主动清除（可选）
-func RemoveUserGateway(userID string) error {
-	key := UserConnPrefix + userID
+// GetUserSessions 查询用户所有在线设备的详细信息，按连接时间升序
+func GetUserSessions(userID string) ([]DeviceSession, error) {
 	Ctx := context.Background()
-	err := global.RedisDB.Del(Ctx, key).Err()
-	if err != nil {
-		zap.S().Info("Redis RemoveUserGateway failed: %v", err)
-	} else {
-		zap.S().Info("User %s removed from Redis", userID)
+	pipe := global.RedisDB.Pipeline()
+	connCmd := pipe.HGetAll(Ctx, UserConnPrefix+userID)
+	devCmd := pipe.HGetAll(Ctx, UserDevicePrefix+userID)
+	if _, err := pipe.Exec(Ctx); err != nil {
+		return nil, err
 	}
-	return err
+
+	meta := devCmd.Val()
+	sessions := make([]DeviceSession, 0, len(connCmd.Val()))
+	for deviceID, gatewayID := range connCmd.Val() {
+		if !isLiveGateway(gatewayID) {
+			continue
+		}
+		s := DeviceSession{DeviceID: deviceID, Gateway: gatewayID}
+		if platform, since, ok := strings.Cut(meta[deviceID], "|"); ok {
+			s.Platform = platform
+			if ns, err := strconv.ParseInt(since, 10, 64); err == nil {
+				s.ConnectedAt = time.Unix(0, ns)
+			}
+		}
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+	return sessions, nil
 }
 
-// IsUserOnline 判断用户是否在线
-func IsUserOnline(userID string) (bool, error) {
-	key := UserConnPrefix + userID
+// RemoveUserGateway 设备断开时清除（仅当该设备仍登记在本网关时）
+func RemoveUserGateway(userID, deviceID, gatewayID string) error {
 	Ctx := context.Background()
-	exists, err := global.RedisDB.Exists(Ctx, key).Result()
+	connKey := UserConnPrefix + userID
+	current, err := global.RedisDB.HGet(Ctx, connKey, deviceID).Result()
+	if err != nil || current != gatewayID {
+		// 设备已在其他网关重连，不能误删
+		return nil
+	}
+
+	pipe := global.RedisDB.TxPipeline()
+	pipe.HDel(Ctx, connKey, deviceID)
+	pipe.HDel(Ctx, UserDevicePrefix+userID, deviceID)
+	_, err = pipe.Exec(Ctx)
 	if err != nil {
-		return false, err
+		zap.S().Info("Redis RemoveUserGateway failed", zap.Error(err))
 	}
-	return exists == 1, nil
+	return err
 }
 
-func StartHeartbeat(userID string, stop <-chan struct{}) {
-	ticker := time.NewTicker(ConnTTL / 2) // 每 15s 续约一次
+// RefreshUserGateway 心跳续期
+func RefreshUserGateway(userID string) error {
 	Ctx := context.Background()
-	defer ticker.Stop()
+	pipe := global.RedisDB.Pipeline()
+	pipe.Expire(Ctx, UserConnPrefix+userID, ConnTTL)
+	pipe.Expire(Ctx, UserDevicePrefix+userID, ConnTTL)
+	_, err := pipe.Exec(Ctx)
+	return err
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			// 刷新 TTL
-			err := global.RedisDB.Expire(Ctx, UserConnPrefix+userID, ConnTTL).Err()
-			if err != nil {
-				zap.S().Info("Heartbeat failed for user %s: %v", userID, err)
-				// 可尝试重连或退出
-			} else {
-				// log.Printf("Heartbeat renewed for user %s", userID)
-			}
-		case <-stop:
-			// 连接断开，停止心跳
-			zap.S().Info("Heartbeat stopped for user %s", userID)
-			if err := RemoveUserGateway(userID); err != nil {
-				zap.S().Info("Failed to remove user %s from Redis: %v", userID, err)
-			} else {
-				zap.S().Info("User %s successfully removed from Redis", userID)
-			}
-			return
-		}
+// IsUserOnline 判断用户是否有任意设备在线
+func IsUserOnline(userID string) (bool, error) {
+	devices, err := GetUserGateways(userID)
+	if err != nil {
+		return false, err
 	}
+	return len(devices) > 0, nil
 }
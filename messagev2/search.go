@@ -0,0 +1,57 @@
+package messagev2
+
+import (
+	"HiChat/messagesave"
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchMessages 在用户所属的会话中检索消息；convID 非空时只检索该会话
+func SearchMessages(userID, keyword, convID, senderID string, start, end time.Time, limit, offset int) ([]*messagesave.SearchHit, error) {
+	if keyword == "" {
+		return nil, errors.New("keyword is empty")
+	}
+
+	var convIDs []string
+	if convID != "" {
+		ok, err := CanAccessConversation(userID, convID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.New("not a member of this conversation")
+		}
+		convIDs = []string{convID}
+	} else {
+		var err error
+		if convIDs, err = UserConversations(userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	return messagesave.Search(context.Background(), messagesave.SearchQuery{
+		Keyword:         keyword,
+		ConversationIDs: convIDs,
+		SenderID:        senderID,
+		Start:           start,
+		End:             end,
+		Limit:           limit,
+		Offset:          offset,
+	})
+}
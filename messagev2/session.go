@@ -0,0 +1,72 @@
+package messagev2
+
+import (
+	"HiChat/global"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// closeKicked 被踢下线的自定义关闭码
+const closeKicked = 4001
+
+// Kick 关闭连接并告知客户端原因；ReadPump 退出后完成清理
+func (c *Client) Kick(reason string) {
+	msg := websocket.FormatCloseMessage(closeKicked, reason)
+	c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	c.Conn.Close()
+	zap.S().Info("Session kicked",
+		zap.String("user", c.UserID),
+		zap.String("device", c.DeviceID),
+		zap.String("reason", reason))
+}
+
+// KickDevice 踢掉用户的指定设备，设备可能在任意网关
+func (g *Gateway) KickDevice(userID, deviceID, gatewayID, reason string) {
+	if gatewayID == g.ID {
+		if client, ok := g.GetClient(userID, deviceID); ok {
+			client.Kick(reason)
+		}
+		return
+	}
+	if err := ProduceControl(gatewayID, routeEnvelope{
+		Control:  controlKick,
+		UserID:   userID,
+		DeviceID: deviceID,
+		Reason:   reason,
+	}); err != nil {
+		zap.S().Error("Failed to route kick via Kafka",
+			zap.String("user", userID),
+			zap.String("device", deviceID),
+			zap.String("target_gateway", gatewayID),
+			zap.Error(err))
+	}
+}
+
+// enforceDevicePolicy 同一平台的会话数超过配置上限时，踢掉最早登录的会话
+func (g *Gateway) enforceDevicePolicy(client *Client) {
+	limit := global.ServiceConfig.Session.MaxPerPlatform[client.Platform]
+	if limit <= 0 {
+		return
+	}
+
+	sessions, err := GetUserSessions(client.UserID)
+	if err != nil {
+		zap.S().Warn("Load user sessions failed", zap.String("user", client.UserID), zap.Error(err))
+		return
+	}
+
+	// sessions 按连接时间升序，新连接自身也在其中
+	var same []DeviceSession
+	for _, s := range sessions {
+		if s.Platform == client.Platform && s.DeviceID != client.DeviceID {
+			same = append(same, s)
+		}
+	}
+	for i := 0; i < len(same)+1-limit && i < len(same); i++ {
+		s := same[i]
+		g.KickDevice(client.UserID, s.DeviceID, s.Gateway, "signed in on another "+client.Platform+" device")
+		RemoveUserGateway(client.UserID, s.DeviceID, s.Gateway)
+	}
+}
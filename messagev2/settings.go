@@ -0,0 +1,141 @@
+package messagev2
+
+import (
+	"HiChat/dao"
+	"HiChat/global"
+	"HiChat/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MutedPrefix 会话中开启免打扰的成员：hash，field 为用户 ID，value 为截止时间（Unix 秒）。
+// 是 conversation_settings 的镜像，发送消息时据此标记下行消息，避免每条消息都查库
+const MutedPrefix = "conv:muted:"
+
+// ConversationSettingUpdate 会话设置的部分更新，nil 表示不修改；MutedUntil 为零值表示取消免打扰
+type ConversationSettingUpdate struct {
+	Pinned     *bool
+	MutedUntil *time.Time
+	Archived   *bool
+}
+
+// PushNotifier 离线推送（APNs、FCM 等）的扩展点，消息写入离线队列后调用
+type PushNotifier interface {
+	Notify(userID string, payload []byte)
+}
+
+var pushNotifier PushNotifier
+
+// LogPushNotifier 只记录日志的推送实现，接入真实推送服务前使用
+type LogPushNotifier struct{}
+
+func (LogPushNotifier) Notify(userID string, payload []byte) {
+	zap.S().Info("Offline push", zap.String("user", userID), zap.Int("bytes", len(payload)))
+}
+
+// SetPushNotifier 注入离线推送实现，未注入时不推送
+func SetPushNotifier(n PushNotifier) {
+	pushNotifier = n
+}
+
+// notifyOffline 离线推送，免打扰的消息跳过
+func notifyOffline(userID string, payload []byte) {
+	if pushNotifier == nil || payloadMuted(payload) {
+		return
+	}
+	pushNotifier.Notify(userID, payload)
+}
+
+// payloadMuted 判断下行消息是否带有免打扰标记
+func payloadMuted(payload []byte) bool {
+	var m struct {
+		Muted bool
+	}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return false
+	}
+	return m.Muted
+}
+
+// UpdateConversationSetting 修改用户在会话上的置顶、免打扰、归档设置
+func UpdateConversationSetting(userID, convID string, update ConversationSettingUpdate) (*models.ConversationSetting, error) {
+	ok, err := CanAccessConversation(userID, convID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("not a member of this conversation")
+	}
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := dao.ConversationSettings(uint(uid), []string{convID})
+	if err != nil {
+		return nil, err
+	}
+	setting, ok := settings[convID]
+	if !ok {
+		setting = models.ConversationSetting{OwnerId: uint(uid), ConversationID: convID}
+	}
+	if update.Pinned != nil {
+		setting.Pinned = *update.Pinned
+	}
+	if update.Archived != nil {
+		setting.Archived = *update.Archived
+	}
+	if update.MutedUntil != nil {
+		if update.MutedUntil.IsZero() {
+			setting.MutedUntil = nil
+		} else {
+			until := *update.MutedUntil
+			setting.MutedUntil = &until
+		}
+	}
+
+	if err := dao.SaveConversationSetting(&setting); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if setting.IsMuted(time.Now()) {
+		err = global.RedisDB.HSet(ctx, MutedPrefix+convID, userID, setting.MutedUntil.Unix()).Err()
+	} else {
+		err = global.RedisDB.HDel(ctx, MutedPrefix+convID, userID).Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &setting, nil
+}
+
+// mutedMembers 返回会话中当前处于免打扰的成员，顺带清理已到期的记录
+func mutedMembers(convID string, now time.Time) map[string]bool {
+	ctx := context.Background()
+	entries, err := global.RedisDB.HGetAll(ctx, MutedPrefix+convID).Result()
+	if err != nil {
+		zap.S().Warn("Load muted members failed", zap.String("conv", convID), zap.Error(err))
+		return nil
+	}
+
+	muted := make(map[string]bool, len(entries))
+	var expired []string
+	for userID, v := range entries {
+		until, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || until <= now.Unix() {
+			expired = append(expired, userID)
+			continue
+		}
+		muted[userID] = true
+	}
+	if len(expired) > 0 {
+		global.RedisDB.HDel(ctx, MutedPrefix+convID, expired...)
+	}
+	return muted
+}
@@ -0,0 +1,29 @@
+package messagev2
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type recordingNotifier struct {
+	users []string
+}
+
+func (n *recordingNotifier) Notify(userID string, payload []byte) {
+	n.users = append(n.users, userID)
+}
+
+func TestNotifyOfflineSkipsMuted(t *testing.T) {
+	n := &recordingNotifier{}
+	SetPushNotifier(n)
+	defer SetPushNotifier(nil)
+
+	normal, _ := json.Marshal(Message{MsgID: "m1", Content: "hi"})
+	muted, _ := json.Marshal(Message{MsgID: "m2", Content: "hi", Muted: true})
+	notifyOffline("1", normal)
+	notifyOffline("2", muted)
+
+	if len(n.users) != 1 || n.users[0] != "1" {
+		t.Fatalf("notified %v, want [1]", n.users)
+	}
+}
@@ -0,0 +1,179 @@
+package messagev2
+
+import (
+	"HiChat/dao"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 瞬时信号：只转发给在线设备，不落库、不进离线队列
+const (
+	SignalTypingStart    = "typing_start"
+	SignalTypingStop     = "typing_stop"
+	SignalRecordingVoice = "recording_voice"
+	SignalRecordingStop  = "recording_stop"
+)
+
+var allowedSignals = map[string]bool{
+	SignalTypingStart:    true,
+	SignalTypingStop:     true,
+	SignalRecordingVoice: true,
+	SignalRecordingStop:  true,
+}
+
+const (
+	signalRate  = 2 // 每秒补充的令牌数
+	signalBurst = 5 // 突发上限
+	// senderLimiterSweep 清理空闲令牌桶的周期
+	senderLimiterSweep = time.Minute
+)
+
+// SignalEvent 下行的瞬时信号
+type SignalEvent struct {
+	Type           string    `json:"type"` // 固定为 signal
+	Signal         string    `json:"signal"`
+	ConversationID string    `json:"conversation_id"`
+	ChatType       string    `json:"chat_type"`
+	From           string    `json:"from"`
+	To             string    `json:"to"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// rateLimiter 令牌桶
+type rateLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  float64
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{tokens: float64(burst), last: time.Now(), rate: rate, burst: float64(burst)}
+}
+
+// Allow 取一个令牌，桶空时返回 false
+func (l *rateLimiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// idleSince 桶自 since 起已补满，丢弃后重建的效果相同
+func (l *rateLimiter) idleSince(since time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last.Before(since)
+}
+
+// senderLimiter 按发送者限制信号频率，同一用户的多台设备共用一个令牌桶
+type senderLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     int
+	buckets   map[string]*rateLimiter
+	lastSweep time.Time
+}
+
+func newSenderLimiter(rate float64, burst int) *senderLimiter {
+	return &senderLimiter{rate: rate, burst: burst, buckets: make(map[string]*rateLimiter), lastSweep: time.Now()}
+}
+
+// Allow 从 userID 的令牌桶取一个令牌，顺带清理已补满的空闲桶
+func (s *senderLimiter) Allow(userID string) bool {
+	s.mu.Lock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= senderLimiterSweep {
+		refill := time.Duration(float64(s.burst) / s.rate * float64(time.Second))
+		for id, l := range s.buckets {
+			if l.idleSince(now.Add(-refill)) {
+				delete(s.buckets, id)
+			}
+		}
+		s.lastSweep = now
+	}
+	l, ok := s.buckets[userID]
+	if !ok {
+		l = newRateLimiter(s.rate, s.burst)
+		s.buckets[userID] = l
+	}
+	s.mu.Unlock()
+	return l.Allow()
+}
+
+// SendSignal 转发瞬时信号（正在输入、正在录音等）
+func (g *Gateway) SendSignal(from, to, chatType, signal string) error {
+	if !allowedSignals[signal] {
+		return errors.New("unsupported signal")
+	}
+
+	event := SignalEvent{
+		Type:      "signal",
+		Signal:    signal,
+		ChatType:  chatType,
+		From:      from,
+		To:        to,
+		Timestamp: time.Now(),
+	}
+
+	var recipients []string
+	if chatType == "group" {
+		inGroup, err := dao.IsUserInGroup(to, from)
+		if err != nil {
+			return err
+		}
+		if !inGroup {
+			return errors.New("user not in group")
+		}
+		event.ConversationID = GetGroupConvID(to)
+		members, err := ConversationMembers(event.ConversationID)
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			if m != from {
+				recipients = append(recipients, m)
+			}
+		}
+	} else {
+		event.ChatType = "private"
+		event.ConversationID = GetConversationID(from, to)
+		recipients = []string{to}
+	}
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for _, userID := range recipients {
+		g.sendEphemeral(userID, value)
+	}
+	return nil
+}
+
+// handleSignal 处理客户端信号帧，超出发送者频率限制的直接丢弃
+func (c *Client) handleSignal(g *Gateway, to, chatType, signal string) {
+	if !g.signals.Allow(c.UserID) {
+		zap.S().Debug("Signal rate limited", zap.String("user", c.UserID), zap.String("signal", signal))
+		return
+	}
+	if err := g.SendSignal(c.UserID, to, chatType, signal); err != nil {
+		zap.S().Debug("Send signal failed", zap.String("user", c.UserID), zap.Error(err))
+	}
+}
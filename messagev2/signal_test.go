@@ -0,0 +1,41 @@
+package messagev2
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSenderLimiterSharedAcrossDevices(t *testing.T) {
+	l := newSenderLimiter(0.001, signalBurst)
+
+	// 同一用户的多台设备共用突发额度
+	allowed := 0
+	for device := 0; device < 3; device++ {
+		for i := 0; i < signalBurst; i++ {
+			if l.Allow("1") {
+				allowed++
+			}
+		}
+	}
+	if allowed != signalBurst {
+		t.Fatalf("allowed %d signals across devices, want %d", allowed, signalBurst)
+	}
+	if !l.Allow("2") {
+		t.Fatal("other sender limited by user 1's bucket")
+	}
+}
+
+func TestSenderLimiterSweepsIdleBuckets(t *testing.T) {
+	l := newSenderLimiter(1000, signalBurst)
+	l.Allow("1")
+	time.Sleep(10 * time.Millisecond) // 以 1000/s 的速率早已补满
+	l.lastSweep = time.Now().Add(-senderLimiterSweep)
+	l.Allow("2")
+
+	if _, ok := l.buckets["1"]; ok {
+		t.Fatal("idle bucket not swept")
+	}
+	if _, ok := l.buckets["2"]; !ok {
+		t.Fatal("active bucket missing")
+	}
+}
@@ -0,0 +1,94 @@
+package messagev2
+
+import (
+	"HiChat/messagesave"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSyncLimit = 100
+	maxSyncLimit     = 500
+)
+
+// SyncFrame sync 请求的下行结果
+type SyncFrame struct {
+	Type           string                     `json:"type"` // 固定为 sync
+	ConversationID string                     `json:"conversation_id"`
+	Messages       []*messagesave.MessageView `json:"messages"`
+	HasMore        bool                       `json:"has_more"`
+	Error          string                     `json:"error,omitempty"`
+}
+
+// SyncConversation 校验会话归属后返回 afterSeq 之后的消息
+func SyncConversation(userID, convID string, afterSeq int64, limit int) ([]*messagesave.MessageView, bool, error) {
+	ok, err := CanAccessConversation(userID, convID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return nil, false, errors.New("not a member of this conversation")
+	}
+
+	if limit <= 0 {
+		limit = defaultSyncLimit
+	}
+	if limit > maxSyncLimit {
+		limit = maxSyncLimit
+	}
+
+	msgs, hasMore, err := messagesave.SyncAfter(context.Background(), convID, afterSeq, limit)
+	if err != nil {
+		return nil, false, err
+	}
+	return messagesave.Views(msgs), hasMore, nil
+}
+
+// handleSync 处理客户端 sync 帧，结果直接写回当前连接
+func (c *Client) handleSync(convID string, afterSeq int64, limit int) {
+	frame := SyncFrame{Type: "sync", ConversationID: convID}
+
+	msgs, hasMore, err := SyncConversation(c.UserID, convID, afterSeq, limit)
+	if err != nil {
+		zap.S().Warn("Sync failed", zap.String("user", c.UserID), zap.String("conv", convID), zap.Error(err))
+		frame.Error = err.Error()
+	} else {
+		frame.Messages = msgs
+		frame.HasMore = hasMore
+	}
+
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return
+	}
+	if !c.Push(data) {
+		zap.S().Warn("Client send buffer full, drop sync result", zap.String("user", c.UserID))
+	}
+}
+
+// History 校验会话归属后按游标向前翻历史消息（新的在前）
+func History(userID, convID string, cursor int64, limit int) ([]*messagesave.MessageView, int64, bool, error) {
+	ok, err := CanAccessConversation(userID, convID)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if !ok {
+		return nil, 0, false, errors.New("not a member of this conversation")
+	}
+
+	if limit <= 0 {
+		limit = defaultSyncLimit
+	}
+	if limit > maxSyncLimit {
+		limit = maxSyncLimit
+	}
+
+	msgs, next, hasMore, err := messagesave.DefaultHistory().Before(context.Background(), convID, cursor, limit)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return messagesave.Views(msgs), next, hasMore, nil
+}
@@ -0,0 +1,55 @@
+package messagev2
+
+import (
+	"HiChat/messagesave"
+	"context"
+	"errors"
+)
+
+// resolveThread 校验被回复的消息属于同一会话，返回新消息所在话题的根消息 ID
+func resolveThread(convID, replyTo string) (string, error) {
+	if replyTo == "" {
+		return "", nil
+	}
+	parent, err := messagesave.Get(context.Background(), replyTo)
+	if err != nil {
+		return "", errors.New("reply target not found")
+	}
+	if parent.ConversationID != convID {
+		return "", errors.New("reply target belongs to another conversation")
+	}
+	if parent.ThreadRoot != "" {
+		// 回复的是话题中的某条回复，仍归入同一话题
+		return parent.ThreadRoot, nil
+	}
+	return parent.ID, nil
+}
+
+// ThreadMessages 校验会话归属后按 seq 升序返回话题中 afterSeq 之后的回复
+func ThreadMessages(userID, rootID string, afterSeq int64, limit int) ([]*messagesave.MessageView, bool, error) {
+	ctx := context.Background()
+	root, err := messagesave.Get(ctx, rootID)
+	if err != nil {
+		return nil, false, errors.New("message not found")
+	}
+	ok, err := CanAccessConversation(userID, root.ConversationID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return nil, false, errors.New("not a member of this conversation")
+	}
+
+	if limit <= 0 {
+		limit = defaultSyncLimit
+	}
+	if limit > maxSyncLimit {
+		limit = maxSyncLimit
+	}
+
+	msgs, hasMore, err := messagesave.ListThread(ctx, root, afterSeq, limit)
+	if err != nil {
+		return nil, false, err
+	}
+	return messagesave.Views(msgs), hasMore, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -74,6 +75,15 @@ func JWY() gin.HandlerFunc {
 	}
 }
 
+// TokenFromHeader 从 Authorization: Bearer <token> 中取出 token
+func TokenFromHeader(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
 // GenerateToken 根据用户的用户名和密码产生token
 func GenerateToken(userId uint, iss string) (string, error) {
 	//设置token有效时间
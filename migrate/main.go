@@ -1,6 +1,7 @@
 package main
 
 import (
+	"HiChat/messagesave"
 	"HiChat/models"
 
 	"gorm.io/driver/mysql"
@@ -20,9 +21,17 @@ func main() {
 	err = db.AutoMigrate(
 		&models.UserBasic{},
 		&models.Relation{},
+		&models.ConversationSetting{},
+		&models.FriendRequest{},
+		&models.UserPrivacy{},
+		&models.FriendTag{},
+		&models.GroupJoinRequest{},
 		&models.Message{},
 		&models.GroupInfo{},
 		&models.Community{},
+		&messagesave.Message{},
+		&messagesave.Revision{},
+		&messagesave.SearchDocument{},
 	)
 	if err != nil {
 		panic("failed to migrate database: " + err.Error())
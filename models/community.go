@@ -7,11 +7,12 @@ import (
 
 type Community struct {
 	Model
-	Name    string //群名称
-	OwnerId uint   //群拥有者
-	Type    int    //群类型
-	Image   string //头像
-	Desc    string //描述
+	Name         string //群名称
+	OwnerId      uint   //群拥有者
+	Type         int    //群类型
+	Image        string //头像
+	Desc         string //描述
+	JoinApproval bool   //加群是否需要管理员审批
 }
 
 // FindUsers 获取群成员id
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ConversationSetting 用户对某个会话的个人设置
+type ConversationSetting struct {
+	Model
+	OwnerId        uint       `gorm:"uniqueIndex:idx_owner_conv"`         //谁的设置
+	ConversationID string     `gorm:"size:64;uniqueIndex:idx_owner_conv"` //会话ID，如 user:1:2 / group:3
+	Pinned         bool       //置顶
+	MutedUntil     *time.Time //免打扰截止时间，为空表示未开启
+	Archived       bool       //归档（从会话列表隐藏）
+}
+
+// IsMuted 当前是否处于免打扰
+func (s *ConversationSetting) IsMuted(now time.Time) bool {
+	return s.MutedUntil != nil && s.MutedUntil.After(now)
+}
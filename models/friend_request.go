@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// 好友申请状态
+const (
+	FriendRequestPending  = iota // 待处理
+	FriendRequestAccepted        // 已同意
+	FriendRequestRejected        // 已拒绝
+	FriendRequestExpired         // 超时未处理
+)
+
+// FriendRequestTTL 好友申请的有效期，超时未处理视为过期
+const FriendRequestTTL = 7 * 24 * time.Hour
+
+// FriendRequest 好友申请，同意后才建立双向好友关系
+type FriendRequest struct {
+	Model
+	FromId    uint       `gorm:"index"` //申请人
+	ToId      uint       `gorm:"index"` //被申请人
+	Status    int        //申请状态：0 待处理 1 已同意 2 已拒绝 3 已过期
+	Greeting  string     `gorm:"size:255"` //验证消息
+	HandledAt *time.Time //处理时间
+}
+
+// Expired 待处理的申请是否已超过有效期
+func (r *FriendRequest) Expired(now time.Time) bool {
+	return r.Status == FriendRequestPending && now.Sub(r.CreatedAt) > FriendRequestTTL
+}
@@ -0,0 +1,9 @@
+package models
+
+// FriendTag 好友分组标签，一个好友可以有多个标签，只对 OwnerId 可见
+type FriendTag struct {
+	Model
+	OwnerId  uint   `gorm:"uniqueIndex:idx_owner_friend_tag"`         //谁的标签
+	FriendId uint   `gorm:"uniqueIndex:idx_owner_friend_tag"`         //打标签的好友
+	Tag      string `gorm:"size:32;uniqueIndex:idx_owner_friend_tag"` //标签名
+}
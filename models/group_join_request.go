@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// GroupJoinRequest 加群申请，群开启审批时由群主或管理员处理；状态取值与 FriendRequest 相同
+type GroupJoinRequest struct {
+	Model
+	GroupId   uint       `gorm:"index"` //申请加入的群
+	UserId    uint       `gorm:"index"` //申请人
+	Status    int        //申请状态：0 待处理 1 已同意 2 已拒绝
+	HandledBy uint       //处理人
+	HandledAt *time.Time //处理时间
+}
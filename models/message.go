@@ -243,7 +243,7 @@ func sendMsgAndSave(userId int64, msg []byte) {
 
 	//将聊天记录写入数据库
 	score := float64(cap(res)) + 1
-	ress, e := global.RedisDB.ZAdd(ctx, key, &redis.Z{score, msg}).Result() //jsonMsg
+	ress, e := global.RedisDB.ZAdd(ctx, key, &redis.Z{Score: score, Member: msg}).Result() //jsonMsg
 	//res, e := utils.Red.Do(ctx, "zadd", key, 1, jsonMsg).Result() //备用 后续拓展 记录完整msg
 	if e != nil {
 		fmt.Println(e)
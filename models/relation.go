@@ -1,11 +1,22 @@
 package models
 
+import "time"
+
+// 群成员角色（Relation.Type 为 2 时有效）
+const (
+	RoleMember = iota // 普通成员
+	RoleAdmin         // 管理员
+	RoleOwner         // 群主
+)
+
 type Relation struct {
 	Model
-	OwnerId  uint   //谁的关系信息
-	TargetID uint   //对应的谁
-	Type     int    //关系类型：0 1 2
-	Desc     string //描述
+	OwnerId    uint       //谁的关系信息
+	TargetID   uint       //对应的谁
+	Type       int        //关系类型：1 好友 2 群 3 拉黑（OwnerId 拉黑了 TargetID）
+	Desc       string     //描述，好友关系中为 OwnerId 给 TargetID 设置的备注名
+	Role       int        //群成员角色：0 普通成员 1 管理员 2 群主
+	MutedUntil *time.Time //群成员禁言截止时间
 }
 
 func (r *Relation) RelTableName() string {
@@ -0,0 +1,17 @@
+package models
+
+// 隐私选项的取值
+const (
+	PrivacyEveryone = iota // 所有人
+	PrivacyFriends         // 仅好友
+	PrivacyNobody          // 不允许
+)
+
+// UserPrivacy 用户隐私设置，未设置过的用户按全部允许处理
+type UserPrivacy struct {
+	Model
+	UserId      uint `gorm:"uniqueIndex"`
+	FindByName  int  //谁可以通过昵称找到我
+	FindByPhone int  //谁可以通过手机号找到我
+	GroupInvite int  //谁可以把我拉进群
+}
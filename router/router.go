@@ -3,6 +3,7 @@ package router
 import (
 	"HiChat/middlewear"
 	"HiChat/service"
+	"expvar"
 
 	"github.com/gin-gonic/gin"
 )
@@ -29,6 +30,8 @@ func Router() *gin.Engine {
 		user.DELETE("/delete", middlewear.JWY(), service.DeleteUser)
 		user.POST("/updata", middlewear.JWY(), service.UpdataUser)
 		user.GET("/ws", middlewear.JWY(), service.SendMsg)
+		user.POST("/privacy", middlewear.JWY(), service.GetPrivacy)
+		user.POST("/privacy/update", middlewear.JWY(), service.UpdatePrivacy)
 		//user.GET("/SendUserMsg", middlewear.JWY(), service.SendUserMsg)
 	}
 
@@ -43,9 +46,46 @@ func Router() *gin.Engine {
 	{
 		relation.POST("/list", service.FriendList)
 		relation.POST("/add", service.AddFriendByName)
+		relation.POST("/requests", service.FriendRequests)
+		relation.POST("/accept", service.AcceptFriendRequest)
+		relation.POST("/reject", service.RejectFriendRequest)
+		relation.POST("/delete", service.DeleteFriend)
+		relation.POST("/remark", service.SetFriendRemark)
+		relation.POST("/tags", service.SetFriendTags)
 		relation.POST("/new_group", service.NewGroup)
 		relation.POST("/group_list", service.GroupList)
 		relation.POST("/join_group", service.JoinGroup)
+		relation.POST("/invite_group", service.InviteToGroup)
+		relation.POST("/group/members", service.GroupMembers)
+		relation.POST("/group/admin", service.SetGroupAdmin)
+		relation.POST("/group/transfer", service.TransferGroupOwner)
+		relation.POST("/group/mute", service.MuteGroupMember)
+		relation.POST("/group/update", service.UpdateGroupInfo)
+		relation.POST("/group/join_requests", service.GroupJoinRequests)
+		relation.POST("/group/approve", service.ApproveGroupJoin)
+		relation.POST("/group/reject", service.RejectGroupJoin)
+		relation.POST("/group/leave", service.LeaveGroup)
+		relation.POST("/group/kick", service.KickGroupMember)
+		relation.POST("/group/dissolve", service.DissolveGroup)
+		relation.POST("/block", service.BlockUser)
+		relation.POST("/unblock", service.UnblockUser)
+		relation.POST("/block_list", service.BlockList)
+	}
+
+	//消息模块
+	message := v1.Group("message").Use(middlewear.JWY())
+	{
+		message.POST("/conversations", service.ConversationList)
+		message.POST("/settings", service.UpdateConversationSetting)
+		message.POST("/sync", service.SyncMessages)
+		message.POST("/history", service.HistoryMessages)
+		message.POST("/search", service.SearchMessages)
+		message.POST("/unread", service.UnreadCounts)
+		message.POST("/recall", service.RecallMessage)
+		message.POST("/edit", service.EditMessage)
+		message.POST("/revisions", service.MessageRevisions)
+		message.POST("/thread", service.ThreadMessages)
+		message.POST("/react", service.ReactMessage)
 	}
 
 	//聊天记录
@@ -53,3 +93,14 @@ func Router() *gin.Engine {
 
 	return router
 }
+
+// AdminRouter 内部管理接口（运行指标等），只在 admin_addr 上监听，不对外暴露
+func AdminRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	//运行指标（归档任务等）
+	router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+
+	return router
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"HiChat/dao"
+	"HiChat/messagev2"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	maxFriendTags   = 20
+	maxFriendTagLen = 32
+)
+
+// DeleteFriend 删除好友
+// @Summary 删除好友
+// @Tags 好友关系
+// @param targetId formData int true "好友ID"
+// @param clearHistory formData bool false "是否同时清空双方未归档的聊天记录"
+// @Success 200 {string} json{"code","message"}
+// @Router /relation/delete [post]
+func DeleteFriend(ctx *gin.Context) {
+	userId := ctx.Query("userId")
+	uid, _ := strconv.ParseUint(userId, 10, 64)
+	targetId, err := strconv.ParseUint(ctx.PostForm("targetId"), 10, 64)
+	if err != nil {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "targetId 参数错误",
+		})
+		return
+	}
+
+	if err := dao.DeleteFriend(uint(uid), uint(targetId)); err != nil {
+		zap.S().Info("删除好友失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if clear, _ := strconv.ParseBool(ctx.PostForm("clearHistory")); clear {
+		if err := messagev2.ClearPrivateHistory(userId, ctx.PostForm("targetId")); err != nil {
+			zap.S().Info("清空聊天记录失败", err)
+			ctx.JSON(200, gin.H{
+				"code":    -1, //  0成功   -1失败
+				"message": "已删除好友，清空聊天记录失败",
+			})
+			return
+		}
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "删除好友成功",
+	})
+}
+
+// SetFriendRemark 设置好友备注
+// @Summary 好友备注
+// @Tags 好友关系
+// @param targetId formData int true "好友ID"
+// @param remark formData string false "备注名，为空时清除"
+// @Success 200 {string} json{"code","message"}
+// @Router /relation/remark [post]
+func SetFriendRemark(ctx *gin.Context) {
+	uid, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	targetId, err := strconv.ParseUint(ctx.PostForm("targetId"), 10, 64)
+	if err != nil {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "targetId 参数错误",
+		})
+		return
+	}
+
+	if err := dao.SetFriendRemark(uint(uid), uint(targetId), strings.TrimSpace(ctx.PostForm("remark"))); err != nil {
+		zap.S().Info("设置好友备注失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+	})
+}
+
+// SetFriendTags 设置好友标签（覆盖原有标签）
+// @Summary 好友标签
+// @Tags 好友关系
+// @param targetId formData int true "好友ID"
+// @param tags formData string false "标签，逗号分隔，为空时清除"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /relation/tags [post]
+func SetFriendTags(ctx *gin.Context) {
+	uid, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	targetId, err := strconv.ParseUint(ctx.PostForm("targetId"), 10, 64)
+	if err != nil {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "targetId 参数错误",
+		})
+		return
+	}
+
+	tags := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(ctx.PostForm("tags"), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxFriendTagLen {
+			ctx.JSON(200, gin.H{
+				"code":    -1, //  0成功   -1失败
+				"message": "标签过长",
+			})
+			return
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxFriendTags {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "标签过多",
+		})
+		return
+	}
+
+	if err := dao.SetFriendTags(uint(uid), uint(targetId), tags); err != nil {
+		zap.S().Info("设置好友标签失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+		"data":    tags,
+	})
+}
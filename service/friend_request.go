@@ -0,0 +1,98 @@
+package service
+
+import (
+	"strconv"
+
+	"HiChat/dao"
+	"HiChat/messagev2"
+	"HiChat/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// notifyFriendRequest 通过网关推送好友申请通知，消息服务不可用时只记录日志
+func notifyFriendRequest(req *models.FriendRequest) {
+	gateway, ok := messagev2.DefaultGateway()
+	if !ok {
+		zap.S().Warn("Gateway unavailable, friend request notification dropped", zap.Uint("request", req.ID))
+		return
+	}
+	gateway.NotifyFriendRequest(req)
+}
+
+// FriendRequests 好友申请列表
+// @Summary 好友申请列表
+// @Tags 好友关系
+// @param direction formData string false "incoming 收到的（默认） / outgoing 发出的"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /relation/requests [post]
+func FriendRequests(ctx *gin.Context) {
+	userId, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	incoming := ctx.PostForm("direction") != "outgoing"
+
+	requests, err := dao.FriendRequests(uint(userId), incoming)
+	if err != nil {
+		zap.S().Info("获取好友申请失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "获取好友申请失败",
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+		"data":    requests,
+	})
+}
+
+// AcceptFriendRequest 同意好友申请
+// @Summary 同意好友申请
+// @Tags 好友关系
+// @param requestId formData int true "好友申请ID"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /relation/accept [post]
+func AcceptFriendRequest(ctx *gin.Context) {
+	handleFriendRequest(ctx, true)
+}
+
+// RejectFriendRequest 拒绝好友申请
+// @Summary 拒绝好友申请
+// @Tags 好友关系
+// @param requestId formData int true "好友申请ID"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /relation/reject [post]
+func RejectFriendRequest(ctx *gin.Context) {
+	handleFriendRequest(ctx, false)
+}
+
+func handleFriendRequest(ctx *gin.Context, accept bool) {
+	userId, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	requestId, err := strconv.ParseUint(ctx.PostForm("requestId"), 10, 64)
+	if err != nil {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "requestId 参数错误",
+		})
+		return
+	}
+
+	req, err := dao.HandleFriendRequest(uint(requestId), uint(userId), accept)
+	if err != nil {
+		zap.S().Info("处理好友申请失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+	notifyFriendRequest(req)
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+		"data":    req,
+	})
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"HiChat/dao"
+	"HiChat/messagev2"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// groupParams 解析当前用户、群ID以及可选的 targetId，失败时已写入响应
+func groupParams(ctx *gin.Context, needTarget bool) (userId, groupId, targetId uint, ok bool) {
+	uid, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	gid, err := strconv.ParseUint(ctx.PostForm("groupId"), 10, 64)
+	if err != nil {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "groupId 参数错误",
+		})
+		return 0, 0, 0, false
+	}
+	var tid uint64
+	if needTarget {
+		if tid, err = strconv.ParseUint(ctx.PostForm("targetId"), 10, 64); err != nil {
+			ctx.JSON(200, gin.H{
+				"code":    -1, //  0成功   -1失败
+				"message": "targetId 参数错误",
+			})
+			return 0, 0, 0, false
+		}
+	}
+	return uint(uid), uint(gid), uint(tid), true
+}
+
+// groupResult 群管理操作的统一响应
+func groupResult(ctx *gin.Context, action string, data interface{}, err error) {
+	if err != nil {
+		zap.S().Info(action+"失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+		"data":    data,
+	})
+}
+
+// GroupMembers 群成员列表（含角色）
+// @Summary 群成员列表
+// @Tags 群管理
+// @param groupId formData int true "群ID"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /relation/group/members [post]
+func GroupMembers(ctx *gin.Context) {
+	userId, groupId, _, ok := groupParams(ctx, false)
+	if !ok {
+		return
+	}
+	members, err := dao.GroupMembers(groupId, userId)
+	groupResult(ctx, "获取群成员", members, err)
+}
+
+// SetGroupAdmin 群主设置或取消管理员
+// @Summary 设置管理员
+// @Tags 群管理
+// @param groupId formData int true "群ID"
+// @param targetId formData int true "成员ID"
+// @param admin formData bool true "true 设为管理员，false 取消"
+// @Success 200 {string} json{"code","message"}
+// @Router /relation/group/admin [post]
+func SetGroupAdmin(ctx *gin.Context) {
+	userId, groupId, targetId, ok := groupParams(ctx, true)
+	if !ok {
+		return
+	}
+	admin, _ := strconv.ParseBool(ctx.PostForm("admin"))
+	groupResult(ctx, "设置管理员", nil, dao.SetGroupAdmin(groupId, userId, targetId, admin))
+}
+
+// TransferGroupOwner 群主转让群
+// @Summary 转让群主
+// @Tags 群管理
+// @param groupId formData int true "群ID"
+// @param targetId formData int true "新群主ID"
+// @Success 200 {string} json{"code","message"}
+// @Router /relation/group/transfer [post]
+func TransferGroupOwner(ctx *gin.Context) {
+	userId, groupId, targetId, ok := groupParams(ctx, true)
+	if !ok {
+		return
+	}
+	groupResult(ctx, "转让群主", nil, dao.TransferGroupOwner(groupId, userId, targetId))
+}
+
+// MuteGroupMember 禁言或解除禁言群成员
+// @Summary 群成员禁言
+// @Tags 群管理
+// @param groupId formData int true "群ID"
+// @param targetId formData int true "成员ID"
+// @param mutedUntil formData int false "禁言截止时间（Unix 秒），0 或不传为解除禁言"
+// @Success 200 {string} json{"code","message"}
+// @Router /relation/group/mute [post]
+func MuteGroupMember(ctx *gin.Context) {
+	userId, groupId, targetId, ok := groupParams(ctx, true)
+	if !ok {
+		return
+	}
+	var until *time.Time
+	if sec, _ := strconv.ParseInt(ctx.PostForm("mutedUntil"), 10, 64); sec > 0 {
+		t := time.Unix(sec, 0)
+		until = &t
+	}
+	groupResult(ctx, "禁言", nil, dao.MuteGroupMember(groupId, userId, targetId, until))
+}
+
+// UpdateGroupInfo 修改群资料，不传的字段不修改
+// @Summary 修改群资料
+// @Tags 群管理
+// @param groupId formData int true "群ID"
+// @param name formData string false "群名称"
+// @param icon formData string false "群头像"
+// @param desc formData string false "群描述"
+// @param joinApproval formData bool false "加群是否需要审批"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /relation/group/update [post]
+func UpdateGroupInfo(ctx *gin.Context) {
+	userId, groupId, _, ok := groupParams(ctx, false)
+	if !ok {
+		return
+	}
+
+	fields := make(map[string]interface{})
+	if name, ok := ctx.GetPostForm("name"); ok {
+		if name = strings.TrimSpace(name); name == "" {
+			ctx.JSON(200, gin.H{
+				"code":    -1, //  0成功   -1失败
+				"message": "群名称不能为空",
+			})
+			return
+		}
+		fields["name"] = name
+	}
+	if icon, ok := ctx.GetPostForm("icon"); ok {
+		fields["image"] = icon
+	}
+	if desc, ok := ctx.GetPostForm("desc"); ok {
+		fields["desc"] = desc
+	}
+	if v, ok := ctx.GetPostForm("joinApproval"); ok {
+		approval, err := strconv.ParseBool(v)
+		if err != nil {
+			ctx.JSON(200, gin.H{
+				"code":    -1, //  0成功   -1失败
+				"message": "joinApproval 参数错误",
+			})
+			return
+		}
+		fields["join_approval"] = approval
+	}
+
+	community, err := dao.UpdateCommunityInfo(groupId, userId, fields)
+	groupResult(ctx, "修改群资料", community, err)
+}
+
+// GroupJoinRequests 待处理的加群申请
+// @Summary 加群申请列表
+// @Tags 群管理
+// @param groupId formData int true "群ID"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /relation/group/join_requests [post]
+func GroupJoinRequests(ctx *gin.Context) {
+	userId, groupId, _, ok := groupParams(ctx, false)
+	if !ok {
+		return
+	}
+	requests, err := dao.GroupJoinRequests(groupId, userId)
+	groupResult(ctx, "获取加群申请", requests, err)
+}
+
+// ApproveGroupJoin 同意加群申请
+// @Summary 同意加群
+// @Tags 群管理
+// @param requestId formData int true "加群申请ID"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /relation/group/approve [post]
+func ApproveGroupJoin(ctx *gin.Context) {
+	handleGroupJoin(ctx, true)
+}
+
+// RejectGroupJoin 拒绝加群申请
+// @Summary 拒绝加群
+// @Tags 群管理
+// @param requestId formData int true "加群申请ID"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /relation/group/reject [post]
+func RejectGroupJoin(ctx *gin.Context) {
+	handleGroupJoin(ctx, false)
+}
+
+func handleGroupJoin(ctx *gin.Context, accept bool) {
+	userId, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	requestId, err := strconv.ParseUint(ctx.PostForm("requestId"), 10, 64)
+	if err != nil {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "requestId 参数错误",
+		})
+		return
+	}
+	req, err := dao.HandleGroupJoinRequest(uint(requestId), uint(userId), accept)
+	groupResult(ctx, "处理加群申请", req, err)
+}
+
+// LeaveGroup 退出群聊
+// @Summary 退群
+// @Tags 群管理
+// @param groupId formData int true "群ID"
+// @Success 200 {string} json{"code","message"}
+// @Router /relation/group/leave [post]
+func LeaveGroup(ctx *gin.Context) {
+	gateway, ok := messagev2.DefaultGateway()
+	if !ok {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "消息服务不可用",
+		})
+		return
+	}
+	groupResult(ctx, "退群", nil, gateway.LeaveGroup(ctx.Query("userId"), ctx.PostForm("groupId")))
+}
+
+// KickGroupMember 移出群成员（群主、管理员）
+// @Summary 踢人
+// @Tags 群管理
+// @param groupId formData int true "群ID"
+// @param targetId formData int true "成员ID"
+// @Success 200 {string} json{"code","message"}
+// @Router /relation/group/kick [post]
+func KickGroupMember(ctx *gin.Context) {
+	gateway, ok := messagev2.DefaultGateway()
+	if !ok {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "消息服务不可用",
+		})
+		return
+	}
+	groupResult(ctx, "移出群成员", nil, gateway.KickMember(ctx.Query("userId"), ctx.PostForm("groupId"), ctx.PostForm("targetId")))
+}
+
+// DissolveGroup 解散群（仅群主）
+// @Summary 解散群
+// @Tags 群管理
+// @param groupId formData int true "群ID"
+// @Success 200 {string} json{"code","message"}
+// @Router /relation/group/dissolve [post]
+func DissolveGroup(ctx *gin.Context) {
+	gateway, ok := messagev2.DefaultGateway()
+	if !ok {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "消息服务不可用",
+		})
+		return
+	}
+	groupResult(ctx, "解散群", nil, gateway.DissolveGroup(ctx.Query("userId"), ctx.PostForm("groupId")))
+}
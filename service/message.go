@@ -0,0 +1,401 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"HiChat/messagev2"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ConversationList 我的会话列表，按最后活跃时间倒序
+// @Summary 会话列表
+// @Tags 消息模块
+// @param cursor formData string false "上一页返回的 next_cursor，不传则从最新开始"
+// @param limit formData int false "条数"
+// @param archived formData bool false "true 时只返回已归档的会话"
+// @Success 200 {string} json{"code","message","data","next_cursor","has_more"}
+// @Router /message/conversations [post]
+func ConversationList(ctx *gin.Context) {
+	limit, _ := strconv.Atoi(ctx.PostForm("limit"))
+	archived, _ := strconv.ParseBool(ctx.PostForm("archived"))
+
+	items, next, hasMore, err := messagev2.ConversationList(ctx.Query("userId"), ctx.PostForm("cursor"), limit, archived)
+	if err != nil {
+		zap.S().Info("获取会话列表失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "获取会话列表失败",
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":        0, //  0成功   -1失败
+		"message":     "ok",
+		"data":        items,
+		"next_cursor": next,
+		"has_more":    hasMore,
+	})
+}
+
+// UpdateConversationSetting 修改会话的置顶、免打扰、归档设置
+// @Summary 会话设置
+// @Tags 消息模块
+// @param conversationId formData string false "会话ID，如 user:1:2 / group:3"
+// @param targetId formData string false "对端用户ID或群ID（未传会话ID时使用）"
+// @param chatType formData string false "private / group"
+// @param pinned formData bool false "是否置顶，不传则不修改"
+// @param mutedUntil formData int false "免打扰截止时间（Unix 秒），0 为取消，不传则不修改"
+// @param archived formData bool false "是否归档，不传则不修改"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /message/settings [post]
+func UpdateConversationSetting(ctx *gin.Context) {
+	userId := ctx.Query("userId")
+	convID := messagev2.ResolveConversationID(userId, ctx.PostForm("conversationId"), ctx.PostForm("targetId"), ctx.PostForm("chatType"))
+
+	var update messagev2.ConversationSettingUpdate
+	if v, ok := ctx.GetPostForm("pinned"); ok {
+		pinned, err := strconv.ParseBool(v)
+		if err != nil {
+			ctx.JSON(200, gin.H{
+				"code":    -1, //  0成功   -1失败
+				"message": "pinned 参数错误",
+			})
+			return
+		}
+		update.Pinned = &pinned
+	}
+	if v, ok := ctx.GetPostForm("archived"); ok {
+		archived, err := strconv.ParseBool(v)
+		if err != nil {
+			ctx.JSON(200, gin.H{
+				"code":    -1, //  0成功   -1失败
+				"message": "archived 参数错误",
+			})
+			return
+		}
+		update.Archived = &archived
+	}
+	if v, ok := ctx.GetPostForm("mutedUntil"); ok {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || sec < 0 {
+			ctx.JSON(200, gin.H{
+				"code":    -1, //  0成功   -1失败
+				"message": "mutedUntil 参数错误",
+			})
+			return
+		}
+		var until time.Time
+		if sec > 0 {
+			until = time.Unix(sec, 0)
+		}
+		update.MutedUntil = &until
+	}
+
+	setting, err := messagev2.UpdateConversationSetting(userId, convID, update)
+	if err != nil {
+		zap.S().Info("修改会话设置失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "修改会话设置失败",
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+		"data":    setting,
+	})
+}
+
+// SyncMessages 按 seq 补拉会话消息
+// @Summary 补拉会话消息
+// @Tags 消息模块
+// @param conversationId formData string false "会话ID，如 user:1:2 / group:3"
+// @param targetId formData string false "对端用户ID或群ID（未传会话ID时使用）"
+// @param chatType formData string false "private / group"
+// @param seq formData int false "客户端已收到的最大 seq"
+// @param limit formData int false "条数"
+// @Success 200 {string} json{"code","message","data","has_more"}
+// @Router /message/sync [post]
+func SyncMessages(ctx *gin.Context) {
+	userId := ctx.Query("userId")
+	convID := messagev2.ResolveConversationID(userId, ctx.PostForm("conversationId"), ctx.PostForm("targetId"), ctx.PostForm("chatType"))
+	seq, _ := strconv.ParseInt(ctx.PostForm("seq"), 10, 64)
+	limit, _ := strconv.Atoi(ctx.PostForm("limit"))
+
+	msgs, hasMore, err := messagev2.SyncConversation(userId, convID, seq, limit)
+	if err != nil {
+		zap.S().Info("补拉消息失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":     0, //  0成功   -1失败
+		"message":  "ok",
+		"data":     msgs,
+		"has_more": hasMore,
+	})
+}
+
+// HistoryMessages 按游标向前翻历史消息，先读缓存再读归档
+// @Summary 历史消息
+// @Tags 消息模块
+// @param conversationId formData string false "会话ID，如 user:1:2 / group:3"
+// @param targetId formData string false "对端用户ID或群ID（未传会话ID时使用）"
+// @param chatType formData string false "private / group"
+// @param cursor formData int false "上一页返回的 next_cursor，不传则从最新一条开始"
+// @param limit formData int false "条数"
+// @Success 200 {string} json{"code","message","data","next_cursor","has_more"}
+// @Router /message/history [post]
+func HistoryMessages(ctx *gin.Context) {
+	userId := ctx.Query("userId")
+	convID := messagev2.ResolveConversationID(userId, ctx.PostForm("conversationId"), ctx.PostForm("targetId"), ctx.PostForm("chatType"))
+	cursor, _ := strconv.ParseInt(ctx.PostForm("cursor"), 10, 64)
+	limit, _ := strconv.Atoi(ctx.PostForm("limit"))
+
+	msgs, next, hasMore, err := messagev2.History(userId, convID, cursor, limit)
+	if err != nil {
+		zap.S().Info("获取历史消息失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":        0, //  0成功   -1失败
+		"message":     "ok",
+		"data":        msgs,
+		"next_cursor": next,
+		"has_more":    hasMore,
+	})
+}
+
+// SearchMessages 在自己所属的会话中全文检索消息
+// @Summary 搜索消息
+// @Tags 消息模块
+// @param keyword formData string true "关键词"
+// @param conversationId formData string false "会话ID，不传则检索所有会话"
+// @param targetId formData string false "对端用户ID或群ID（未传会话ID时使用）"
+// @param chatType formData string false "private / group"
+// @param senderId formData string false "发送者ID"
+// @param start formData int false "起始时间（Unix 秒）"
+// @param end formData int false "结束时间（Unix 秒）"
+// @param limit formData int false "条数"
+// @param offset formData int false "偏移量"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /message/search [post]
+func SearchMessages(ctx *gin.Context) {
+	userId := ctx.Query("userId")
+	convID := messagev2.ResolveConversationID(userId, ctx.PostForm("conversationId"), ctx.PostForm("targetId"), ctx.PostForm("chatType"))
+
+	var start, end time.Time
+	if s, err := strconv.ParseInt(ctx.PostForm("start"), 10, 64); err == nil && s > 0 {
+		start = time.Unix(s, 0)
+	}
+	if e, err := strconv.ParseInt(ctx.PostForm("end"), 10, 64); err == nil && e > 0 {
+		end = time.Unix(e, 0)
+	}
+	limit, _ := strconv.Atoi(ctx.PostForm("limit"))
+	offset, _ := strconv.Atoi(ctx.PostForm("offset"))
+
+	hits, err := messagev2.SearchMessages(userId, ctx.PostForm("keyword"), convID, ctx.PostForm("senderId"), start, end, limit, offset)
+	if err != nil {
+		zap.S().Info("搜索消息失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+		"data":    hits,
+	})
+}
+
+// UnreadCounts 获取所有会话的未读数
+// @Summary 会话未读数
+// @Tags 消息模块
+// @Success 200 {string} json{"code","message","data"}
+// @Router /message/unread [post]
+func UnreadCounts(ctx *gin.Context) {
+	counts, err := messagev2.UnreadCounts(ctx.Query("userId"))
+	if err != nil {
+		zap.S().Info("获取未读数失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "获取未读数失败",
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+		"data":    counts,
+	})
+}
+
+// RecallMessage 撤回消息
+// @Summary 撤回消息
+// @Tags 消息模块
+// @param msgId formData string true "消息ID"
+// @Success 200 {string} json{"code","message"}
+// @Router /message/recall [post]
+func RecallMessage(ctx *gin.Context) {
+	gateway, ok := messagev2.DefaultGateway()
+	if !ok {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "消息服务不可用",
+		})
+		return
+	}
+
+	if err := gateway.RecallMessage(ctx.Query("userId"), ctx.PostForm("msgId")); err != nil {
+		zap.S().Info("撤回消息失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "撤回成功",
+	})
+}
+
+// EditMessage 编辑消息
+// @Summary 编辑消息
+// @Tags 消息模块
+// @param msgId formData string true "消息ID"
+// @param content formData string true "新内容"
+// @Success 200 {string} json{"code","message"}
+// @Router /message/edit [post]
+func EditMessage(ctx *gin.Context) {
+	gateway, ok := messagev2.DefaultGateway()
+	if !ok {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "消息服务不可用",
+		})
+		return
+	}
+
+	if err := gateway.EditMessage(ctx.Query("userId"), ctx.PostForm("msgId"), ctx.PostForm("content")); err != nil {
+		zap.S().Info("编辑消息失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "编辑成功",
+	})
+}
+
+// MessageRevisions 获取消息的编辑历史
+// @Summary 消息编辑历史
+// @Tags 消息模块
+// @param msgId formData string true "消息ID"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /message/revisions [post]
+func MessageRevisions(ctx *gin.Context) {
+	revs, err := messagev2.MessageRevisions(ctx.Query("userId"), ctx.PostForm("msgId"))
+	if err != nil {
+		zap.S().Info("获取编辑历史失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+		"data":    revs,
+	})
+}
+
+// ThreadMessages 获取话题中的回复
+// @Summary 话题回复列表
+// @Tags 消息模块
+// @param rootId formData string true "话题根消息ID"
+// @param seq formData int false "已拉取到的最大 seq"
+// @param limit formData int false "条数"
+// @Success 200 {string} json{"code","message","data","has_more"}
+// @Router /message/thread [post]
+func ThreadMessages(ctx *gin.Context) {
+	seq, _ := strconv.ParseInt(ctx.PostForm("seq"), 10, 64)
+	limit, _ := strconv.Atoi(ctx.PostForm("limit"))
+
+	msgs, hasMore, err := messagev2.ThreadMessages(ctx.Query("userId"), ctx.PostForm("rootId"), seq, limit)
+	if err != nil {
+		zap.S().Info("获取话题回复失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":     0, //  0成功   -1失败
+		"message":  "ok",
+		"data":     msgs,
+		"has_more": hasMore,
+	})
+}
+
+// ReactMessage 添加或取消表情回应
+// @Summary 表情回应
+// @Tags 消息模块
+// @param msgId formData string true "消息ID"
+// @param emoji formData string true "表情"
+// @param action formData string false "add / remove，默认 add"
+// @Success 200 {string} json{"code","message"}
+// @Router /message/react [post]
+func ReactMessage(ctx *gin.Context) {
+	gateway, ok := messagev2.DefaultGateway()
+	if !ok {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "消息服务不可用",
+		})
+		return
+	}
+
+	add := ctx.PostForm("action") != "remove"
+	if err := gateway.ReactMessage(ctx.Query("userId"), ctx.PostForm("msgId"), ctx.PostForm("emoji"), add); err != nil {
+		zap.S().Info("表情回应失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+	})
+}
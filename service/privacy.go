@@ -0,0 +1,231 @@
+package service
+
+import (
+	"strconv"
+
+	"HiChat/dao"
+	"HiChat/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// BlockUser 拉黑用户，拉黑后双方无法私聊、无法互加好友
+// @Summary 拉黑
+// @Tags 好友关系
+// @param targetId formData int true "对方用户ID"
+// @Success 200 {string} json{"code","message"}
+// @Router /relation/block [post]
+func BlockUser(ctx *gin.Context) {
+	userId, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	targetId, err := strconv.ParseUint(ctx.PostForm("targetId"), 10, 64)
+	if err != nil {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "targetId 参数错误",
+		})
+		return
+	}
+
+	if code, err := dao.BlockUser(uint(userId), uint(targetId)); err != nil {
+		zap.S().Info("拉黑失败", err)
+		switch code {
+		case -2:
+			ctx.JSON(200, gin.H{
+				"code":    -1, //  0成功   -1失败
+				"message": "不能拉黑自己",
+			})
+		default:
+			ctx.JSON(200, gin.H{
+				"code":    -1, //  0成功   -1失败
+				"message": err.Error(),
+			})
+		}
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "已拉黑",
+	})
+}
+
+// UnblockUser 取消拉黑
+// @Summary 取消拉黑
+// @Tags 好友关系
+// @param targetId formData int true "对方用户ID"
+// @Success 200 {string} json{"code","message"}
+// @Router /relation/unblock [post]
+func UnblockUser(ctx *gin.Context) {
+	userId, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	targetId, err := strconv.ParseUint(ctx.PostForm("targetId"), 10, 64)
+	if err != nil {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "targetId 参数错误",
+		})
+		return
+	}
+
+	if err := dao.UnblockUser(uint(userId), uint(targetId)); err != nil {
+		zap.S().Info("取消拉黑失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "取消拉黑失败",
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "已取消拉黑",
+	})
+}
+
+// BlockList 黑名单
+// @Summary 黑名单
+// @Tags 好友关系
+// @Success 200 {string} json{"code","message","data"}
+// @Router /relation/block_list [post]
+func BlockList(ctx *gin.Context) {
+	userId, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	users, err := dao.BlockList(uint(userId))
+	if err != nil {
+		zap.S().Info("获取黑名单失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "获取黑名单失败",
+		})
+		return
+	}
+
+	infos := make([]user, 0, len(users))
+	for _, v := range users {
+		infos = append(infos, user{
+			Name:     v.Name,
+			Avatar:   v.Avatar,
+			Gender:   v.Gender,
+			Identity: v.Identity,
+		})
+	}
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+		"data":    infos,
+	})
+}
+
+// GetPrivacy 获取隐私设置
+// @Summary 隐私设置
+// @Tags 用户模块
+// @Success 200 {string} json{"code","message","data"}
+// @Router /user/privacy [post]
+func GetPrivacy(ctx *gin.Context) {
+	userId, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	privacy, err := dao.GetPrivacy(uint(userId))
+	if err != nil {
+		zap.S().Info("获取隐私设置失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "获取隐私设置失败",
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+		"data":    privacy,
+	})
+}
+
+// UpdatePrivacy 修改隐私设置，各项取值 0 所有人 1 仅好友 2 不允许，不传则不修改
+// @Summary 修改隐私设置
+// @Tags 用户模块
+// @param findByName formData int false "谁可以通过昵称找到我"
+// @param findByPhone formData int false "谁可以通过手机号找到我"
+// @param groupInvite formData int false "谁可以把我拉进群"
+// @Success 200 {string} json{"code","message","data"}
+// @Router /user/privacy/update [post]
+func UpdatePrivacy(ctx *gin.Context) {
+	userId, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	privacy, err := dao.GetPrivacy(uint(userId))
+	if err != nil {
+		zap.S().Info("获取隐私设置失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "修改隐私设置失败",
+		})
+		return
+	}
+
+	fields := map[string]*int{
+		"findByName":  &privacy.FindByName,
+		"findByPhone": &privacy.FindByPhone,
+		"groupInvite": &privacy.GroupInvite,
+	}
+	for name, field := range fields {
+		v, ok := ctx.GetPostForm(name)
+		if !ok {
+			continue
+		}
+		policy, err := strconv.Atoi(v)
+		if err != nil || policy < models.PrivacyEveryone || policy > models.PrivacyNobody {
+			ctx.JSON(200, gin.H{
+				"code":    -1, //  0成功   -1失败
+				"message": name + " 参数错误",
+			})
+			return
+		}
+		*field = policy
+	}
+
+	if err := dao.SavePrivacy(privacy); err != nil {
+		zap.S().Info("修改隐私设置失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "修改隐私设置失败",
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "ok",
+		"data":    privacy,
+	})
+}
+
+// InviteToGroup 把用户拉进自己所在的群
+// @Summary 邀请进群
+// @Tags 好友关系
+// @param groupId formData int true "群ID"
+// @param targetId formData int true "被邀请人ID"
+// @Success 200 {string} json{"code","message"}
+// @Router /relation/invite_group [post]
+func InviteToGroup(ctx *gin.Context) {
+	userId, _ := strconv.ParseUint(ctx.Query("userId"), 10, 64)
+	groupId, err1 := strconv.ParseUint(ctx.PostForm("groupId"), 10, 64)
+	targetId, err2 := strconv.ParseUint(ctx.PostForm("targetId"), 10, 64)
+	if err1 != nil || err2 != nil {
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": "参数错误",
+		})
+		return
+	}
+
+	if _, err := dao.InviteToGroup(uint(userId), uint(targetId), uint(groupId)); err != nil {
+		zap.S().Info("邀请进群失败", err)
+		ctx.JSON(200, gin.H{
+			"code":    -1, //  0成功   -1失败
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(200, gin.H{
+		"code":    0, //  0成功   -1失败
+		"message": "邀请成功",
+	})
+}
@@ -20,9 +20,10 @@ type user struct {
 	Identity string
 }
 
+//FriendList 好友列表，返回备注和标签，tag 非空时只返回带该标签的好友
 func FriendList(ctx *gin.Context) {
 	id, _ := strconv.Atoi(ctx.Request.FormValue("userId"))
-	users, err := dao.FriendList(uint(id))
+	users, err := dao.FriendList(uint(id), ctx.PostForm("tag"))
 	if err != nil {
 		zap.S().Info("获取好友列表失败", err)
 		ctx.JSON(200, gin.H{
@@ -32,25 +33,13 @@ func FriendList(ctx *gin.Context) {
 		return
 	}
 
-	infos := make([]user, 0)
-
-	for _, v := range *users {
-		info := user{
-			Name:     v.Name,
-			Avatar:   v.Avatar,
-			Gender:   v.Gender,
-			Phone:    v.Phone,
-			Email:    v.Email,
-			Identity: v.Identity,
-		}
-		infos = append(infos, info)
-	}
-	common.RespOKList(ctx.Writer, users, len(infos))
+	common.RespOKList(ctx.Writer, users, len(users))
 }
 
-//AddFriendByName 通过加好友
+//AddFriendByName 发起好友申请，对方同意后才成为好友
 func AddFriendByName(ctx *gin.Context) {
-	user := ctx.PostForm("userId")
+	// 申请人只能是 JWY 校验过的当前用户
+	user := ctx.Query("userId")
 	userId, err := strconv.Atoi(user)
 	if err != nil {
 		zap.S().Info("类型转换失败", err)
@@ -58,24 +47,23 @@ func AddFriendByName(ctx *gin.Context) {
 	}
 
 	tar := ctx.PostForm("targetName")
-	target, err := strconv.Atoi(tar)
+	greeting := ctx.PostForm("greeting")
+	// 纯数字也按昵称或手机号查找，统一经过对方的隐私设置
+	req, code, err := dao.AddFriendByName(uint(userId), tar, greeting)
 	if err != nil {
-		code, err := dao.AddFriendByName(uint(userId), tar)
-		if err != nil {
-			HandleErr(code, ctx, err)
-			return
-		}
-
-	} else {
-		code, err := dao.AddFriend(uint(userId), uint(target))
-		if err != nil {
-			HandleErr(code, ctx, err)
-			return
-		}
+		HandleErr(code, ctx, err)
+		return
+	}
+	notifyFriendRequest(req)
+
+	message := "好友申请已发送"
+	if req.Status == models.FriendRequestAccepted {
+		message = "添加好友成功"
 	}
 	ctx.JSON(200, gin.H{
 		"code":    0, //  0成功   -1失败
-		"message": "添加好友成功",
+		"message": message,
+		"data":    req,
 	})
 }
 
@@ -198,7 +186,8 @@ func JoinGroup(ctx *gin.Context) {
 		return
 	}
 
-	user := ctx.PostForm("userId")
+	// 加群申请人只能是 JWY 校验过的当前用户
+	user := ctx.Query("userId")
 	userId, err := strconv.Atoi(user)
 	if err != nil {
 		zap.S().Info("user类型转换失败")
@@ -217,9 +206,13 @@ func JoinGroup(ctx *gin.Context) {
 		return
 	}
 
+	message := "加群成功"
+	if code == 1 {
+		message = "已提交加群申请，等待群主或管理员审批"
+	}
 	ctx.JSON(200, gin.H{
 		"code":    0, //  0成功   -1失败
-		"message": "加群成功",
+		"message": message,
 	})
 }
 